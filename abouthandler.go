@@ -165,16 +165,9 @@ func aboutHandler(w http.ResponseWriter, r *http.Request) {
 
 	res := AboutResponse{}.AsJSON()
 	w.Header().Set(contentType, applicationJSON)
-	if proxy.Dwn.AcceptEncoding.isCompatible(EncIdentity) {
-		proxy.Dwn.Resp.Body = &res
-		proxy.Dwn.Resp.ContentEncoding = EncIdentity
-	} else if proxy.Dwn.AcceptEncoding.isCompatible(EncGzip) {
-		proxy.Dwn.Resp.Body = Gzip(res)
-		proxy.Dwn.Resp.ContentEncoding = EncGzip
-	} else if proxy.Dwn.AcceptEncoding.isCompatible(EncBrotli) {
-		proxy.Dwn.Resp.Body = BrotliEncode(res)
-		proxy.Dwn.Resp.ContentEncoding = EncBrotli
-	}
+	enc := proxy.compression().negotiate(proxy.Dwn.AcceptEncoding, true, applicationJSON, len(res))
+	proxy.Dwn.Resp.Body = proxy.compression().encode(enc, res)
+	proxy.Dwn.Resp.ContentEncoding = enc
 	w.Header().Set(contentEncoding, proxy.Dwn.Resp.ContentEncoding.print())
 
 	proxy.setContentLengthHeader()
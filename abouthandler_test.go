@@ -119,7 +119,33 @@ func TestAboutHandlerAcceptEncodingBrotliSendsBrotli(t *testing.T) {
 	}
 }
 
-func TestAboutHandlerAcceptEncodingDeflateSends406AsIdentity(t *testing.T) {
+func TestAboutHandlerAcceptEncodingZstdSendsZstd(t *testing.T) {
+	Runner = mockRuntime()
+
+	server := httptest.NewServer(&AboutHttpHandler{})
+	defer server.Close()
+
+	c := &http.Client{}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set(acceptEncoding, "zstd")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotBody, _ := ioutil.ReadAll(resp.Body)
+	if c := bytes.Compare(gotBody[0:4], zstdMagicBytes); c != 0 {
+		t.Errorf("body should have zstd response magic bytes but does not: %v", gotBody[0:4])
+	}
+
+	want := "zstd"
+	got := resp.Header[contentEncoding][0]
+	if got != want {
+		t.Errorf("response does have correct Content-Encoding header, want %v, got %v", want, got)
+	}
+}
+
+func TestAboutHandlerAcceptEncodingDeflateSendsDeflate(t *testing.T) {
 	Runner = mockRuntime()
 
 	server := httptest.NewServer(&AboutHttpHandler{})
@@ -133,10 +159,36 @@ func TestAboutHandlerAcceptEncodingDeflateSends406AsIdentity(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	gotBody, _ := ioutil.ReadAll(resp.Body)
+	if dec, err := Inflate(gotBody); err != nil || !strings.Contains(string(*dec), "j8a") {
+		t.Errorf("body should inflate to about response")
+	}
+
+	want := "deflate"
+	got := resp.Header[contentEncoding][0]
+	if got != want {
+		t.Errorf("response does have correct Content-Encoding header, want %v, got %v", want, got)
+	}
+}
+
+func TestAboutHandlerAcceptEncodingCompressSends406AsIdentity(t *testing.T) {
+	Runner = mockRuntime()
+
+	server := httptest.NewServer(&AboutHttpHandler{})
+	defer server.Close()
+
+	c := &http.Client{}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set(acceptEncoding, "compress")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	gotBody, _ := ioutil.ReadAll(resp.Body)
 	stringBody := string(gotBody)
 	if !strings.Contains(stringBody, "406") {
-		t.Errorf("response should contain 406 for deflate request")
+		t.Errorf("response should contain 406 for compress request")
 	}
 
 	want := "identity"
@@ -0,0 +1,200 @@
+package j8a
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+	"github.com/simonmittag/lego/v4/challenge/dns01"
+)
+
+const http01S = "http-01"
+const dns01S = "dns-01"
+
+const rfc2136 = "rfc2136"
+const execS = "exec"
+
+const acmeDnsDefaultTTL = 120
+const acmeDnsDefaultPropagationTimeoutSeconds = 120
+const acmeDnsDefaultPollingIntervalSeconds = 2
+const acmeDnsDefaultTsigAlgorithm = dns.HmacSHA256
+const acmeDnsUpdateTimeout = time.Second * 10
+const acmeDnsExecTimeout = time.Second * 60
+
+// AcmeDnsProvider publishes and removes the TXT records for an ACME DNS-01 challenge. Implementations are
+// registered by name in acmeDnsProviders.
+type AcmeDnsProvider interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token, keyAuth string) error
+	Timeout() (timeout, interval time.Duration)
+}
+
+func (a Acme) isDns01() bool {
+	return strings.EqualFold(a.Challenge, dns01S)
+}
+
+type acmeDnsProviderFactory func(cfg AcmeDns) (AcmeDnsProvider, error)
+
+var acmeDnsProviders = map[string]acmeDnsProviderFactory{
+	rfc2136: newRfc2136Provider,
+	execS:   newExecDnsProvider,
+}
+
+func newAcmeDnsProvider(cfg AcmeDns) (AcmeDnsProvider, error) {
+	factory, ok := acmeDnsProviders[strings.ToLower(cfg.Provider)]
+	if !ok {
+		return nil, fmt.Errorf("ACME DNS provider not supported: %s", cfg.Provider)
+	}
+	return factory(cfg)
+}
+
+func acmeDnsTimeout(cfg AcmeDns) (time.Duration, time.Duration) {
+	timeout := time.Second * time.Duration(cfg.PropagationTimeoutSeconds)
+	if timeout == 0 {
+		timeout = time.Second * acmeDnsDefaultPropagationTimeoutSeconds
+	}
+	interval := time.Second * time.Duration(cfg.PollingIntervalSeconds)
+	if interval == 0 {
+		interval = time.Second * acmeDnsDefaultPollingIntervalSeconds
+	}
+	return timeout, interval
+}
+
+func acmeDnsTTL(cfg AcmeDns) int {
+	if cfg.TTL > 0 {
+		return cfg.TTL
+	}
+	return acmeDnsDefaultTTL
+}
+
+// withDefaultDnsPort appends port 53 to nameservers configured without one.
+func withDefaultDnsPort(ns string) string {
+	if _, _, err := net.SplitHostPort(ns); err != nil {
+		return net.JoinHostPort(strings.Trim(ns, "[]"), "53")
+	}
+	return ns
+}
+
+// Rfc2136Provider sends dynamic DNS updates for challenge records to an authoritative name server, see RFC2136.
+type Rfc2136Provider struct {
+	cfg AcmeDns
+}
+
+func newRfc2136Provider(cfg AcmeDns) (AcmeDnsProvider, error) {
+	if len(cfg.Nameserver) == 0 {
+		return nil, errors.New("ACME DNS provider rfc2136 needs a nameserver")
+	}
+	cfg.Nameserver = withDefaultDnsPort(cfg.Nameserver)
+	if len(cfg.TsigKey) > 0 && len(cfg.TsigAlgorithm) == 0 {
+		cfg.TsigAlgorithm = acmeDnsDefaultTsigAlgorithm
+	}
+	return &Rfc2136Provider{cfg: cfg}, nil
+}
+
+func (p *Rfc2136Provider) Present(domain, token, keyAuth string) error {
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+	if err := p.update(fqdn, value, true); err != nil {
+		return fmt.Errorf("ACME DNS provider rfc2136 unable to insert TXT record %s, cause: %v", fqdn, err)
+	}
+	log.Info().Msgf("ACME DNS provider rfc2136 inserted TXT record %s for domain %s", fqdn, domain)
+	return nil
+}
+
+func (p *Rfc2136Provider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+	if err := p.update(fqdn, value, false); err != nil {
+		return fmt.Errorf("ACME DNS provider rfc2136 unable to remove TXT record %s, cause: %v", fqdn, err)
+	}
+	log.Info().Msgf("ACME DNS provider rfc2136 removed TXT record %s for domain %s", fqdn, domain)
+	return nil
+}
+
+func (p *Rfc2136Provider) Timeout() (time.Duration, time.Duration) {
+	return acmeDnsTimeout(p.cfg)
+}
+
+func (p *Rfc2136Provider) update(fqdn string, value string, insert bool) error {
+	zone := dns.Fqdn(p.cfg.Zone)
+	if len(p.cfg.Zone) == 0 {
+		var err error
+		if zone, err = dns01.FindZoneByFqdnCustom(fqdn, []string{p.cfg.Nameserver}); err != nil {
+			return err
+		}
+	}
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(acmeDnsTTL(p.cfg))},
+		Txt: []string{value},
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	if insert {
+		//leftover records from earlier attempts confuse the ACME server, we always replace them.
+		m.RemoveRRset([]dns.RR{rr})
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+
+	c := &dns.Client{Timeout: acmeDnsUpdateTimeout}
+	if len(p.cfg.TsigKey) > 0 {
+		key := dns.Fqdn(p.cfg.TsigKey)
+		m.SetTsig(key, dns.Fqdn(p.cfg.TsigAlgorithm), 300, time.Now().Unix())
+		c.TsigSecret = map[string]string{key: p.cfg.TsigSecret}
+	}
+
+	reply, _, err := c.Exchange(m, p.cfg.Nameserver)
+	if err != nil {
+		return err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("nameserver %s replied %s", p.cfg.Nameserver, dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
+
+// ExecDnsProvider hands challenge records to an external command, i.e. a script talking to your DNS API.
+// The command is called as `command present|cleanup <fqdn> <value>` and must exit 0 on success.
+type ExecDnsProvider struct {
+	cfg AcmeDns
+}
+
+func newExecDnsProvider(cfg AcmeDns) (AcmeDnsProvider, error) {
+	if len(cfg.Command) == 0 {
+		return nil, errors.New("ACME DNS provider exec needs a command")
+	}
+	return &ExecDnsProvider{cfg: cfg}, nil
+}
+
+func (p *ExecDnsProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+	return p.run("present", domain, fqdn, value)
+}
+
+func (p *ExecDnsProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+	return p.run("cleanup", domain, fqdn, value)
+}
+
+func (p *ExecDnsProvider) Timeout() (time.Duration, time.Duration) {
+	return acmeDnsTimeout(p.cfg)
+}
+
+func (p *ExecDnsProvider) run(action string, domain string, fqdn string, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeDnsExecTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, p.cfg.Command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ACME DNS provider exec %s %s failed, cause: %v, output: %s", p.cfg.Command, action, err, strings.TrimSpace(string(out)))
+	}
+	log.Info().Msgf("ACME DNS provider exec %s %s TXT record %s for domain %s", p.cfg.Command, action, fqdn, domain)
+	return nil
+}
@@ -0,0 +1,172 @@
+package j8a
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/simonmittag/lego/v4/challenge/dns01"
+)
+
+// mockDnsUpdateServer is a local authoritative name server stand-in that records RFC2136 updates.
+type mockDnsUpdateServer struct {
+	mu      sync.Mutex
+	txt     map[string]string
+	updates int
+	srv     *dns.Server
+	addr    string
+}
+
+func (m *mockDnsUpdateServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	res := new(dns.Msg)
+	res.SetReply(r)
+
+	if r.IsTsig() != nil && w.TsigStatus() != nil {
+		res.SetRcode(r, dns.RcodeNotAuth)
+		w.WriteMsg(res)
+		return
+	}
+
+	m.mu.Lock()
+	m.updates++
+	for _, rr := range r.Ns {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		switch txt.Hdr.Class {
+		case dns.ClassINET:
+			m.txt[txt.Hdr.Name] = strings.Join(txt.Txt, "")
+		case dns.ClassANY, dns.ClassNONE:
+			delete(m.txt, txt.Hdr.Name)
+		}
+	}
+	m.mu.Unlock()
+
+	if t := r.IsTsig(); t != nil {
+		res.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+	w.WriteMsg(res)
+}
+
+func (m *mockDnsUpdateServer) record(fqdn string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.txt[fqdn]
+	return v, ok
+}
+
+func mockDnsUpdateServerWith(t *testing.T, tsig map[string]string) *mockDnsUpdateServer {
+	m := &mockDnsUpdateServer{txt: make(map[string]string)}
+	started := make(chan struct{})
+	m.srv = &dns.Server{
+		Addr:              "127.0.0.1:0",
+		Net:               "udp",
+		Handler:           m,
+		TsigSecret:        tsig,
+		NotifyStartedFunc: func() { close(started) },
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go m.srv.ListenAndServe()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("mock DNS server did not start")
+	}
+	m.addr = m.srv.PacketConn.LocalAddr().String()
+	return m
+}
+
+func TestRfc2136ProviderPresentAndCleanUp(t *testing.T) {
+	m := mockDnsUpdateServerWith(t, nil)
+	defer m.srv.Shutdown()
+
+	p, err := newAcmeDnsProvider(AcmeDns{Provider: "RFC2136", Nameserver: m.addr, Zone: "example.org"})
+	if err != nil {
+		t.Fatalf("rfc2136 provider should have been created, cause: %v", err)
+	}
+
+	fqdn, value := dns01.GetRecord("www.example.org", "keyAuth")
+	if err = p.Present("www.example.org", "token", "keyAuth"); err != nil {
+		t.Fatalf("rfc2136 provider should have inserted TXT record, cause: %v", err)
+	}
+	if v, ok := m.record(fqdn); !ok || v != value {
+		t.Errorf("TXT record %s should have value %s, but was %s", fqdn, value, v)
+	}
+
+	if err = p.CleanUp("www.example.org", "token", "keyAuth"); err != nil {
+		t.Fatalf("rfc2136 provider should have removed TXT record, cause: %v", err)
+	}
+	if _, ok := m.record(fqdn); ok {
+		t.Errorf("TXT record %s should have been removed", fqdn)
+	}
+}
+
+func TestRfc2136ProviderWithTsig(t *testing.T) {
+	secret := "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+	m := mockDnsUpdateServerWith(t, map[string]string{"j8a.": secret})
+	defer m.srv.Shutdown()
+
+	p, _ := newAcmeDnsProvider(AcmeDns{Provider: "rfc2136", Nameserver: m.addr, Zone: "example.org", TsigKey: "j8a", TsigSecret: secret})
+	if err := p.Present("example.org", "token", "keyAuth"); err != nil {
+		t.Errorf("rfc2136 provider should have inserted TXT record with TSIG, cause: %v", err)
+	}
+
+	bad, _ := newAcmeDnsProvider(AcmeDns{Provider: "rfc2136", Nameserver: m.addr, Zone: "example.org", TsigKey: "j8a", TsigSecret: "d3Jvbmc="})
+	if err := bad.Present("example.org", "token", "keyAuth"); err == nil {
+		t.Errorf("rfc2136 provider should have failed with wrong TSIG secret")
+	}
+}
+
+func TestRfc2136ProviderDefaultPort(t *testing.T) {
+	p, _ := newRfc2136Provider(AcmeDns{Nameserver: "ns1.example.org"})
+	if ns := p.(*Rfc2136Provider).cfg.Nameserver; ns != "ns1.example.org:53" {
+		t.Errorf("nameserver should default to port 53, but was %s", ns)
+	}
+}
+
+func TestExecDnsProviderPresentAndCleanUp(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "dns.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+out+"\n"), 0700)
+
+	p, err := newAcmeDnsProvider(AcmeDns{Provider: "exec", Command: script})
+	if err != nil {
+		t.Fatalf("exec provider should have been created, cause: %v", err)
+	}
+	p.Present("example.org", "token", "keyAuth")
+	p.CleanUp("example.org", "token", "keyAuth")
+
+	fqdn, value := dns01.GetRecord("example.org", "keyAuth")
+	got, _ := os.ReadFile(out)
+	want := "present " + fqdn + " " + value + "\ncleanup " + fqdn + " " + value + "\n"
+	if string(got) != want {
+		t.Errorf("exec provider should have called command with %q, but was %q", want, string(got))
+	}
+}
+
+func TestExecDnsProviderFailingCommand(t *testing.T) {
+	p, _ := newAcmeDnsProvider(AcmeDns{Provider: "exec", Command: "false"})
+	if err := p.Present("example.org", "token", "keyAuth"); err == nil {
+		t.Errorf("exec provider should have returned error for failing command")
+	}
+}
+
+func TestNewAcmeDnsProviderUnknown(t *testing.T) {
+	if _, err := newAcmeDnsProvider(AcmeDns{Provider: "carrierpigeon"}); err == nil {
+		t.Errorf("unknown ACME DNS provider should have returned error")
+	}
+}
+
+func TestAcmeDnsTimeoutDefaults(t *testing.T) {
+	timeout, interval := acmeDnsTimeout(AcmeDns{})
+	if timeout != time.Second*acmeDnsDefaultPropagationTimeoutSeconds || interval != time.Second*acmeDnsDefaultPollingIntervalSeconds {
+		t.Errorf("ACME DNS timeouts should use defaults, but were %v, %v", timeout, interval)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -19,6 +21,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/simonmittag/lego/v4/certcrypto"
 	"github.com/simonmittag/lego/v4/certificate"
+	"github.com/simonmittag/lego/v4/challenge/dns01"
 	"github.com/simonmittag/lego/v4/challenge/http01"
 	"github.com/simonmittag/lego/v4/lego"
 	"github.com/simonmittag/lego/v4/registration"
@@ -39,6 +42,14 @@ var acmeProviders = map[string]acmeProvider {
 	"let'sencryptstaging": acmeProvider{"https://acme-staging-v02.api.letsencrypt.org/directory", "LetsEncrypt Staging", "https://letsencrypt.org/repository/#let-s-encrypt-subscriber-agreement"},
 }
 
+// directoryUrl returns the custom ACME directory if configured, otherwise the endpoint of the known provider.
+func (a Acme) directoryUrl() string {
+	if len(a.DirectoryUrl) > 0 {
+		return a.DirectoryUrl
+	}
+	return acmeProviders[a.Provider].endpoint
+}
+
 type AcmeHandler struct {
 	Active   map[string]bool
 	Domains  map[string]string
@@ -117,6 +128,8 @@ func (u *AcmeUser) GetPrivateKey() crypto.PrivateKey {
 const acmeKeyFile = "tls.pk"
 const acmeCertFile = "tls.cert"
 const acmeHashFile = "confighash"
+const acmeAccountKeyFile = "account.pk"
+const ecPrivateKey = "EC PRIVATE KEY"
 
 func (runtime *Runtime) loadAcmeCertAndKeyFromCache(provider string) error {
 	var e error
@@ -157,8 +170,9 @@ func (runtime *Runtime) loadAcmeCertAndKeyFromCache(provider string) error {
 	}
 
 	if _, e3 := checkFullCertChainFromBytes(cert, key); e3 == nil {
-		runtime.Connection.Downstream.Tls.Key = string(key)
-		runtime.Connection.Downstream.Tls.Cert = string(cert)
+		if e = runtime.ReloadableCert.swap(cert, key); e != nil {
+			return e
+		}
 		log.Info().Msgf("TLS cert and key for ACME provider %s loaded from cache", provider)
 	} else {
 		//if delete doesn't work ignore this it may already be gone (partially).
@@ -182,9 +196,10 @@ func (runtime *Runtime) cacheAcmeCertAndKey(provider string) error {
 		os.Mkdir(runtime.cacheDir+"/"+provider, acmeRwx)
 	}
 
+	cert, key := runtime.ReloadableCert.keyPair()
 	e1 := ioutil.WriteFile(
 		filepath.FromSlash(Runner.cacheDir+"/"+provider+"/"+acmeKeyFile),
-		[]byte(runtime.Connection.Downstream.Tls.Key),
+		[]byte(key),
 		acmeRwx)
 	if e1 != nil {
 		return e1
@@ -192,7 +207,7 @@ func (runtime *Runtime) cacheAcmeCertAndKey(provider string) error {
 
 	e2 := ioutil.WriteFile(
 		filepath.FromSlash(Runner.cacheDir+"/"+provider+"/"+acmeCertFile),
-		[]byte(runtime.Connection.Downstream.Tls.Cert),
+		[]byte(cert),
 		acmeRwx)
 	if e2 != nil {
 		return e2
@@ -210,6 +225,44 @@ func (runtime *Runtime) cacheAcmeCertAndKey(provider string) error {
 	return e
 }
 
+// loadAcmeAccountKey reads the ACME account key for provider from the cache directory, or creates and caches
+// a new one. The bool result tells us if the key was loaded from cache, i.e. the account may already exist.
+func (runtime *Runtime) loadAcmeAccountKey(provider string) (*ecdsa.PrivateKey, bool, error) {
+	if runtime.cacheDirIsActive() {
+		keyFile := filepath.FromSlash(runtime.cacheDir + "/" + provider + "/" + acmeAccountKeyFile)
+		if data, e1 := ioutil.ReadFile(keyFile); e1 == nil {
+			if block, _ := pem.Decode(data); block != nil && block.Type == ecPrivateKey {
+				if pk, e2 := x509.ParseECPrivateKey(block.Bytes); e2 == nil {
+					log.Info().Msgf("ACME account key for provider %s loaded from cache", provider)
+					return pk, true, nil
+				}
+			}
+			log.Warn().Msgf("unable to read cached ACME account key for provider %s, creating new key", provider)
+		}
+	}
+
+	pk, e3 := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e3 != nil {
+		return nil, false, e3
+	}
+
+	if runtime.cacheDirIsActive() {
+		//it doesn't matter if this fails because dir already exists
+		os.Mkdir(runtime.cacheDir+"/"+provider, acmeRwx)
+		der, _ := x509.MarshalECPrivateKey(pk)
+		e4 := ioutil.WriteFile(
+			filepath.FromSlash(runtime.cacheDir+"/"+provider+"/"+acmeAccountKeyFile),
+			pem.EncodeToMemory(&pem.Block{Type: ecPrivateKey, Bytes: der}),
+			acmeRwx)
+		if e4 != nil {
+			log.Warn().Msgf("unable to cache ACME account key for provider %s, cause: %v", provider, e4)
+		} else {
+			log.Info().Msgf("stored new ACME account key for provider %s in cache", provider)
+		}
+	}
+	return pk, false, nil
+}
+
 func asSha256(o interface{}) string {
 	h := sha256.New()
 	h.Write([]byte(fmt.Sprintf("%v", o)))
@@ -221,7 +274,55 @@ func (runtime *Runtime) acmeProviderHashAsBytes() []byte {
 	return []byte(asSha256(runtime.Connection.Downstream.Tls.Acme))
 }
 
-func (runtime *Runtime) fetchAcmeCertAndKey(url string) error {
+func (runtime *Runtime) setDns01Provider(client *lego.Client) error {
+	cfg := runtime.Connection.Downstream.Tls.Acme.Dns
+	provider, e := newAcmeDnsProvider(cfg)
+	if e != nil {
+		return e
+	}
+
+	var opts []dns01.ChallengeOption
+	if len(cfg.Resolvers) > 0 {
+		opts = append(opts, dns01.AddRecursiveNameservers(dns01.ParseNameservers(cfg.Resolvers)))
+	}
+	log.Info().Msgf("ACME %s challenge configured with DNS provider %s", dns01S, cfg.Provider)
+	return client.Challenge.SetDNS01Provider(provider, opts...)
+}
+
+func (runtime *Runtime) setTlsAlpn01Provider(client *lego.Client) error {
+	h := runtime.AcmeTlsAlpnHandler
+	//j8a's TLS listener only starts once we have a certificate, until then challenges need a listener of their own.
+	if runtime.ReloadableCert == nil || runtime.ReloadableCert.certificate() == nil {
+		h.port = runtime.Connection.Downstream.Tls.Port
+	} else {
+		h.port = 0
+	}
+	log.Info().Msgf("ACME %s challenge configured", tlsAlpn01S)
+	return client.Challenge.SetTLSALPN01Provider(h)
+}
+
+func (runtime *Runtime) registerAcmeAccount(client *lego.Client, cached bool) (*registration.Resource, error) {
+	acme := runtime.Connection.Downstream.Tls.Acme
+	if cached {
+		if reg, e := client.Registration.ResolveAccountByKey(); e == nil {
+			log.Info().Msgf("ACME account %s resolved with cached account key", reg.URI)
+			return reg, nil
+		}
+	}
+
+	if len(acme.EabKeyId) > 0 {
+		return client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+			TermsOfServiceAgreed: true,
+			Kid:                  acme.EabKeyId,
+			HmacEncoded:          acme.EabHmacKey,
+		})
+	}
+	return client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+}
+
+// fetchAcmeCertAndKey obtains a new PEM encoded cert and key from the ACME provider at url. Callers validate before
+// they serve it.
+func (runtime *Runtime) fetchAcmeCertAndKey(url string) ([]byte, []byte, error) {
 	var e error
 
 	defer func() {
@@ -233,10 +334,11 @@ func (runtime *Runtime) fetchAcmeCertAndKey(url string) error {
 	}()
 
 	var pk *ecdsa.PrivateKey
+	var cached bool
 
-	pk, e = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pk, cached, e = runtime.loadAcmeAccountKey(runtime.Connection.Downstream.Tls.Acme.Provider)
 	if e != nil {
-		return e
+		return nil, nil, e
 	}
 
 	myUser := AcmeUser{
@@ -245,22 +347,30 @@ func (runtime *Runtime) fetchAcmeCertAndKey(url string) error {
 	}
 
 	config := lego.NewConfig(&myUser)
+	config.CADirURL = url
 	config.Certificate.KeyType = certcrypto.RSA2048
 	var client *lego.Client
 	client, e = lego.NewClient(config)
 	if e != nil {
-		return e
+		return nil, nil, e
 	}
 
-	e = client.Challenge.SetHTTP01Provider(runtime.AcmeHandler)
+	switch acme := runtime.Connection.Downstream.Tls.Acme; {
+	case acme.isDns01():
+		e = runtime.setDns01Provider(client)
+	case acme.isTlsAlpn01():
+		e = runtime.setTlsAlpn01Provider(client)
+	default:
+		e = client.Challenge.SetHTTP01Provider(runtime.AcmeHandler)
+	}
 	if e != nil {
-		return e
+		return nil, nil, e
 	}
 
-	//we always register because it's safer than to cache credentials
-	myUser.Registration, e = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	//we only cache the account key, the account itself is looked up or registered again every time.
+	myUser.Registration, e = runtime.registerAcmeAccount(client, cached)
 	if e != nil {
-		return e
+		return nil, nil, e
 	}
 
 	request := certificate.ObtainRequest{
@@ -272,13 +382,10 @@ func (runtime *Runtime) fetchAcmeCertAndKey(url string) error {
 	c, e = client.Certificate.Obtain(request)
 	if e != nil {
 		log.Warn().Msgf("ACME certificate from %s unsuccessful, cause %v", url, e)
-		return e
+		return nil, nil, e
 	}
 
-	runtime.Connection.Downstream.Tls.Cert = string(c.Certificate)
-	runtime.Connection.Downstream.Tls.Key = string(c.PrivateKey)
-
 	log.Info().Msgf("ACME certificate successfully fetched from %s", url)
 
-	return e
+	return c.Certificate, c.PrivateKey, e
 }
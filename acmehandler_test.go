@@ -1,6 +1,7 @@
 package j8a
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -141,8 +142,59 @@ func TestAcmeHandlerLifecycle(t *testing.T) {
 
 func TestAcmeHandlerFetch(t *testing.T) {
 	r := mockRuntime()
-	e1 := r.fetchAcmeCertAndKey("http://localhost")
+	_, _, e1 := r.fetchAcmeCertAndKey("http://localhost")
 	if e1 == nil {
 		t.Errorf("domain needs to include at least one dot, should have failed")
 	}
 }
+
+func TestLoadAcmeAccountKeyPersistedInCache(t *testing.T) {
+	mockRunner()
+	Runner.initCacheDir()
+	os.RemoveAll(filepath.FromSlash(Runner.cacheDir + "/testacmeaccount"))
+
+	pk1, cached1, e1 := Runner.loadAcmeAccountKey("testacmeaccount")
+	if e1 != nil || cached1 {
+		t.Errorf("first ACME account key should have been created, cached: %v, cause: %v", cached1, e1)
+	}
+
+	pk2, cached2, e2 := Runner.loadAcmeAccountKey("testacmeaccount")
+	if e2 != nil || !cached2 {
+		t.Errorf("second ACME account key should have been loaded from cache, cached: %v, cause: %v", cached2, e2)
+	}
+	if !pk1.Equal(pk2) {
+		t.Errorf("ACME account key loaded from cache should be identical to the one created")
+	}
+
+	//corrupt keys are replaced
+	ioutil.WriteFile(filepath.FromSlash(Runner.cacheDir+"/testacmeaccount/"+acmeAccountKeyFile), []byte("xxxx"), acmeRwx)
+	pk3, cached3, e3 := Runner.loadAcmeAccountKey("testacmeaccount")
+	if e3 != nil || cached3 || pk3.Equal(pk1) {
+		t.Errorf("corrupt ACME account key should have been replaced, cached: %v, cause: %v", cached3, e3)
+	}
+
+	//cleanup
+	if e4 := os.RemoveAll(filepath.FromSlash(Runner.cacheDir + "/testacmeaccount")); e4 != nil {
+		t.Errorf("unable to clean up cache after unit test, cause: %v", e4)
+	}
+}
+
+func TestLoadAcmeAccountKeyWithoutCacheDir(t *testing.T) {
+	r := mockRuntime()
+	pk, cached, e := r.loadAcmeAccountKey("testacmeaccount")
+	if e != nil || pk == nil || cached {
+		t.Errorf("ACME account key should have been created without cache dir, cached: %v, cause: %v", cached, e)
+	}
+}
+
+func TestAcmeDirectoryUrl(t *testing.T) {
+	le := Acme{Provider: "letsencryptstaging"}
+	if le.directoryUrl() != acmeProviders["letsencryptstaging"].endpoint {
+		t.Errorf("ACME directory URL should be provider endpoint, but was %s", le.directoryUrl())
+	}
+
+	custom := Acme{Provider: "letsencrypt", DirectoryUrl: "https://localhost:14000/dir"}
+	if custom.directoryUrl() != "https://localhost:14000/dir" {
+		t.Errorf("ACME directory URL should be custom URL, but was %s", custom.directoryUrl())
+	}
+}
@@ -0,0 +1,122 @@
+package j8a
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/simonmittag/lego/v4/challenge/tlsalpn01"
+)
+
+const tlsAlpn01S = "tls-alpn-01"
+
+func (a Acme) isTlsAlpn01() bool {
+	return strings.EqualFold(a.Challenge, tlsAlpn01S)
+}
+
+// AcmeTlsAlpnHandler answers ACME tls-alpn-01 challenges with self signed challenge certificates, see RFC8737.
+// Once j8a's TLS listener is up the challenge certificates are served from ReloadableCert, before that
+// the handler opens a temporary listener of its own.
+type AcmeTlsAlpnHandler struct {
+	mu    sync.Mutex
+	Certs map[string]*tls.Certificate
+	//port for the temporary listener, 0 if j8a's TLS listener is serving challenges.
+	port       int
+	standalone net.Listener
+}
+
+func NewAcmeTlsAlpnHandler() *AcmeTlsAlpnHandler {
+	return &AcmeTlsAlpnHandler{
+		Certs: make(map[string]*tls.Certificate),
+	}
+}
+
+func (a *AcmeTlsAlpnHandler) Present(domain, token, keyAuth string) error {
+	cert, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Certs[domain] = cert
+
+	if a.port > 0 && a.standalone == nil {
+		if err = a.listen(); err != nil {
+			delete(a.Certs, domain)
+			return err
+		}
+	}
+
+	log.Info().Msgf("ACME %s handler for domain %s activated, ready to serve challenge certificate for token %s.", tlsAlpn01S, domain, token)
+	return nil
+}
+
+func (a *AcmeTlsAlpnHandler) CleanUp(domain, token, keyAuth string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.Certs, domain)
+
+	if len(a.Certs) == 0 && a.standalone != nil {
+		a.standalone.Close()
+		a.standalone = nil
+	}
+
+	log.Info().Msgf("ACME %s handler for domain %s deactivated.", tlsAlpn01S, domain)
+	return nil
+}
+
+func (a *AcmeTlsAlpnHandler) isActive() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.Certs) > 0
+}
+
+func (a *AcmeTlsAlpnHandler) getCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cert, ok := a.Certs[strings.ToLower(clientHello.ServerName)]; ok {
+		log.Info().Msgf("responded to remote ACME %s challenge for domain %s", tlsAlpn01S, clientHello.ServerName)
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no ACME %s challenge certificate for domain %s", tlsAlpn01S, clientHello.ServerName)
+}
+
+// listen opens the temporary listener. It only completes handshakes, that's all the ACME server looks at.
+func (a *AcmeTlsAlpnHandler) listen() error {
+	ln, err := tls.Listen("tcp", ":"+strconv.Itoa(a.port), &tls.Config{
+		GetCertificate: a.getCertificate,
+		NextProtos:     []string{tlsalpn01.ACMETLS1Protocol},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to listen for ACME %s challenge on port %d, cause: %v", tlsAlpn01S, a.port, err)
+	}
+	a.standalone = ln
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return nil
+}
+
+func isAcmeTlsAlpnHello(clientHello *tls.ClientHelloInfo) bool {
+	for _, p := range clientHello.SupportedProtos {
+		if p == tlsalpn01.ACMETLS1Protocol {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package j8a
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/simonmittag/lego/v4/challenge/tlsalpn01"
+)
+
+func freeTcpPort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to find free port, cause: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func dialAcmeTlsAlpn(port int, domain string) (*tls.Conn, error) {
+	return tls.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port), &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+}
+
+func TestAcmeTlsAlpnHandlerStandaloneLifecycle(t *testing.T) {
+	h := NewAcmeTlsAlpnHandler()
+	h.port = freeTcpPort(t)
+
+	if err := h.Present("example.org", "token", "keyAuth"); err != nil {
+		t.Fatalf("tls-alpn-01 handler should present, cause: %v", err)
+	}
+	if !h.isActive() {
+		t.Errorf("tls-alpn-01 handler should be active after present")
+	}
+
+	conn, err := dialAcmeTlsAlpn(h.port, "example.org")
+	if err != nil {
+		t.Fatalf("tls-alpn-01 handshake should succeed, cause: %v", err)
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+
+	if state.NegotiatedProtocol != tlsalpn01.ACMETLS1Protocol {
+		t.Errorf("tls-alpn-01 handshake should negotiate %s, but was %s", tlsalpn01.ACMETLS1Protocol, state.NegotiatedProtocol)
+	}
+	if names := state.PeerCertificates[0].DNSNames; len(names) != 1 || names[0] != "example.org" {
+		t.Errorf("tls-alpn-01 challenge certificate should be for example.org, but was %v", names)
+	}
+
+	h.CleanUp("example.org", "token", "keyAuth")
+	if h.isActive() {
+		t.Errorf("tls-alpn-01 handler should not be active after cleanup")
+	}
+	if _, err = dialAcmeTlsAlpn(h.port, "example.org"); err == nil {
+		t.Errorf("tls-alpn-01 standalone listener should have been closed after cleanup")
+	}
+}
+
+func TestAcmeTlsAlpnHandlerUnknownDomain(t *testing.T) {
+	h := NewAcmeTlsAlpnHandler()
+	h.Present("example.org", "token", "keyAuth")
+
+	if _, err := h.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+		t.Errorf("tls-alpn-01 handler should not return challenge certificate for unknown domain")
+	}
+}
+
+func TestReloadableCertServesAcmeTlsAlpnChallenge(t *testing.T) {
+	mockTlsConfig()
+	Runner.AcmeTlsAlpnHandler.Present("example.org", "token", "keyAuth")
+	defer Runner.AcmeTlsAlpnHandler.CleanUp("example.org", "token", "keyAuth")
+
+	challenge, _ := Runner.ReloadableCert.GetCertificateFunc(&tls.ClientHelloInfo{
+		ServerName:      "example.org",
+		SupportedProtos: []string{tlsalpn01.ACMETLS1Protocol},
+	})
+	if challenge != Runner.AcmeTlsAlpnHandler.Certs["example.org"] {
+		t.Errorf("TLS listener should serve tls-alpn-01 challenge certificate for %s hello", tlsalpn01.ACMETLS1Protocol)
+	}
+
+	regular, _ := Runner.ReloadableCert.GetCertificateFunc(&tls.ClientHelloInfo{
+		ServerName:      "example.org",
+		SupportedProtos: []string{"h2", "http/1.1"},
+	})
+	if regular != Runner.ReloadableCert.Cert {
+		t.Errorf("TLS listener should serve regular certificate for regular hello")
+	}
+}
@@ -0,0 +1,250 @@
+package j8a
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const balanceVirtualNodes = 160
+const balanceDefaultUnhealthySeconds = 10
+
+const balanceIP = "ip"
+const balanceHeader = "header"
+const balanceCookie = "cookie"
+const balanceJwtSub = "jwtSub"
+
+var balanceHashes = []string{balanceIP, balanceHeader, balanceCookie, balanceJwtSub}
+
+const balanceMemberS = "balanceMember"
+const balanceAffinityS = "balanceAffinity"
+const memberBalanced = "upstream resource member balanced"
+const memberUnhealthy = "upstream resource member marked unhealthy for %v"
+
+// Balance selects the member of a resource with a consistent hash, so requests with the same key reach the same
+// member. Members added, removed or unhealthy only remap their own share of keys.
+type Balance struct {
+	// Hash is one of ip | header | cookie | jwtSub
+	Hash string
+
+	// Key is the header or cookie name for hash header and cookie
+	Key string
+
+	// AffinityCookie is an optional cookie name j8a issues to pin clients to the member first chosen for them
+	AffinityCookie string
+
+	// UnhealthySeconds members are skipped for after failed upstream attempts, defaults to 10
+	UnhealthySeconds int
+}
+
+func (b Balance) unhealthyFor() time.Duration {
+	return time.Duration(b.UnhealthySeconds) * time.Second
+}
+
+// Balancer holds hash rings of resource members and their health.
+type Balancer struct {
+	rings     sync.Map
+	mu        sync.RWMutex
+	unhealthy map[string]time.Time
+}
+
+func NewBalancer() *Balancer {
+	return &Balancer{
+		unhealthy: make(map[string]time.Time),
+	}
+}
+
+func (b *Balancer) markUnhealthy(member string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unhealthy[member] = time.Now().Add(d)
+}
+
+func (b *Balancer) isHealthy(member string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	until, ok := b.unhealthy[member]
+	return !ok || time.Now().After(until)
+}
+
+// ring for resource members with label. Members don't change at runtime, so rings are built once.
+func (b *Balancer) ring(resource string, label string, members []*URL) *hashRing {
+	key := resource + "\x00" + label
+	if r, ok := b.rings.Load(key); ok {
+		return r.(*hashRing)
+	}
+	r, _ := b.rings.LoadOrStore(key, newHashRing(members))
+	return r.(*hashRing)
+}
+
+// hashRing places virtual nodes of each member on a ring of uint64 hashes.
+type hashRing struct {
+	points  []uint64
+	members map[uint64]*URL
+}
+
+func newHashRing(members []*URL) *hashRing {
+	r := &hashRing{members: make(map[uint64]*URL)}
+	for _, m := range members {
+		for i := 0; i < balanceVirtualNodes; i++ {
+			p := hash64(m.String() + "#" + strconv.Itoa(i))
+			if _, dup := r.members[p]; !dup {
+				r.members[p] = m
+				r.points = append(r.points, p)
+			}
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// get walks clockwise from key to the first member accepted by ok.
+func (r *hashRing) get(key string, ok func(*URL) bool) *URL {
+	if len(r.points) == 0 {
+		return nil
+	}
+	h := hash64(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	for n := 0; n < len(r.points); n++ {
+		m := r.members[r.points[(i+n)%len(r.points)]]
+		if ok(m) {
+			return m
+		}
+	}
+	return nil
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	//fnv has poor avalanche for similar keys, finalise with splitmix64
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// memberID identifies members in affinity cookies without exposing upstream URLs.
+func memberID(u *URL) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, hash64(u.String()))
+	return fmt.Sprintf("%x", b)
+}
+
+// balanceKey is the value members are hashed on, empty if the request doesn't have it.
+func (proxy *Proxy) balanceKey(b *Balance) string {
+	switch b.Hash {
+	case balanceIP:
+		return ipr.extractAddr(proxy.Dwn.Req.RemoteAddr)
+	case balanceHeader:
+		return proxy.Dwn.Req.Header.Get(b.Key)
+	case balanceCookie:
+		if c, err := proxy.Dwn.Req.Cookie(b.Key); err == nil {
+			return c.Value
+		}
+	case balanceJwtSub:
+		if sub, ok := proxy.Dwn.JwtClaims["sub"]; ok {
+			return fmt.Sprint(sub)
+		}
+	}
+	return emptyString
+}
+
+// balanceMembers of resource, limited to those with label if the route has a policy.
+func balanceMembers(route Route, resource []ResourceMapping, label string) []*URL {
+	var members []*URL
+	for i, rm := range resource {
+		if len(route.Policy) == 0 {
+			members = append(members, &resource[i].URL)
+			continue
+		}
+		for _, l := range rm.Labels {
+			if l == label {
+				members = append(members, &resource[i].URL)
+				break
+			}
+		}
+	}
+	return members
+}
+
+// balance picks a healthy member for the request. It prefers the member of the affinity cookie, then the hash
+// ring, then any healthy member for requests without a key.
+func (proxy *Proxy) balance(route Route, resource []ResourceMapping, label string, skip *URL) *URL {
+	members := balanceMembers(route, resource, label)
+	if len(members) == 0 || Runner.Balancer == nil {
+		return nil
+	}
+	b := route.Balance
+	healthy := func(m *URL) bool {
+		return m != skip && Runner.Balancer.isHealthy(m.String())
+	}
+
+	var affinity string
+	if len(b.AffinityCookie) > 0 {
+		if c, err := proxy.Dwn.Req.Cookie(b.AffinityCookie); err == nil {
+			affinity = c.Value
+			for _, m := range members {
+				if memberID(m) == affinity && healthy(m) {
+					return m
+				}
+			}
+		}
+	}
+
+	var m *URL
+	if key := proxy.balanceKey(b); len(key) > 0 {
+		m = Runner.Balancer.ring(route.Resource, label, members).get(key, healthy)
+	} else {
+		for _, i := range rand.Perm(len(members)) {
+			if healthy(members[i]) {
+				m = members[i]
+				break
+			}
+		}
+	}
+	fallback := m == nil
+	if fallback {
+		//all members unhealthy, we try anyway but don't pin clients to it
+		m = members[0]
+	}
+
+	if len(b.AffinityCookie) > 0 && skip == nil && !fallback && affinity != memberID(m) {
+		http.SetCookie(proxy.Dwn.Resp.Writer, &http.Cookie{
+			Name:     b.AffinityCookie,
+			Value:    memberID(m),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   proxy.Dwn.Req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	infoOrTraceEv(proxy).
+		Str(XRequestID, proxy.XRequestID).
+		Str(balanceMemberS, m.String()).
+		Str(balanceAffinityS, b.Hash).
+		Msg(memberBalanced)
+	return m
+}
+
+// markMemberUnhealthy after upstream attempts that failed without a response.
+func (proxy *Proxy) markMemberUnhealthy(upstreamResponse *http.Response, upstreamError error) {
+	if proxy.Route == nil || proxy.Route.Balance == nil || Runner.Balancer == nil ||
+		upstreamResponse != nil || upstreamError == nil || proxy.Dwn.AbortedFlag || proxy.Dwn.TimeoutFlag {
+		return
+	}
+	d := proxy.Route.Balance.unhealthyFor()
+	Runner.Balancer.markUnhealthy(proxy.Up.Atmpt.URL.String(), d)
+	scaffoldUpAttemptLog(proxy).
+		Msgf(memberUnhealthy, d)
+}
@@ -0,0 +1,242 @@
+package j8a
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mockBalanceMembers(n int) []ResourceMapping {
+	var rm []ResourceMapping
+	for i := 0; i < n; i++ {
+		rm = append(rm, ResourceMapping{
+			Name:   "session",
+			Labels: []string{"green"},
+			URL:    URL{Scheme: "http", Host: "10.0.0." + fmt.Sprint(i+1), Port: "8080"},
+		})
+	}
+	return rm
+}
+
+// mockBalanceProxy is a request on a route balanced on header X-Session over the resource session
+func mockBalanceProxy(members []ResourceMapping, session string) *Proxy {
+	Runner.Resources["session"] = members
+	proxy := mockProxy(nil, "0", "/path", "/path", "/get", "", "")
+	proxy.Up.Atmpt.Label = defaultMsg
+	proxy.Route.Resource = "session"
+	proxy.Route.Policy = ""
+	proxy.Route.Balance = &Balance{Hash: balanceHeader, Key: "X-Session", UnhealthySeconds: 10}
+	proxy.Dwn.Req.Header.Set("X-Session", session)
+	return &proxy
+}
+
+func balanceKeys(ms []ResourceMapping) map[string]string {
+	r := newHashRing(balanceMembers(Route{}, ms, emptyString))
+	got := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		k := fmt.Sprint("session-", i)
+		got[k] = r.get(k, func(*URL) bool { return true }).String()
+	}
+	return got
+}
+
+func TestHashRingSpreadsKeys(t *testing.T) {
+	counts := make(map[string]int)
+	for _, m := range balanceKeys(mockBalanceMembers(4)) {
+		counts[m]++
+	}
+	if len(counts) != 4 {
+		t.Fatalf("want keys on all 4 members, got %v", counts)
+	}
+	for m, c := range counts {
+		if c < 1500 || c > 3500 {
+			t.Errorf("want roughly even spread, member %s has %d of 10000 keys", m, c)
+		}
+	}
+}
+
+func TestHashRingRemapsMinimalShareOfKeys(t *testing.T) {
+	before := balanceKeys(mockBalanceMembers(4))
+
+	added := balanceKeys(mockBalanceMembers(5))
+	for k, m := range added {
+		if m != before[k] && m != "http://10.0.0.5:8080" {
+			t.Fatalf("want keys only moved to added member, %s moved from %s to %s", k, before[k], m)
+		}
+	}
+
+	removed := balanceKeys(mockBalanceMembers(3))
+	for k, m := range removed {
+		if before[k] != "http://10.0.0.4:8080" && m != before[k] {
+			t.Fatalf("want only keys of removed member moved, %s moved from %s to %s", k, before[k], m)
+		}
+	}
+}
+
+func TestHashRingSkipsUnhealthyMembers(t *testing.T) {
+	ms := mockBalanceMembers(4)
+	r := newHashRing(balanceMembers(Route{}, ms, emptyString))
+	unhealthy := ms[1].URL.String()
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprint("session-", i)
+		all := r.get(k, func(*URL) bool { return true }).String()
+		got := r.get(k, func(u *URL) bool { return u.String() != unhealthy }).String()
+		if got == unhealthy || (all != unhealthy && got != all) {
+			t.Fatalf("want only keys of unhealthy member moved, %s moved from %s to %s", k, all, got)
+		}
+	}
+}
+
+func TestBalanceKey(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockBalanceProxy(mockBalanceMembers(2), "s1")
+	proxy.Dwn.Req.RemoteAddr = "192.168.1.7:54321"
+	proxy.Dwn.Req.AddCookie(&http.Cookie{Name: "sid", Value: "c1"})
+	proxy.Dwn.JwtClaims = map[string]interface{}{"sub": "user1"}
+
+	tests := map[string]struct {
+		b    Balance
+		want string
+	}{
+		"ip":          {Balance{Hash: balanceIP}, "192.168.1.7"},
+		"header":      {Balance{Hash: balanceHeader, Key: "X-Session"}, "s1"},
+		"cookie":      {Balance{Hash: balanceCookie, Key: "sid"}, "c1"},
+		"jwtSub":      {Balance{Hash: balanceJwtSub}, "user1"},
+		"noHeader":    {Balance{Hash: balanceHeader, Key: "X-Other"}, ""},
+		"noCookieSet": {Balance{Hash: balanceCookie, Key: "other"}, ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := proxy.balanceKey(&tt.b); got != tt.want {
+				t.Errorf("want key %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMapURLBalancesOnKey(t *testing.T) {
+	Runner = mockRuntime()
+	ms := mockBalanceMembers(4)
+	for i := 0; i < 50; i++ {
+		session := fmt.Sprint("s", i)
+		p1 := mockBalanceProxy(ms, session)
+		p2 := mockBalanceProxy(ms, session)
+		u1, l1, ok1 := p1.Route.mapURL(p1)
+		u2, _, ok2 := p2.Route.mapURL(p2)
+		if !ok1 || !ok2 || u1 != u2 || l1 != defaultMsg {
+			t.Fatalf("want same member for session %s, got %v %v", session, u1, u2)
+		}
+	}
+}
+
+func TestMapURLBalancesWithinPolicyLabel(t *testing.T) {
+	Runner = mockRuntime()
+	ms := mockBalanceMembers(4)
+	ms[0].Labels = []string{"blue"}
+	ms[1].Labels = []string{"blue"}
+	Runner.Policies["blue"] = Policy{LabelWeight{Label: "blue", Weight: 1}}
+	for i := 0; i < 50; i++ {
+		proxy := mockBalanceProxy(ms, fmt.Sprint("s", i))
+		proxy.Route.Policy = "blue"
+		u, l, ok := proxy.Route.mapURL(proxy)
+		if !ok || l != "blue" || (u != &ms[0].URL && u != &ms[1].URL) {
+			t.Fatalf("want member labelled blue, got %v %s", u, l)
+		}
+	}
+}
+
+func TestBalanceIssuesAndHonoursAffinityCookie(t *testing.T) {
+	Runner = mockRuntime()
+	ms := mockBalanceMembers(4)
+	proxy := mockBalanceProxy(ms, "")
+	proxy.Route.Balance = &Balance{Hash: balanceIP, AffinityCookie: "j8a-affinity", UnhealthySeconds: 10}
+	proxy.Dwn.Req.RemoteAddr = "192.168.1.7:54321"
+	u, _, _ := proxy.Route.mapURL(proxy)
+
+	cookies := proxy.Dwn.Resp.Writer.(*httptest.ResponseRecorder).Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "j8a-affinity" || cookies[0].Value != memberID(u) || !cookies[0].HttpOnly {
+		t.Fatalf("want affinity cookie for member %s, got %v", u, cookies)
+	}
+
+	//same cookie from another address stays on the member
+	next := mockBalanceProxy(ms, "")
+	next.Route.Balance = proxy.Route.Balance
+	next.Dwn.Req.RemoteAddr = "10.1.1.1:1234"
+	next.Dwn.Req.AddCookie(cookies[0])
+	for i := 0; i < 10; i++ {
+		if got, _, _ := next.Route.mapURL(next); got != u {
+			t.Errorf("want affinity cookie to pin member %s, got %s", u, got)
+		}
+	}
+
+	//unhealthy member loses its affinity
+	Runner.Balancer.markUnhealthy(u.String(), next.Route.Balance.unhealthyFor())
+	if got, _, _ := next.Route.mapURL(next); got == u {
+		t.Errorf("want unhealthy member %s skipped", u)
+	}
+}
+
+func TestBalanceSetsAffinityCookieOnlyWhenChanged(t *testing.T) {
+	Runner = mockRuntime()
+	ms := mockBalanceMembers(2)
+	b := &Balance{Hash: balanceIP, AffinityCookie: "j8a-affinity", UnhealthySeconds: 10}
+	cookies := func(p *Proxy) []*http.Cookie {
+		return p.Dwn.Resp.Writer.(*httptest.ResponseRecorder).Result().Cookies()
+	}
+
+	first := mockBalanceProxy(ms, "")
+	first.Route.Balance = b
+	first.Dwn.Req.RemoteAddr = "192.168.1.7:54321"
+	u, _, _ := first.Route.mapURL(first)
+
+	//all members unhealthy, the fallback member isn't pinned
+	for _, m := range ms {
+		Runner.Balancer.markUnhealthy(m.URL.String(), b.unhealthyFor())
+	}
+	fallback := mockBalanceProxy(ms, "")
+	fallback.Route.Balance = b
+	fallback.Dwn.Req.AddCookie(cookies(first)[0])
+	if _, _, mapped := fallback.Route.mapURL(fallback); !mapped {
+		t.Fatalf("want request mapped to fallback member")
+	}
+	if got := cookies(fallback); len(got) != 0 {
+		t.Errorf("want no affinity cookie for unhealthy fallback member, got %v", got)
+	}
+
+	//healthy again, the existing cookie isn't sent again
+	Runner.Balancer = NewBalancer()
+	again := mockBalanceProxy(ms, "")
+	again.Route.Balance = b
+	again.Dwn.Req.AddCookie(cookies(first)[0])
+	if got, _, _ := again.Route.mapURL(again); got != u {
+		t.Errorf("want affinity cookie to pin member %s, got %s", u, got)
+	}
+	if got := cookies(again); len(got) != 0 {
+		t.Errorf("want no affinity cookie when member is unchanged, got %v", got)
+	}
+}
+
+func TestUpstreamFailureMarksMemberUnhealthyAndRetriesOnAnother(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockBalanceProxy(mockBalanceMembers(3), "s1")
+	u, _, _ := proxy.Route.mapURL(proxy)
+	proxy.Up.Atmpt.URL = u
+
+	proxy.markMemberUnhealthy(nil, fmt.Errorf("connection refused"))
+	if Runner.Balancer.isHealthy(u.String()) {
+		t.Fatalf("want member %s unhealthy after failed attempt", u)
+	}
+	if next := proxy.nextAttempt(); next.Up.Atmpt.URL == u {
+		t.Errorf("want retry on another member than %s", u)
+	}
+
+	//responses don't mark members unhealthy
+	Runner = mockRuntime()
+	other := mockBalanceProxy(mockBalanceMembers(3), "s1")
+	other.Up.Atmpt.URL = &Runner.Resources["session"][2].URL
+	other.markMemberUnhealthy(&http.Response{StatusCode: 500}, nil)
+	if !Runner.Balancer.isHealthy(other.Up.Atmpt.URL.String()) {
+		t.Errorf("want member healthy after upstream response")
+	}
+}
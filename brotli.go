@@ -11,12 +11,10 @@ const brotliLevel int = 1
 
 var brotliEmpty = []byte{0}
 
-var brotliEncPool = sync.Pool{
-	New: func() interface{} {
-		var buf bytes.Buffer
-		return brotli.NewWriterLevel(&buf, brotliLevel)
-	},
-}
+var brotliEncPools = newLevelPools(brotli.BestSpeed, brotli.BestCompression, func(level int) interface{} {
+	var buf bytes.Buffer
+	return brotli.NewWriterLevel(&buf, level)
+})
 
 var brotliDecPool = sync.Pool{
 	New: func() interface{} {
@@ -28,6 +26,12 @@ var brotliDecPool = sync.Pool{
 
 // BrotliEncode encodes to brotli from byte array.
 func BrotliEncode(input []byte) *[]byte {
+	return BrotliEncodeLevel(input, brotliLevel)
+}
+
+// BrotliEncodeLevel encodes to brotli from byte array with compression level 0-11
+func BrotliEncodeLevel(input []byte, level int) *[]byte {
+	brotliEncPool := brotliEncPools.get(level)
 	wrt, _ := brotliEncPool.Get().(*brotli.Writer)
 	buf := &bytes.Buffer{}
 	wrt.Reset(buf)
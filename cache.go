@@ -0,0 +1,654 @@
+package j8a
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cacheDefaultMaxBytes int64 = 64 << 20
+
+const xCacheS = "X-Cache"
+const ageS = "Age"
+const cacheControlS = "Cache-Control"
+const expiresS = "Expires"
+const lastModifiedS = "Last-Modified"
+const ifNoneMatchS = "If-None-Match"
+const ifModifiedSinceS = "If-Modified-Since"
+const setCookieS = "Set-Cookie"
+const authorizationS = "Authorization"
+
+const cacheHit = "HIT"
+const cacheMiss = "MISS"
+const cacheRevalidated = "REVALIDATED"
+const cacheStale = "STALE"
+const cacheStaleIfError = "STALE-IF-ERROR"
+
+const ccNoStore = "no-store"
+const ccNoCache = "no-cache"
+const ccPrivate = "private"
+const ccPublic = "public"
+const ccMaxAge = "max-age"
+const ccSMaxAge = "s-maxage"
+const ccMustRevalidate = "must-revalidate"
+const ccProxyRevalidate = "proxy-revalidate"
+const ccStaleWhileRevalidate = "stale-while-revalidate"
+const ccStaleIfError = "stale-if-error"
+
+const cacheStored = "upstream response stored in cache as %s, fresh for %v"
+const cacheServed = "downstream response served from cache"
+const cacheRefreshing = "stale cache entry refreshing in background"
+const cacheStaleS = "cacheStale"
+
+// backgroundRefresh performs upstream requests of background refreshes
+var backgroundRefresh proxyfunc = handleHTTP
+
+// cacheHeadersNotStored are set per response, never from the cache.
+var cacheHeadersNotStored = []string{contentEncoding, contentLength, date, server, XRequestID, strictTransportSecurity,
+	varyS, ageS, xCacheS, connectionS, transferEncoding, setCookieS}
+
+// ResponseCache is a byte bounded LRU of upstream responses for routes with cache.
+type ResponseCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	//keys with a background refresh in progress
+	refreshing map[string]bool
+}
+
+// CacheEntry is a cached response with one body per content encoding it was served in. Entries are never modified
+// once stored, updates replace them, so they are safe to serve while other requests write.
+type CacheEntry struct {
+	Key              string
+	Host             string
+	URI              string
+	Route            string
+	SurrogateKeys    []string
+	StatusCode       int
+	Header           http.Header
+	Variants         map[ContentEncoding][]byte
+	UpstreamEncoding ContentEncoding
+	Compressible     bool
+	ResponseTime     time.Time
+	InitialAge       time.Duration
+	Ttl              time.Duration
+}
+
+func NewResponseCache(maxBytes int64) *ResponseCache {
+	return &ResponseCache{
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		refreshing: make(map[string]bool),
+	}
+}
+
+func (c *ResponseCache) get(key string) *CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*CacheEntry)
+	}
+	return nil
+}
+
+// put stores e, keeping variants of the existing entry if it's the same representation.
+func (c *ResponseCache) put(e *CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.Key]; ok {
+		old := el.Value.(*CacheEntry)
+		if e.sameRepresentation(old) {
+			e = e.withVariantsOf(old)
+		}
+		c.removeElement(el)
+	}
+
+	if e.size() > c.maxBytes {
+		return
+	}
+
+	c.entries[e.Key] = c.lru.PushFront(e)
+	c.bytes += e.size()
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *ResponseCache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+		return true
+	}
+	return false
+}
+
+func (c *ResponseCache) removeElement(el *list.Element) {
+	e := el.Value.(*CacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, e.Key)
+	c.bytes -= e.size()
+}
+
+// startRefresh is true if the caller should refresh key, false if another refresh is in progress.
+func (c *ResponseCache) startRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+func (c *ResponseCache) endRefresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.refreshing, key)
+}
+
+func (c *ResponseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (e *CacheEntry) size() int64 {
+	var s = int64(len(e.Key))
+	for _, v := range e.Variants {
+		s += int64(len(v))
+	}
+	for k, vs := range e.Header {
+		s += int64(len(k))
+		for _, v := range vs {
+			s += int64(len(v))
+		}
+	}
+	return s
+}
+
+func (e *CacheEntry) age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.ResponseTime)
+}
+
+func (e *CacheEntry) isFresh(now time.Time) bool {
+	return e.age(now) < e.Ttl
+}
+
+// staleWithin is true if e is stale for no longer than the window of the directive, capped at max. Responses that
+// must be revalidated are never served stale.
+func (e *CacheEntry) staleWithin(directive string, max time.Duration, now time.Time) bool {
+	cc := parseCacheControl(e.Header)
+	if max <= 0 || cc.has(ccMustRevalidate) || cc.has(ccProxyRevalidate) || cc.has(ccSMaxAge) || cc.has(ccNoCache) {
+		return false
+	}
+	window := max
+	if s, ok := cc.seconds(directive); ok && s < max {
+		window = s
+	}
+	return e.age(now)-e.Ttl <= window
+}
+
+func (e *CacheEntry) etag() string {
+	return e.Header.Get(etagS)
+}
+
+func (e *CacheEntry) lastModified() string {
+	return e.Header.Get(lastModifiedS)
+}
+
+func (e *CacheEntry) hasValidator() bool {
+	return len(e.etag()) > 0 || len(e.lastModified()) > 0
+}
+
+// sameRepresentation is true if variants of old can be served alongside e.
+func (e *CacheEntry) sameRepresentation(old *CacheEntry) bool {
+	if len(e.etag()) > 0 || len(old.etag()) > 0 {
+		return strings.TrimPrefix(e.etag(), weakEtagPrefix) == strings.TrimPrefix(old.etag(), weakEtagPrefix)
+	}
+	if len(e.lastModified()) > 0 || len(old.lastModified()) > 0 {
+		return e.lastModified() == old.lastModified()
+	}
+	return old.isFresh(e.ResponseTime)
+}
+
+func (e *CacheEntry) withVariantsOf(old *CacheEntry) *CacheEntry {
+	n := *e
+	n.Variants = make(map[ContentEncoding][]byte)
+	for enc, v := range old.Variants {
+		n.Variants[enc] = v
+	}
+	for enc, v := range e.Variants {
+		n.Variants[enc] = v
+	}
+	return &n
+}
+
+// refreshed is a copy of e with freshness and validators from a 304 response.
+func (e *CacheEntry) refreshed(h http.Header, now time.Time) *CacheEntry {
+	n := *e
+	n.Header = e.Header.Clone()
+	for _, k := range []string{cacheControlS, expiresS, etagS, lastModifiedS} {
+		if v := h.Values(k); len(v) > 0 {
+			n.Header[k] = v
+		}
+	}
+	n.ResponseTime = now
+	n.InitialAge = parseAge(h)
+	n.Ttl = freshnessLifetime(n.Header, now)
+	return &n
+}
+
+// cacheControl directives, lowercase, with unquoted values.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, v := range h.Values(cacheControlS) {
+		for _, d := range strings.Split(v, COMMA) {
+			kv := strings.SplitN(strings.TrimSpace(d), "=", 2)
+			if len(kv[0]) == 0 {
+				continue
+			}
+			if len(kv) == 2 {
+				cc[strings.ToLower(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			} else {
+				cc[strings.ToLower(kv[0])] = emptyString
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	if v, ok := cc[directive]; ok {
+		if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second, true
+		}
+		//invalid values are treated as stale
+		return 0, true
+	}
+	return 0, false
+}
+
+// freshnessLifetime as a shared cache per RFC 9111 4.2.1. s-maxage over max-age over Expires.
+func freshnessLifetime(h http.Header, now time.Time) time.Duration {
+	cc := parseCacheControl(h)
+	if cc.has(ccNoCache) {
+		return 0
+	}
+	if s, ok := cc.seconds(ccSMaxAge); ok {
+		return s
+	}
+	if s, ok := cc.seconds(ccMaxAge); ok {
+		return s
+	}
+	if exp := h.Get(expiresS); len(exp) > 0 {
+		expires, err := http.ParseTime(exp)
+		if err != nil {
+			return 0
+		}
+		dt := now
+		if d, err := http.ParseTime(h.Get(date)); err == nil {
+			dt = d
+		}
+		if ttl := expires.Sub(dt); ttl > 0 {
+			return ttl
+		}
+	}
+	return 0
+}
+
+func parseAge(h http.Header) time.Duration {
+	if s, err := strconv.Atoi(h.Get(ageS)); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return 0
+}
+
+// proxyCache is the cache state of a single request on a route with cache.
+type proxyCache struct {
+	key string
+	//stale entry we revalidate upstream
+	stale *CacheEntry
+	//stale entry we serve if upstream fails
+	fallback *CacheEntry
+}
+
+func (proxy *Proxy) hasCache() bool {
+	return proxy.cache != nil
+}
+
+// cacheKey is method, host, URI, the matched route, its policy label and the values of the route's vary headers.
+func (proxy *Proxy) cacheKey() string {
+	var b strings.Builder
+	b.WriteString(proxy.Dwn.Method)
+	b.WriteString(Sep)
+	b.WriteString(proxy.Dwn.Host)
+	b.WriteString(proxy.Dwn.URI)
+	b.WriteString("\n")
+	b.WriteString(proxy.Route.identity())
+	if len(proxy.Route.Policy) > 0 {
+		b.WriteString(Sep)
+		b.WriteString(proxy.policyLabel(*proxy.Route))
+	}
+	var vary []string
+	if proxy.Route.Cache != nil {
+		vary = proxy.Route.Cache.Vary
+	}
+	for _, v := range vary {
+		b.WriteString("\n")
+		b.WriteString(v)
+		b.WriteString(colon)
+		b.WriteString(strings.Join(proxy.Dwn.Req.Header.Values(v), COMMA))
+	}
+	return b.String()
+}
+
+// expectedEncoding is what encodeUpstreamResponseBody would produce for this request from e's upstream response.
+func (proxy *Proxy) expectedEncoding(e *CacheEntry) ContentEncoding {
+	c := proxy.compression()
+	up := e.UpstreamEncoding
+	ae := proxy.Dwn.AcceptEncoding
+	if up.isEncoded() && !(c.Transcode && up.isAtomic() && c.decodable(up) && !ae.isCompatible(up)) {
+		return up
+	}
+	if !e.Compressible {
+		return EncIdentity
+	}
+	if enc := ae.negotiate(c.preference()); len(enc) > 0 {
+		return enc
+	}
+	return EncIdentity
+}
+
+// serveFromCache sends a fresh cached response and returns true. For stale responses with validators it remembers
+// the entry, so we can revalidate it upstream.
+func (proxy *Proxy) serveFromCache() bool {
+	if Runner.ResponseCache == nil || proxy.Dwn.Method != "GET" || proxy.isWebsocketUpgrade() {
+		return false
+	}
+	proxy.cache = &proxyCache{key: proxy.cacheKey()}
+
+	e := Runner.ResponseCache.get(proxy.cache.key)
+	if e == nil {
+		return false
+	}
+	enc := proxy.expectedEncoding(e)
+	if _, ok := e.Variants[enc]; !ok {
+		return false
+	}
+
+	now := time.Now()
+	if e.isFresh(now) {
+		proxy.sendCachedResponse(e, enc, cacheHit)
+		return true
+	}
+	if e.staleWithin(ccStaleWhileRevalidate, proxy.Route.Cache.staleWhileRevalidate(), now) {
+		proxy.sendCachedResponse(e, enc, cacheStale)
+		proxy.refreshInBackground(e)
+		return true
+	}
+	if e.hasValidator() {
+		proxy.cache.stale = e
+	}
+	proxy.cache.fallback = e
+	return false
+}
+
+// refreshInBackground revalidates e upstream with a copy of the request that nobody waits for.
+func (proxy *Proxy) refreshInBackground(e *CacheEntry) {
+	if !Runner.ResponseCache.startRefresh(e.Key) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(proxy.downstreamRoundTripTimeout(), cancel)
+
+	bg := &Proxy{
+		XRequestID: proxy.XRequestID,
+		Route:      proxy.Route,
+		Dwn: Down{
+			Req:            proxy.Dwn.Req.Clone(ctx),
+			Resp:           Resp{Writer: &discardResponseWriter{header: make(http.Header)}},
+			Method:         proxy.Dwn.Method,
+			Host:           proxy.Dwn.Host,
+			Path:           proxy.Dwn.Path,
+			URI:            proxy.Dwn.URI,
+			UserAgent:      proxy.Dwn.UserAgent,
+			AcceptEncoding: proxy.Dwn.AcceptEncoding,
+			JwtClaims:      proxy.Dwn.JwtClaims,
+			Timeout:        ctx.Done(),
+			startDate:      time.Now(),
+			HttpVer:        proxy.Dwn.HttpVer,
+			Port:           proxy.Dwn.Port,
+			Listener:       proxy.Dwn.Listener,
+		},
+		cache: &proxyCache{key: e.Key},
+		//the refresh goes to the label the entry is keyed on
+		label: proxy.label,
+	}
+	if e.hasValidator() {
+		bg.cache.stale = e
+	}
+
+	infoOrTraceEv(proxy).
+		Str(XRequestID, proxy.XRequestID).
+		Msg(cacheRefreshing)
+
+	go func() {
+		defer Runner.ResponseCache.endRefresh(e.Key)
+		defer cancel()
+		if url, label, mapped := bg.Route.mapURL(bg); mapped {
+			backgroundRefresh(bg.firstAttempt(url, label))
+		}
+	}()
+}
+
+// hasStaleIfError is true if a stale entry may be served instead of an upstream error.
+func (proxy *Proxy) hasStaleIfError() bool {
+	return proxy.hasCache() && proxy.cache.fallback != nil &&
+		proxy.cache.fallback.staleWithin(ccStaleIfError, proxy.Route.Cache.staleIfError(), time.Now())
+}
+
+// serveStaleIfError sends the stale entry instead of an upstream error and returns true.
+func (proxy *Proxy) serveStaleIfError() bool {
+	if !proxy.hasStaleIfError() {
+		return false
+	}
+	e := proxy.cache.fallback
+	proxy.sendCachedResponse(e, proxy.expectedEncoding(e), cacheStaleIfError)
+	return true
+}
+
+// discardResponseWriter is the downstream of background refreshes.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+// setConditionalHeaders asks upstream to revalidate our stale entry instead of sending the client's validators.
+func (proxy *Proxy) setConditionalHeaders(h http.Header) {
+	if !proxy.hasCache() || proxy.cache.stale == nil {
+		return
+	}
+	h.Del(ifNoneMatchS)
+	h.Del(ifModifiedSinceS)
+	if etag := proxy.cache.stale.etag(); len(etag) > 0 {
+		h.Set(ifNoneMatchS, etag)
+	}
+	if lm := proxy.cache.stale.lastModified(); len(lm) > 0 {
+		h.Set(ifModifiedSinceS, lm)
+	}
+}
+
+// revalidatedFromCache serves the stale entry if upstream confirmed it with 304 and returns true.
+func (proxy *Proxy) revalidatedFromCache() bool {
+	if !proxy.hasCache() || proxy.cache.stale == nil || proxy.Up.Atmpt.StatusCode != http.StatusNotModified {
+		return false
+	}
+	e := proxy.cache.stale.refreshed(proxy.Up.Atmpt.resp.Header, time.Now())
+	Runner.ResponseCache.put(e)
+	proxy.sendCachedResponse(e, proxy.expectedEncoding(e), cacheRevalidated)
+	return true
+}
+
+// notModified evaluates the client's conditional request against e.
+func (proxy *Proxy) notModified(e *CacheEntry) bool {
+	if inm := proxy.Dwn.Req.Header.Get(ifNoneMatchS); len(inm) > 0 {
+		etag := strings.TrimPrefix(e.etag(), weakEtagPrefix)
+		if len(etag) == 0 {
+			return false
+		}
+		for _, t := range strings.Split(inm, COMMA) {
+			t = strings.TrimSpace(t)
+			if t == STAR || strings.TrimPrefix(t, weakEtagPrefix) == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(proxy.Dwn.Req.Header.Get(ifModifiedSinceS)); err == nil {
+		if lm, err := http.ParseTime(e.lastModified()); err == nil {
+			return !lm.After(ims)
+		}
+	}
+	return false
+}
+
+func (proxy *Proxy) sendCachedResponse(e *CacheEntry, enc ContentEncoding, xCache string) {
+	proxy.writeStandardResponseHeaders()
+	h := proxy.Dwn.Resp.Writer.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set(ageS, strconv.Itoa(int(e.age(time.Now()).Seconds())))
+	h.Set(xCacheS, xCache)
+
+	body := e.Variants[enc]
+	proxy.Dwn.Resp.ContentEncoding = enc
+	h.Set(contentEncoding, enc.print())
+	if proxy.notModified(e) {
+		body = []byte{}
+		proxy.respondWith(http.StatusNotModified, none)
+	} else {
+		proxy.respondWith(e.StatusCode, none)
+	}
+	proxy.Dwn.Resp.Body = &body
+
+	proxy.setContentLengthHeader()
+	proxy.sendDownstreamStatusCodeHeader()
+	proxy.pipeDownstreamResponse()
+
+	infoOrTraceEv(proxy).
+		Str(XRequestID, proxy.XRequestID).
+		Str(xCacheS, xCache).
+		Bool(cacheStaleS, xCache == cacheStale || xCache == cacheStaleIfError).
+		Int64(dwnElpsdMicros, time.Since(proxy.Dwn.startDate).Microseconds()).
+		Msg(cacheServed)
+	logHandledDownstreamRoundtrip(proxy)
+}
+
+// isStorable checks the upstream response against RFC 9111 rules for shared caches.
+func (proxy *Proxy) isStorable(up http.Header) bool {
+	if proxy.Up.Atmpt.StatusCode != http.StatusOK || len(up.Values(setCookieS)) > 0 {
+		return false
+	}
+	cc := parseCacheControl(up)
+	if cc.has(ccNoStore) || cc.has(ccPrivate) {
+		return false
+	}
+	if len(proxy.Dwn.Req.Header.Get(authorizationS)) > 0 &&
+		!(cc.has(ccPublic) || cc.has(ccSMaxAge) || cc.has(ccMustRevalidate)) {
+		return false
+	}
+	return proxy.varyCovered(up)
+}
+
+// varyCovered is true if our cache key covers all headers the upstream response varies on.
+func (proxy *Proxy) varyCovered(up http.Header) bool {
+	for _, v := range up.Values(varyS) {
+		for _, f := range strings.Split(v, COMMA) {
+			f = http.CanonicalHeaderKey(strings.TrimSpace(f))
+			if len(f) == 0 || f == http.CanonicalHeaderKey(acceptEncoding) {
+				continue
+			}
+			if f == STAR {
+				return false
+			}
+			i := sort.SearchStrings(proxy.Route.Cache.Vary, f)
+			if i == len(proxy.Route.Cache.Vary) || proxy.Route.Cache.Vary[i] != f {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// cacheUpstreamResponse stores the encoded downstream response. Must be called before the status code is sent.
+func (proxy *Proxy) cacheUpstreamResponse() {
+	if !proxy.hasCache() {
+		return
+	}
+	proxy.Dwn.Resp.Writer.Header().Set(xCacheS, cacheMiss)
+	//surrogate keys are for purging, not for clients
+	proxy.Dwn.Resp.Writer.Header().Del(surrogateKeyS)
+
+	atmpt := proxy.Up.Atmpt
+	up := atmpt.resp.Header
+	if !proxy.isStorable(up) {
+		return
+	}
+
+	now := time.Now()
+	e := &CacheEntry{
+		Key:              proxy.cache.key,
+		Host:             proxy.Dwn.Host,
+		URI:              proxy.Dwn.URI,
+		Route:            proxy.Route.Path,
+		SurrogateKeys:    strings.Fields(strings.Join(up.Values(surrogateKeyS), Sep)),
+		StatusCode:       atmpt.StatusCode,
+		Header:           proxy.Dwn.Resp.Writer.Header().Clone(),
+		Variants:         map[ContentEncoding][]byte{proxy.Dwn.Resp.ContentEncoding: *proxy.Dwn.Resp.Body},
+		UpstreamEncoding: atmpt.ContentEncoding,
+		Compressible:     proxy.compression().allows(atmpt.contentType(), len(*atmpt.respBody)),
+		ResponseTime:     now,
+		InitialAge:       parseAge(up),
+		Ttl:              freshnessLifetime(up, now),
+	}
+	for _, h := range cacheHeadersNotStored {
+		e.Header.Del(h)
+	}
+	if e.Ttl <= 0 && !e.hasValidator() {
+		return
+	}
+
+	Runner.ResponseCache.put(e)
+	scaffoldUpAttemptLog(proxy).
+		Msgf(cacheStored, proxy.Dwn.Resp.ContentEncoding, e.Ttl)
+}
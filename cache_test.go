@@ -0,0 +1,538 @@
+package j8a
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var cacheBody = []byte(strings.Repeat(`{"mse6":"Hello from the cache"}`, 100))
+
+// mockCacheProxy is a GET on a route with cache, before its first upstream attempt
+func mockCacheProxy(ae string) *Proxy {
+	proxy := mockProxy(cacheBody, "0", "/path", "/path", "/get", "", "")
+	proxy.Up.Atmpt = nil
+	proxy.Dwn.Method = "GET"
+	proxy.Dwn.Host = "localhost"
+	proxy.Dwn.Req.Header.Del(authorizationS)
+	proxy.Dwn.AcceptEncoding = mockAcceptEncoding(ae)
+	proxy.Route.Cache = &RouteCache{}
+	return &proxy
+}
+
+// mockCacheUpstreamResponse runs the proxyhandler pipeline for an upstream response up to caching it.
+func mockCacheUpstreamResponse(proxy *Proxy, status int, upHeader http.Header, body []byte) {
+	proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+	proxy.Up.Atmpt.resp.StatusCode = status
+	proxy.Up.Atmpt.resp.Header = upHeader
+	proxy.Up.Atmpt.StatusCode = status
+	proxy.Up.Atmpt.respBody = &body
+	proxy.writeStandardResponseHeaders()
+	proxy.copyUpstreamResponseHeaders()
+	proxy.copyUpstreamStatusCodeHeader()
+	proxy.encodeUpstreamResponseBody()
+	proxy.setContentLengthHeader()
+	proxy.cacheUpstreamResponse()
+}
+
+func mockCacheHeader(kv ...string) http.Header {
+	h := make(http.Header)
+	for i := 0; i < len(kv); i += 2 {
+		h.Add(kv[i], kv[i+1])
+	}
+	return h
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(mockCacheHeader(cacheControlS, `Public, max-age="60"`, cacheControlS, "s-maxage=30,,no-cache"))
+	if !cc.has(ccPublic) || !cc.has(ccNoCache) {
+		t.Errorf("want public and no-cache, got %v", cc)
+	}
+	if s, ok := cc.seconds(ccMaxAge); !ok || s != 60*time.Second {
+		t.Errorf("want max-age 60s, got %v", s)
+	}
+	if s, ok := cc.seconds(ccSMaxAge); !ok || s != 30*time.Second {
+		t.Errorf("want s-maxage 30s, got %v", s)
+	}
+	if _, ok := cc.seconds(ccNoStore); ok {
+		t.Errorf("want no no-store directive")
+	}
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	dt := now.Format(http.TimeFormat)
+	in := func(d time.Duration) string { return now.Add(d).Format(http.TimeFormat) }
+
+	tests := map[string]struct {
+		header http.Header
+		want   time.Duration
+	}{
+		"none":              {mockCacheHeader(), 0},
+		"maxAge":            {mockCacheHeader(cacheControlS, "max-age=60"), 60 * time.Second},
+		"sMaxAgeOverMaxAge": {mockCacheHeader(cacheControlS, "max-age=60, s-maxage=10"), 10 * time.Second},
+		"maxAgeOverExpires": {mockCacheHeader(cacheControlS, "max-age=60", expiresS, in(time.Hour)), 60 * time.Second},
+		"invalidMaxAge":     {mockCacheHeader(cacheControlS, "max-age=soon", expiresS, in(time.Hour)), 0},
+		"noCache":           {mockCacheHeader(cacheControlS, "no-cache, max-age=60"), 0},
+		"expires":           {mockCacheHeader(date, dt, expiresS, in(time.Hour)), time.Hour},
+		"expiresNoDate":     {mockCacheHeader(expiresS, in(time.Minute)), time.Minute},
+		"expiresInPast":     {mockCacheHeader(date, dt, expiresS, in(-time.Hour)), 0},
+		"expiresInvalid":    {mockCacheHeader(date, dt, expiresS, "0"), 0},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := freshnessLifetime(tt.header, now); got != tt.want {
+				t.Errorf("want freshness lifetime %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entry := func(key string) *CacheEntry {
+		return &CacheEntry{Key: key, Header: http.Header{}, Variants: map[ContentEncoding][]byte{EncIdentity: make([]byte, 99)}}
+	}
+	c := NewResponseCache(300)
+	c.put(entry("a"))
+	c.put(entry("b"))
+	c.put(entry("c"))
+	if c.len() != 3 || c.bytes != 300 {
+		t.Errorf("want 3 entries with 300 bytes, got %d with %d bytes", c.len(), c.bytes)
+	}
+
+	//a is now most recently used, b is evicted
+	c.get("a")
+	c.put(entry("d"))
+	if c.get("b") != nil {
+		t.Errorf("want least recently used entry evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if c.get(k) == nil {
+			t.Errorf("want entry %s retained", k)
+		}
+	}
+
+	//too large to store at all
+	big := entry("e")
+	big.Variants[EncIdentity] = make([]byte, 301)
+	c.put(big)
+	if c.get("e") != nil || c.len() != 3 {
+		t.Errorf("want oversized entry not stored")
+	}
+
+	if !c.remove("a") || c.remove("a") || c.bytes != 200 {
+		t.Errorf("want entry removed once and bytes released, got %d bytes", c.bytes)
+	}
+}
+
+func TestResponseCachePutMergesVariantsOfSameRepresentation(t *testing.T) {
+	now := time.Now()
+	entry := func(etag string, enc ContentEncoding) *CacheEntry {
+		return &CacheEntry{
+			Key:          "k",
+			Header:       mockCacheHeader(etagS, etag),
+			Variants:     map[ContentEncoding][]byte{enc: []byte(enc)},
+			ResponseTime: now,
+			Ttl:          time.Minute,
+		}
+	}
+
+	c := NewResponseCache(cacheDefaultMaxBytes)
+	c.put(entry(`"v1"`, EncIdentity))
+	c.put(entry(`W/"v1"`, EncGzip))
+	if got := len(c.get("k").Variants); got != 2 {
+		t.Errorf("want variants merged for same etag, got %d", got)
+	}
+
+	c.put(entry(`"v2"`, EncBrotli))
+	e := c.get("k")
+	if _, ok := e.Variants[EncBrotli]; len(e.Variants) != 1 || !ok {
+		t.Errorf("want variants replaced for changed etag, got %v", e.Variants)
+	}
+}
+
+func TestCacheKeyVary(t *testing.T) {
+	Runner = mockRuntime()
+	p1 := mockCacheProxy("")
+	p1.Route.Cache.Vary = []string{"Accept-Language"}
+	p1.Dwn.Req.Header.Set("Accept-Language", "de")
+	p2 := mockCacheProxy("")
+	p2.Route.Cache.Vary = []string{"Accept-Language"}
+	p2.Dwn.Req.Header.Set("Accept-Language", "en")
+
+	if p1.cacheKey() == p2.cacheKey() {
+		t.Errorf("want different cache keys for vary header values, got %s", p1.cacheKey())
+	}
+	p2.Dwn.Req.Header.Set("Accept-Language", "de")
+	if p1.cacheKey() != p2.cacheKey() {
+		t.Errorf("want same cache keys, got %s and %s", p1.cacheKey(), p2.cacheKey())
+	}
+	p2.Dwn.URI = "/get?q=1"
+	if p1.cacheKey() == p2.cacheKey() {
+		t.Errorf("want different cache keys for URI")
+	}
+}
+
+func TestCacheKeyRoute(t *testing.T) {
+	Runner = mockRuntime()
+	v2 := mockCacheProxy("")
+	v2.Route.Headers = []RouteMatcher{{Name: "X-Api-Version", Value: "2"}}
+	fallback := mockCacheProxy("")
+
+	if v2.cacheKey() == fallback.cacheKey() {
+		t.Errorf("want different cache keys for routes with the same path, got %s", v2.cacheKey())
+	}
+	if v2.coalesceKey() == fallback.coalesceKey() {
+		t.Errorf("want different coalesce keys for routes with the same path, got %s", v2.coalesceKey())
+	}
+}
+
+func TestCacheKeyPolicyLabel(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Policies["canary"] = mockCanaryPolicy()
+	pinned := mockCacheProxy("")
+	pinned.Route.Policy = "canary"
+	pinned.Dwn.Req.Header.Set("X-Canary", "blue")
+	other := mockCacheProxy("")
+	other.Route.Policy = "canary"
+
+	if pinned.cacheKey() == other.cacheKey() {
+		t.Errorf("want different cache keys for policy labels, got %s", pinned.cacheKey())
+	}
+	if pinned.coalesceKey() == other.coalesceKey() {
+		t.Errorf("want different coalesce keys for policy labels, got %s", pinned.coalesceKey())
+	}
+	if got := pinned.policyLabel(*pinned.Route); got != "blue" {
+		t.Errorf("want label of cache key resolved once, got %s", got)
+	}
+}
+
+func TestIsStorable(t *testing.T) {
+	tests := map[string]struct {
+		status int
+		header http.Header
+		auth   bool
+		vary   []string
+		want   bool
+	}{
+		"ok":                  {200, mockCacheHeader(cacheControlS, "max-age=60"), false, nil, true},
+		"notOk":               {201, mockCacheHeader(cacheControlS, "max-age=60"), false, nil, false},
+		"noStore":             {200, mockCacheHeader(cacheControlS, "no-store"), false, nil, false},
+		"private":             {200, mockCacheHeader(cacheControlS, "private, max-age=60"), false, nil, false},
+		"setCookie":           {200, mockCacheHeader(cacheControlS, "max-age=60", setCookieS, "a=b"), false, nil, false},
+		"authorization":       {200, mockCacheHeader(cacheControlS, "max-age=60"), true, nil, false},
+		"authorizationPublic": {200, mockCacheHeader(cacheControlS, "public, max-age=60"), true, nil, true},
+		"varyAcceptEncoding":  {200, mockCacheHeader(varyS, "Accept-Encoding"), false, nil, true},
+		"varyNotCovered":      {200, mockCacheHeader(varyS, "accept-encoding, accept-language"), false, nil, false},
+		"varyCovered":         {200, mockCacheHeader(varyS, "accept-language"), false, []string{"Accept-Language"}, true},
+		"varyStar":            {200, mockCacheHeader(varyS, "*"), false, nil, false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			proxy := mockCacheProxy("")
+			proxy.Route.Cache.Vary = tt.vary
+			if tt.auth {
+				proxy.Dwn.Req.Header.Set(authorizationS, "Bearer x")
+			}
+			proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+			proxy.Up.Atmpt.StatusCode = tt.status
+			if got := proxy.isStorable(tt.header); got != tt.want {
+				t.Errorf("want storable %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCacheMissStoresAndHitServesVariant(t *testing.T) {
+	Runner = mockRuntime()
+
+	miss := mockCacheProxy("gzip")
+	if miss.serveFromCache() {
+		t.Fatalf("want empty cache to miss")
+	}
+	mockCacheUpstreamResponse(miss, 200, mockCacheHeader(cacheControlS, "max-age=60", "Content-Type", "application/json"), cacheBody)
+	if got := miss.Dwn.Resp.Writer.Header().Get(xCacheS); got != cacheMiss {
+		t.Errorf("want X-Cache %s, got %s", cacheMiss, got)
+	}
+
+	hit := mockCacheProxy("gzip;q=0.5, br;q=0.1")
+	if !hit.serveFromCache() {
+		t.Fatalf("want gzip variant served from cache")
+	}
+	rec := hit.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+	if rec.Code != 200 || rec.Header().Get(xCacheS) != cacheHit || rec.Header().Get(ageS) != "0" {
+		t.Errorf("want 200 with X-Cache HIT and Age 0, got %d %v", rec.Code, rec.Header())
+	}
+	if rec.Header().Get(contentEncoding) != "gzip" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("want cached gzip json, got %v", rec.Header())
+	}
+	if !bytes.Equal(cacheBody, *Gunzip(rec.Body.Bytes())) {
+		t.Errorf("want cached body to decode to upstream body")
+	}
+
+	//br variant isn't stored yet, it gets fetched and merged
+	br := mockCacheProxy("br")
+	if br.serveFromCache() {
+		t.Errorf("want missing br variant to miss")
+	}
+	mockCacheUpstreamResponse(br, 200, mockCacheHeader(cacheControlS, "max-age=60", "Content-Type", "application/json"), cacheBody)
+	if got := len(Runner.ResponseCache.get(br.cache.key).Variants); got != 2 {
+		t.Errorf("want 2 variants cached, got %d", got)
+	}
+
+	//identity isn't compressed and not stored either
+	identity := mockCacheProxy("")
+	if identity.serveFromCache() {
+		t.Errorf("want missing identity variant to miss")
+	}
+}
+
+func TestCacheServesIdentityForIncompressibleContent(t *testing.T) {
+	Runner = mockRuntime()
+	miss := mockCacheProxy("")
+	miss.serveFromCache()
+	mockCacheUpstreamResponse(miss, 200, mockCacheHeader(cacheControlS, "max-age=60", "Content-Type", "image/png"), cacheBody)
+
+	hit := mockCacheProxy("gzip")
+	if !hit.serveFromCache() {
+		t.Fatalf("want identity served for content we don't compress")
+	}
+	if got := hit.Dwn.Resp.ContentEncoding; got != EncIdentity {
+		t.Errorf("want identity, got %s", got)
+	}
+}
+
+func TestCacheNotStored(t *testing.T) {
+	tests := map[string]http.Header{
+		"noFreshnessNoValidator": mockCacheHeader(),
+		"noStore":                mockCacheHeader(cacheControlS, "no-store, max-age=60"),
+	}
+	for name, h := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			proxy := mockCacheProxy("")
+			proxy.serveFromCache()
+			mockCacheUpstreamResponse(proxy, 200, h, cacheBody)
+			if Runner.ResponseCache.len() != 0 {
+				t.Errorf("want nothing cached")
+			}
+		})
+	}
+}
+
+func TestCacheAnswersConditionalRequest(t *testing.T) {
+	Runner = mockRuntime()
+	miss := mockCacheProxy("")
+	miss.serveFromCache()
+	mockCacheUpstreamResponse(miss, 200, mockCacheHeader(cacheControlS, "max-age=60", etagS, `"v1"`,
+		lastModifiedS, "Fri, 01 Jan 2021 00:00:00 GMT"), cacheBody)
+
+	tests := map[string]struct {
+		header string
+		value  string
+		want   int
+	}{
+		"etagMatch":       {ifNoneMatchS, `"v0", W/"v1"`, 304},
+		"etagStar":        {ifNoneMatchS, `*`, 304},
+		"etagNoMatch":     {ifNoneMatchS, `"v0"`, 200},
+		"notModified":     {ifModifiedSinceS, "Sat, 02 Jan 2021 00:00:00 GMT", 304},
+		"modified":        {ifModifiedSinceS, "Thu, 31 Dec 2020 00:00:00 GMT", 200},
+		"invalidModified": {ifModifiedSinceS, "yesterday", 200},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			hit := mockCacheProxy("")
+			hit.Dwn.Req.Header.Set(tt.header, tt.value)
+			if !hit.serveFromCache() {
+				t.Fatalf("want cache hit")
+			}
+			rec := hit.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+			if rec.Code != tt.want {
+				t.Errorf("want status %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want == 304 && rec.Body.Len() > 0 {
+				t.Errorf("want empty body for 304")
+			}
+		})
+	}
+}
+
+func TestCacheRevalidatesStaleEntry(t *testing.T) {
+	Runner = mockRuntime()
+	miss := mockCacheProxy("")
+	miss.serveFromCache()
+	mockCacheUpstreamResponse(miss, 200, mockCacheHeader(cacheControlS, "max-age=0", etagS, `"v1"`), cacheBody)
+	if Runner.ResponseCache.len() != 1 {
+		t.Fatalf("want stale entry with validator cached")
+	}
+
+	stale := mockCacheProxy("")
+	stale.Dwn.Req.Header.Set(ifNoneMatchS, `"client"`)
+	if stale.serveFromCache() {
+		t.Fatalf("want stale entry not served")
+	}
+	h := http.Header{}
+	h.Set(ifNoneMatchS, `"client"`)
+	stale.setConditionalHeaders(h)
+	if got := h.Get(ifNoneMatchS); got != `"v1"` {
+		t.Errorf("want upstream revalidation with cached etag, got %s", got)
+	}
+
+	stale.Up.Atmpt = &stale.Up.Atmpts[0]
+	stale.Up.Atmpt.StatusCode = http.StatusNotModified
+	stale.Up.Atmpt.resp.Header = mockCacheHeader(cacheControlS, "max-age=60", etagS, `"v1"`)
+	empty := []byte{}
+	stale.Up.Atmpt.respBody = &empty
+	if !stale.revalidatedFromCache() {
+		t.Fatalf("want revalidated entry served")
+	}
+	rec := stale.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+	if rec.Code != 200 || rec.Header().Get(xCacheS) != cacheRevalidated || !bytes.Equal(cacheBody, rec.Body.Bytes()) {
+		t.Errorf("want 200 revalidated from cache, got %d %v", rec.Code, rec.Header())
+	}
+
+	fresh := mockCacheProxy("")
+	if !fresh.serveFromCache() {
+		t.Errorf("want refreshed entry served as hit")
+	}
+}
+
+// mockStaleCacheEntry stores a response for mockCacheProxy that went stale d ago
+func mockStaleCacheEntry(d time.Duration, kv ...string) *CacheEntry {
+	miss := mockCacheProxy("")
+	miss.serveFromCache()
+	mockCacheUpstreamResponse(miss, 200, mockCacheHeader(append([]string{cacheControlS, "max-age=60"}, kv...)...), cacheBody)
+	e := *Runner.ResponseCache.get(miss.cache.key)
+	e.Header = e.Header.Clone()
+	e.Header.Set(cacheControlS, "max-age=60")
+	if len(kv) > 0 {
+		e.Header.Set(cacheControlS, "max-age=60, "+kv[1])
+	}
+	e.ResponseTime = time.Now().Add(-time.Minute - d)
+	Runner.ResponseCache.remove(e.Key)
+	Runner.ResponseCache.put(&e)
+	return &e
+}
+
+func TestStaleWithin(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		cc    string
+		stale time.Duration
+		max   time.Duration
+		want  bool
+	}{
+		"withinRouteMax":       {"max-age=60", 5 * time.Second, 10 * time.Second, true},
+		"beyondRouteMax":       {"max-age=60", 15 * time.Second, 10 * time.Second, false},
+		"disabled":             {"max-age=60", time.Second, 0, false},
+		"directiveShortens":    {"max-age=60, stale-while-revalidate=2", 5 * time.Second, 10 * time.Second, false},
+		"directiveCappedByMax": {"max-age=60, stale-while-revalidate=200", 15 * time.Second, 10 * time.Second, false},
+		"mustRevalidate":       {"max-age=60, must-revalidate", time.Second, 10 * time.Second, false},
+		"proxyRevalidate":      {"max-age=60, proxy-revalidate", time.Second, 10 * time.Second, false},
+		"sMaxAge":              {"s-maxage=60", time.Second, 10 * time.Second, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &CacheEntry{
+				Header:       mockCacheHeader(cacheControlS, tt.cc),
+				ResponseTime: now.Add(-time.Minute - tt.stale),
+				Ttl:          time.Minute,
+			}
+			if got := e.staleWithin(ccStaleWhileRevalidate, tt.max, now); got != tt.want {
+				t.Errorf("want stale within %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCacheServesStaleWhileRevalidate(t *testing.T) {
+	Runner = mockRuntime()
+	refreshed := make(chan *Proxy, 2)
+	backgroundRefresh = func(bg *Proxy) { refreshed <- bg }
+	defer func() { backgroundRefresh = handleHTTP }()
+
+	e := mockStaleCacheEntry(5*time.Second, etagS, `"v1"`)
+
+	stale := mockCacheProxy("")
+	stale.Route.Resource = "default"
+	stale.Route.Cache.StaleWhileRevalidateSeconds = 10
+	if !stale.serveFromCache() {
+		t.Fatalf("want stale entry served while revalidating")
+	}
+	rec := stale.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+	if rec.Code != 200 || rec.Header().Get(xCacheS) != cacheStale || !bytes.Equal(cacheBody, rec.Body.Bytes()) {
+		t.Errorf("want 200 stale from cache, got %d %v", rec.Code, rec.Header())
+	}
+
+	var bg *Proxy
+	select {
+	case bg = <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatalf("want background refresh")
+	}
+	if bg.cache.stale.Key != e.Key || bg.Dwn.Method != "GET" || bg.Up.Atmpt == nil {
+		t.Errorf("want background refresh revalidating stale entry")
+	}
+	if _, ok := bg.Dwn.Resp.Writer.(*discardResponseWriter); !ok {
+		t.Errorf("want background refresh response discarded")
+	}
+
+	//only one refresh per key at a time
+	Runner.ResponseCache.startRefresh(e.Key)
+	again := mockCacheProxy("")
+	again.Route.Cache.StaleWhileRevalidateSeconds = 10
+	again.serveFromCache()
+	select {
+	case <-refreshed:
+		t.Errorf("want no second background refresh while one is in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestCacheServesStaleIfError(t *testing.T) {
+	tests := map[string]struct {
+		staleIfError int
+		cc           []string
+		want         bool
+	}{
+		"enabled":        {60, nil, true},
+		"disabled":       {0, nil, false},
+		"mustRevalidate": {60, []string{cacheControlS, "must-revalidate"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			mockStaleCacheEntry(5*time.Second, tt.cc...)
+
+			proxy := mockCacheProxy("")
+			proxy.Route.Cache.StaleIfErrorSeconds = tt.staleIfError
+			if proxy.serveFromCache() {
+				t.Fatalf("want stale entry not served before upstream attempt")
+			}
+			if got := proxy.serveStaleIfError(); got != tt.want {
+				t.Fatalf("want stale if error %v, got %v", tt.want, got)
+			}
+			if tt.want {
+				rec := proxy.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+				if rec.Code != 200 || rec.Header().Get(xCacheS) != cacheStaleIfError {
+					t.Errorf("want 200 stale if error, got %d %v", rec.Code, rec.Header())
+				}
+			}
+		})
+	}
+}
+
+func TestCacheSkipsWebsocketUpgrade(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockCacheProxy("")
+	proxy.Dwn.Req.Header.Set(UpgradeHeader, websocket)
+	if proxy.serveFromCache() || proxy.hasCache() {
+		t.Errorf("want websocket upgrade not cached")
+	}
+}
@@ -0,0 +1,190 @@
+package j8a
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const coalescedFollower = "downstream request coalesced, waiting for upstream response of leader"
+const coalescedShared = "upstream response shared from coalesced leader"
+const coalescedLeaderFailed = "coalesced leader had no upstream response, retrying"
+const coalescedLeaderXRequestID = "coalescedLeaderXRequestID"
+const cookieS = "Cookie"
+const rangeS = "Range"
+const ifRangeS = "If-Range"
+
+// coalescedMethods are safe, so it doesn't matter which of the identical requests is sent upstream.
+var coalescedMethods = []string{"GET", head}
+
+// Coalescer tracks in-flight upstream requests for routes with coalesce, so that concurrent identical requests
+// share one upstream attempt.
+type Coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is an upstream request of a leading proxy, followers wait for done.
+type flight struct {
+	key        string
+	xRequestID string
+	done       chan struct{}
+	once       sync.Once
+	followers  int
+	//nil if the leader had no upstream response to share
+	atmpt *Atmpt
+}
+
+func NewCoalescer() *Coalescer {
+	return &Coalescer{
+		flights: make(map[string]*flight),
+	}
+}
+
+// join returns the flight in progress for key, or starts a new one with the caller as leader.
+func (c *Coalescer) join(key string, xRequestID string) (*flight, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		f.followers++
+		return f, false
+	}
+	f := &flight{
+		key:        key,
+		xRequestID: xRequestID,
+		done:       make(chan struct{}),
+	}
+	c.flights[key] = f
+	return f, true
+}
+
+// land releases the followers of f. Requests arriving afterwards start a new flight.
+func (c *Coalescer) land(f *flight, atmpt *Atmpt) {
+	f.once.Do(func() {
+		c.mu.Lock()
+		delete(c.flights, f.key)
+		c.mu.Unlock()
+		f.atmpt = atmpt
+		close(f.done)
+	})
+}
+
+func (c *Coalescer) followers(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f.followers
+	}
+	return 0
+}
+
+func (c *Coalescer) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.flights)
+}
+
+func (proxy *Proxy) isCoalescable() bool {
+	if Runner.Coalescer == nil || !proxy.Route.Coalesce || proxy.isWebsocketUpgrade() {
+		return false
+	}
+	for _, m := range coalescedMethods {
+		if proxy.Dwn.Method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// coalescedHeaders change the upstream response for the same URI. Conditional and range requests get a 304 or 206
+// that can't be shared with unconditional requests.
+var coalescedHeaders = []string{acceptEncoding, authorizationS, cookieS, ifNoneMatchS, ifModifiedSinceS, rangeS, ifRangeS}
+
+// coalesceKey is the cache key plus request headers that change the upstream response for the same URI.
+func (proxy *Proxy) coalesceKey() string {
+	var b strings.Builder
+	b.WriteString(proxy.cacheKey())
+	for _, h := range coalescedHeaders {
+		b.WriteString("\n")
+		b.WriteString(h)
+		b.WriteString(colon)
+		b.WriteString(strings.Join(proxy.Dwn.Req.Header.Values(h), COMMA))
+	}
+	return b.String()
+}
+
+// coalesce waits for an identical request in flight and serves its upstream response, returning true. It returns
+// false if proxy leads the flight and must make the upstream request itself.
+func (proxy *Proxy) coalesce() bool {
+	key := proxy.coalesceKey()
+	for {
+		f, leader := Runner.Coalescer.join(key, proxy.XRequestID)
+		if leader {
+			proxy.flight = f
+			return false
+		}
+
+		infoOrTraceEv(proxy).
+			Str(XRequestID, proxy.XRequestID).
+			Str(coalescedLeaderXRequestID, f.xRequestID).
+			Msg(coalescedFollower)
+
+		select {
+		case <-f.done:
+		case <-proxy.Dwn.Timeout:
+		case <-proxy.Dwn.Aborted:
+		}
+
+		//followers respect their own downstream timeouts and aborts
+		if proxy.hasDownstreamAbortedOrTimedout() {
+			sendStatusCodeAsJSON(proxy)
+			return true
+		}
+
+		if f.atmpt != nil {
+			proxy.Up.Atmpts = []Atmpt{*f.atmpt}
+			proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+			proxy.Up.Count = 1
+			scaffoldUpAttemptLog(proxy).
+				Str(coalescedLeaderXRequestID, f.xRequestID).
+				Msg(coalescedShared)
+			proxy.sendUpstreamResponse()
+			return true
+		}
+
+		infoOrTraceEv(proxy).
+			Str(XRequestID, proxy.XRequestID).
+			Str(coalescedLeaderXRequestID, f.xRequestID).
+			Msg(coalescedLeaderFailed)
+	}
+}
+
+// shareFlight lets followers copy the upstream attempt, once its body has been read.
+func (proxy *Proxy) shareFlight() {
+	if proxy.flight == nil {
+		return
+	}
+	a := proxy.Up.Atmpt
+	resp := *a.resp
+	resp.Header = a.resp.Header.Clone()
+	resp.Body = http.NoBody
+	Runner.Coalescer.land(proxy.flight, &Atmpt{
+		URL:             a.URL,
+		Label:           a.Label,
+		Count:           a.Count,
+		StatusCode:      a.StatusCode,
+		ContentEncoding: a.ContentEncoding,
+		resp:            &resp,
+		respBody:        a.respBody,
+		//the leader's attempt is cancelled when it's done, followers' copies are not
+		Aborted:   make(chan struct{}),
+		startDate: a.startDate,
+	})
+}
+
+// landFlight releases followers without a response if the leader didn't share one.
+func (proxy *Proxy) landFlight() {
+	if proxy.flight != nil {
+		Runner.Coalescer.land(proxy.flight, nil)
+	}
+}
@@ -0,0 +1,189 @@
+package j8a
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockCoalesceProxy is a GET on a route with coalesce, before its first upstream attempt
+func mockCoalesceProxy(ae string) *Proxy {
+	proxy := mockProxy(cacheBody, "0", "/path", "/path", "/get", "", "")
+	proxy.XRequestID = ae
+	proxy.Up.Atmpt = nil
+	proxy.Dwn.Method = "GET"
+	proxy.Dwn.Host = "localhost"
+	proxy.Dwn.Req.Header.Del(authorizationS)
+	proxy.Dwn.Req.Header.Set(acceptEncoding, ae)
+	proxy.Dwn.AcceptEncoding = mockAcceptEncoding(ae)
+	proxy.Route.Coalesce = true
+	return &proxy
+}
+
+func TestCoalescerJoinAndLand(t *testing.T) {
+	c := NewCoalescer()
+	f1, leader1 := c.join("k", "1")
+	f2, leader2 := c.join("k", "2")
+	if !leader1 || leader2 || f1 != f2 {
+		t.Errorf("want first request to lead and second to follow the same flight")
+	}
+	if _, leader := c.join("other", "3"); !leader {
+		t.Errorf("want different key to lead its own flight")
+	}
+
+	a := &Atmpt{StatusCode: 200}
+	c.land(f1, a)
+	c.land(f1, nil)
+	select {
+	case <-f2.done:
+	default:
+		t.Errorf("want followers released when flight lands")
+	}
+	if f2.atmpt != a {
+		t.Errorf("want first landing to win")
+	}
+	if _, leader := c.join("k", "4"); !leader || c.len() != 2 {
+		t.Errorf("want new flight for key after landing")
+	}
+}
+
+func TestIsCoalescable(t *testing.T) {
+	tests := map[string]struct {
+		method   string
+		coalesce bool
+		want     bool
+	}{
+		"get":         {"GET", true, true},
+		"head":        {"HEAD", true, true},
+		"post":        {"POST", true, false},
+		"routeNotSet": {"GET", false, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			proxy := mockCoalesceProxy("")
+			proxy.Dwn.Method = tt.method
+			proxy.Route.Coalesce = tt.coalesce
+			if got := proxy.isCoalescable(); got != tt.want {
+				t.Errorf("want coalescable %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	Runner = mockRuntime()
+	p1 := mockCoalesceProxy("gzip")
+	p2 := mockCoalesceProxy("gzip")
+	if p1.coalesceKey() != p2.coalesceKey() {
+		t.Errorf("want identical requests to share key")
+	}
+	p2.Dwn.Req.Header.Set(authorizationS, "Bearer x")
+	if p1.coalesceKey() == p2.coalesceKey() {
+		t.Errorf("want authorization in key")
+	}
+	if p1.coalesceKey() == mockCoalesceProxy("br").coalesceKey() {
+		t.Errorf("want accept-encoding in key")
+	}
+	for _, h := range []string{ifNoneMatchS, ifModifiedSinceS, rangeS, ifRangeS} {
+		p3 := mockCoalesceProxy("gzip")
+		p3.Dwn.Req.Header.Set(h, "x")
+		if p1.coalesceKey() == p3.coalesceKey() {
+			t.Errorf("want %s in key", h)
+		}
+	}
+}
+
+func TestCoalesceFollowerSharesLeaderResponse(t *testing.T) {
+	Runner = mockRuntime()
+	leader := mockCoalesceProxy("gzip")
+	if leader.coalesce() {
+		t.Fatalf("want first request to lead")
+	}
+
+	followers := make([]*Proxy, 3)
+	served := make(chan bool, len(followers))
+	for i := range followers {
+		followers[i] = mockCoalesceProxy("gzip")
+	}
+	for _, f := range followers {
+		go func(f *Proxy) { served <- f.coalesce() }(f)
+	}
+	//followers have to join before the leader lands
+	for Runner.Coalescer.followers(leader.flight.key) < len(followers) {
+		time.Sleep(time.Millisecond)
+	}
+
+	leader.Up.Atmpt = &leader.Up.Atmpts[0]
+	leader.Up.Atmpt.StatusCode = 200
+	leader.Up.Atmpt.resp.StatusCode = 200
+	leader.Up.Atmpt.resp.Header = http.Header{"Content-Type": []string{"application/json"}}
+	leader.Up.Atmpt.respBody = &cacheBody
+	leader.shareFlight()
+	leader.landFlight()
+
+	for range followers {
+		if !<-served {
+			t.Errorf("want follower served from leader response")
+		}
+	}
+	for _, f := range followers {
+		rec := f.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+		if rec.Code != 200 || rec.Header().Get(contentEncoding) != "gzip" {
+			t.Errorf("want 200 gzip response, got %d %v", rec.Code, rec.Header())
+		}
+		if !bytes.Equal(cacheBody, *Gunzip(rec.Body.Bytes())) {
+			t.Errorf("want follower body to decode to upstream body")
+		}
+		if f.Up.Atmpt == leader.Up.Atmpt {
+			t.Errorf("want follower to have its own copy of the upstream attempt")
+		}
+	}
+	if Runner.Coalescer.len() != 0 {
+		t.Errorf("want no flights left")
+	}
+}
+
+func TestCoalesceFollowerLeadsWhenLeaderFails(t *testing.T) {
+	Runner = mockRuntime()
+	leader := mockCoalesceProxy("")
+	leader.coalesce()
+
+	follower := mockCoalesceProxy("")
+	served := make(chan bool)
+	go func() { served <- follower.coalesce() }()
+	for Runner.Coalescer.followers(leader.flight.key) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	leader.landFlight()
+	if <-served {
+		t.Errorf("want follower to make its own upstream request")
+	}
+	if follower.flight == nil || follower.flight == leader.flight {
+		t.Errorf("want follower to lead a new flight")
+	}
+}
+
+func TestCoalesceFollowerTimesOut(t *testing.T) {
+	Runner = mockRuntime()
+	leader := mockCoalesceProxy("")
+	leader.coalesce()
+
+	follower := mockCoalesceProxy("")
+	timeout := make(chan struct{})
+	close(timeout)
+	follower.Dwn.Timeout = timeout
+
+	if !follower.coalesce() {
+		t.Errorf("want timed out follower to respond")
+	}
+	if got := follower.Dwn.Resp.Writer.(*httptest.ResponseRecorder).Code; got != 504 {
+		t.Errorf("want 504 for follower downstream timeout, got %d", got)
+	}
+	if Runner.Coalescer.len() != 1 {
+		t.Errorf("want leader flight still in progress")
+	}
+}
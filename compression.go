@@ -0,0 +1,183 @@
+package j8a
+
+import (
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"mime"
+	pathpkg "path"
+	"strings"
+	"sync"
+)
+
+const defaultCompressionLevel = 1
+const defaultMaxTranscodeBytes int64 = 16 << 20
+
+var defaultCompressionEncodings = AcceptEncoding{EncGzip, EncBrotli, EncZstd, EncDeflate}
+
+var defaultCompressionContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+// compressionLevels is the valid range of levels per encoding.
+var compressionLevels = map[ContentEncoding][2]int{
+	EncGzip:    {gzip.BestSpeed, gzip.BestCompression},
+	EncDeflate: {flate.BestSpeed, flate.BestCompression},
+	EncBrotli:  {brotli.BestSpeed, brotli.BestCompression},
+	EncZstd:    {1, 22},
+}
+
+func (c Compression) preference() AcceptEncoding {
+	if len(c.Encodings) == 0 {
+		return defaultCompressionEncodings
+	}
+	var p AcceptEncoding
+	for _, e := range c.Encodings {
+		p = append(p, NewContentEncoding(e))
+	}
+	return p
+}
+
+func (c Compression) level(enc ContentEncoding) int {
+	if l, ok := c.Levels[string(enc)]; ok {
+		return l
+	}
+	return defaultCompressionLevel
+}
+
+func (c Compression) maxTranscodeBytes() int64 {
+	if c.MaxTranscodeBytes > 0 {
+		return c.MaxTranscodeBytes
+	}
+	return defaultMaxTranscodeBytes
+}
+
+func (c Compression) contentTypes() []string {
+	if len(c.ContentTypes) == 0 {
+		return defaultCompressionContentTypes
+	}
+	return c.ContentTypes
+}
+
+// allows is true if the policy compresses a body of contentType and size. We don't know what's in bodies without
+// Content-Type, so we compress them like before.
+func (c Compression) allows(contentType string, size int) bool {
+	if size < c.MinBodyBytes {
+		return false
+	}
+	if len(contentType) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, ct := range c.contentTypes() {
+		if m, _ := pathpkg.Match(strings.ToLower(ct), mediaType); m {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate picks the downstream encoding for a body of contentType and size. With preferIdentity, identity wins
+// over compression on equal q-value. Returns identity if the policy doesn't compress the body or no
+// encoding we produce is acceptable.
+func (c Compression) negotiate(ae AcceptEncoding, preferIdentity bool, contentType string, size int) ContentEncoding {
+	if !c.allows(contentType, size) {
+		return EncIdentity
+	}
+	preference := c.preference()
+	if preferIdentity {
+		preference = append(AcceptEncoding{EncIdentity}, preference...)
+	}
+	if enc := ae.negotiate(preference); len(enc) > 0 {
+		return enc
+	}
+	return EncIdentity
+}
+
+// encode body with enc at the configured level. identity is returned as is.
+func (c Compression) encode(enc ContentEncoding, body []byte) *[]byte {
+	switch {
+	case enc.isGzip():
+		return GzipLevel(body, c.level(EncGzip))
+	case enc.isBrotli():
+		return BrotliEncodeLevel(body, c.level(EncBrotli))
+	case enc.isZstd():
+		return ZstdEncodeLevel(body, c.level(EncZstd))
+	case enc.isDeflate():
+		return DeflateLevel(body, c.level(EncDeflate))
+	default:
+		return &body
+	}
+}
+
+// decodable is true for atomic encodings we can decode.
+func (c Compression) decodable(enc ContentEncoding) bool {
+	return enc.isGzip() || enc.isBrotli() || enc.isZstd() || enc.isDeflate()
+}
+
+const decodeTooLarge = "decoded body exceeds %d bytes"
+
+// decode body from enc up to limit bytes. Unlike Gunzip and friends we report corrupt bodies and bodies that inflate
+// beyond limit, so they can still be passed through.
+func decode(enc ContentEncoding, body []byte, limit int64) ([]byte, error) {
+	rd, err := decompressReader(enc, body, limit)
+	if err != nil {
+		return nil, err
+	}
+	//we read one byte more to detect exceeding the limit.
+	dec, err := ioutil.ReadAll(io.LimitReader(rd, limit+1))
+	if c, ok := rd.(io.Closer); ok {
+		c.Close()
+	} else if z, ok := rd.(*zstd.Decoder); ok {
+		z.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(dec)) > limit {
+		return nil, fmt.Errorf(decodeTooLarge, limit)
+	}
+	return dec, nil
+}
+
+// levelPools holds one encoder pool per compression level. Levels outside the range are clamped.
+type levelPools struct {
+	min   int
+	pools []*sync.Pool
+}
+
+func newLevelPools(min int, max int, newEncoder func(level int) interface{}) levelPools {
+	lp := levelPools{min: min}
+	for l := min; l <= max; l++ {
+		level := l
+		lp.pools = append(lp.pools, &sync.Pool{
+			New: func() interface{} {
+				return newEncoder(level)
+			},
+		})
+	}
+	return lp
+}
+
+func (lp levelPools) get(level int) *sync.Pool {
+	i := level - lp.min
+	if i < 0 {
+		i = 0
+	} else if i >= len(lp.pools) {
+		i = len(lp.pools) - 1
+	}
+	return lp.pools[i]
+}
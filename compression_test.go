@@ -0,0 +1,167 @@
+package j8a
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func mockAcceptEncoding(raw string) AcceptEncoding {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set(AcceptEncodingS, raw)
+	return parseAcceptEncoding(req)
+}
+
+func TestCompressionAllows(t *testing.T) {
+	tests := map[string]struct {
+		compression Compression
+		contentType string
+		size        int
+		want        bool
+	}{
+		"json":                 {Compression{}, "application/json; charset=utf-8", 100, true},
+		"html":                 {Compression{}, "text/html", 100, true},
+		"ldJson":               {Compression{}, "application/ld+json", 100, true},
+		"svg":                  {Compression{}, "image/svg+xml", 100, true},
+		"noContentType":        {Compression{}, "", 100, true},
+		"jpeg":                 {Compression{}, "image/jpeg", 100, false},
+		"zip":                  {Compression{}, "application/zip", 100, false},
+		"malformedContentType": {Compression{}, "text/html; ===", 100, false},
+		"belowMinBodyBytes":    {Compression{MinBodyBytes: 1024}, "application/json", 1023, false},
+		"atMinBodyBytes":       {Compression{MinBodyBytes: 1024}, "application/json", 1024, true},
+		"customContentType":    {Compression{ContentTypes: []string{"application/octet-stream"}}, "application/octet-stream", 100, true},
+		"customExcludesJson":   {Compression{ContentTypes: []string{"text/*"}}, "application/json", 100, false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.compression.allows(tt.contentType, tt.size); got != tt.want {
+				t.Errorf("compression allows %s size %d want %v, got %v", tt.contentType, tt.size, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompressionNegotiate(t *testing.T) {
+	tests := map[string]struct {
+		compression    Compression
+		acceptEncoding string
+		preferIdentity bool
+		want           ContentEncoding
+	}{
+		"serverPreferenceGzip":        {Compression{}, "br, gzip", false, EncGzip},
+		"higherQBrotli":               {Compression{}, "gzip;q=0.5, br", false, EncBrotli},
+		"refusedGzip":                 {Compression{}, "gzip;q=0, br", false, EncBrotli},
+		"refusedGzipUpperQ":           {Compression{}, "gzip;Q=0, br", false, EncBrotli},
+		"refusedGzipWithStar":         {Compression{}, "*, gzip;q=0", false, EncBrotli},
+		"refusedAll":                  {Compression{}, "gzip;q=0, identity", false, EncIdentity},
+		"starRefused":                 {Compression{}, "*;q=0", false, EncIdentity},
+		"customPreference":            {Compression{Encodings: []string{"zstd", "gzip"}}, "gzip, zstd", false, EncZstd},
+		"customPreferenceExcludes":    {Compression{Encodings: []string{"gzip"}}, "br", false, EncIdentity},
+		"preferIdentity":              {Compression{}, "gzip, identity", true, EncIdentity},
+		"preferIdentityLowerQ":        {Compression{}, "gzip, identity;q=0.5", true, EncGzip},
+		"preferIdentityNotAcceptable": {Compression{}, "br", true, EncBrotli},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ae := mockAcceptEncoding(tt.acceptEncoding)
+			if got := tt.compression.negotiate(ae, tt.preferIdentity, "application/json", 100); got != tt.want {
+				t.Errorf("negotiate %s want %v, got %v", tt.acceptEncoding, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompressionNegotiateIdentityForDisallowedContentType(t *testing.T) {
+	ae := AcceptEncoding{EncGzip}
+	if got := (Compression{}).negotiate(ae, false, "image/jpeg", 100000); got != EncIdentity {
+		t.Errorf("jpeg should not be compressed, got %v", got)
+	}
+}
+
+func TestCompressionEncodeLevels(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"key":"value","another":"thing","number":12345},`), 1000)
+
+	fast := Compression{}
+	best := Compression{Levels: map[string]int{"gzip": 9, "br": 11, "zstd": 22, "deflate": 9}}
+
+	tests := map[ContentEncoding]func([]byte) *[]byte{
+		EncGzip:    Gunzip,
+		EncBrotli:  BrotliDecode,
+		EncZstd:    ignoreDecodeErr(ZstdDecode),
+		EncDeflate: ignoreDecodeErr(Inflate),
+	}
+	for enc, decode := range tests {
+		f := *fast.encode(enc, body)
+		b := *best.encode(enc, body)
+		if len(b) > len(f) {
+			t.Errorf("%v at highest level should not be larger than at level 1, got %d > %d", enc, len(b), len(f))
+		}
+		if !bytes.Equal(body, *decode(b)) {
+			t.Errorf("%v at highest level did not decode to original", enc)
+		}
+	}
+}
+
+func TestCompressionEncodeIdentity(t *testing.T) {
+	body := []byte("identity")
+	if got := *(Compression{}).encode(EncIdentity, body); !bytes.Equal(body, got) {
+		t.Errorf("identity should not be encoded, got %v", got)
+	}
+}
+
+func TestLevelPoolsClamp(t *testing.T) {
+	lp := newLevelPools(1, 9, func(level int) interface{} {
+		return level
+	})
+	if got := lp.get(0).Get().(int); got != 1 {
+		t.Errorf("level below range should clamp to 1, got %d", got)
+	}
+	if got := lp.get(12).Get().(int); got != 9 {
+		t.Errorf("level above range should clamp to 9, got %d", got)
+	}
+	if got := lp.get(5).Get().(int); got != 5 {
+		t.Errorf("level in range should be 5, got %d", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	body := []byte(`{"key":"value"}`)
+	tests := map[ContentEncoding][]byte{
+		EncGzip:     *Gzip(body),
+		EncXGzip:    *Gzip(body),
+		EncBrotli:   *BrotliEncode(body),
+		EncZstd:     *ZstdEncode(body),
+		EncDeflate:  *Deflate(body),
+		EncXDeflate: *Deflate(body),
+	}
+	for enc, encoded := range tests {
+		dec, err := decode(enc, encoded, 1<<20)
+		if err != nil || !bytes.Equal(body, dec) {
+			t.Errorf("%v should decode to original, got %s, err %v", enc, dec, err)
+		}
+	}
+}
+
+func TestDecodeFails(t *testing.T) {
+	if _, err := decode(EncGzip, []byte("notgzip"), 1<<20); err == nil {
+		t.Errorf("corrupt gzip should not decode")
+	}
+	if _, err := decode(EncCompress, []byte("compressed"), 1<<20); err == nil {
+		t.Errorf("compress should not decode")
+	}
+}
+
+func TestDecodeFailsAboveLimit(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 1<<20)
+	for _, enc := range []ContentEncoding{EncGzip, EncBrotli, EncZstd, EncDeflate} {
+		encoded := *(Compression{}).encode(enc, body)
+		if _, err := decode(enc, encoded, 1<<10); err == nil {
+			t.Errorf("%v should not decode beyond limit", enc)
+		}
+		if dec, err := decode(enc, encoded, 1<<20); err != nil || len(dec) != len(body) {
+			t.Errorf("%v should decode up to limit, got %v", enc, err)
+		}
+	}
+}
@@ -2,13 +2,17 @@ package j8a
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"golang.org/x/net/idna"
 	"io/ioutil"
 	"net"
+	"net/textproto"
+	"net/url"
 	"os"
+	pathpkg "path"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,6 +36,13 @@ type Config struct {
 	DisableXRequestInfo bool
 	TimeZone            string
 	LogLevel            string
+	Cache               Cache
+}
+
+// Cache is the in-memory response cache shared by all routes with cache
+type Cache struct {
+	// MaxBytes of cached responses, least recently used are evicted first. Defaults to 64MiB
+	MaxBytes int64
 }
 
 const HTTP = "HTTP"
@@ -149,11 +160,23 @@ func (config Config) validateTimeZone() *Config {
 
 func (config Config) validateResources() *Config {
 	for name := range config.Resources {
+		if name == purge {
+			config.panic(fmt.Sprintf("resource name '%v' is reserved for routes purging the cache, see https://j8a.io/docs", name))
+		}
 		resourceMappings := config.Resources[name]
 		if len(resourceMappings) == 0 {
 			config.panic(fmt.Sprintf("resource '%v' needs to have at least one url, see https://j8a.io/docs", name))
 		}
 		for _, r := range resourceMappings {
+			if r.URL.Scheme == fileScheme {
+				if len(resourceMappings) > 1 {
+					config.panic(fmt.Sprintf("resource '%v' with file url can only have one url", name))
+				}
+				if e := r.validFileRoot(); e != nil {
+					config.panic(fmt.Sprintf("resource '%v' %v", name, e))
+				}
+				continue
+			}
 			iPort, e := strconv.Atoi(r.URL.Port)
 			if e != nil {
 				config.panic(fmt.Sprintf("resource '%v' needs to have port between 1 and 65535, was: %v", name, r.URL.Port))
@@ -281,11 +304,134 @@ func (config Config) validateRoutes() *Config {
 				config.panic(fmt.Sprintf("host pattern %s invalid, cause %v", config.Routes[i].Host, e2))
 			}
 		}
-		if len(config.Routes[i].Resource) == 0 {
+		if d := config.Routes[i].Decompress; d != nil {
+			if d.MaxBytes < 0 || d.MaxRatio < 0 {
+				config.panic(fmt.Sprintf("route %s decompress maxBytes and maxRatio must not be negative", config.Routes[i].Path))
+			}
+			if len(d.Recompress) > 0 {
+				if _, ok := compressionLevels[canonicalCompressionEncoding(d.Recompress)]; !ok {
+					config.panic(fmt.Sprintf("route %s decompress recompress %s not one of gzip, br, zstd, deflate", config.Routes[i].Path, d.Recompress))
+				}
+			}
+		}
+		if c := config.Routes[i].Cache; c != nil {
+			vary := make([]string, 0, len(c.Vary))
+			for _, h := range c.Vary {
+				h = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(h))
+				if len(h) == 0 || h == STAR {
+					config.panic(fmt.Sprintf("route %s cache vary header '%s' invalid", config.Routes[i].Path, h))
+				}
+				vary = append(vary, h)
+			}
+			//sorted for stable cache keys
+			sort.Strings(vary)
+			c.Vary = vary
+			if c.StaleWhileRevalidateSeconds < 0 || c.StaleIfErrorSeconds < 0 {
+				config.panic(fmt.Sprintf("route %s cache staleWhileRevalidateSeconds and staleIfErrorSeconds must not be negative", config.Routes[i].Path))
+			}
+		}
+		if len(config.Routes[i].StickyCookie) > 0 && len(config.Routes[i].Policy) == 0 {
+			config.panic(fmt.Sprintf("route %s stickyCookie requires a policy", config.Routes[i].Path))
+		}
+		if b := config.Routes[i].Balance; b != nil {
+			switch b.Hash {
+			case balanceIP, balanceHeader, balanceCookie, balanceJwtSub:
+			default:
+				config.panic(fmt.Sprintf("route %s balance hash must be one of %v, was %s", config.Routes[i].Path, balanceHashes, b.Hash))
+			}
+			if (b.Hash == balanceHeader || b.Hash == balanceCookie) && len(b.Key) == 0 {
+				config.panic(fmt.Sprintf("route %s balance hash %s requires a key", config.Routes[i].Path, b.Hash))
+			}
+			if b.Hash == balanceJwtSub && !config.Routes[i].hasJwt() {
+				config.panic(fmt.Sprintf("route %s balance hash %s requires a jwt", config.Routes[i].Path, b.Hash))
+			}
+			if b.UnhealthySeconds < 0 {
+				config.panic(fmt.Sprintf("route %s balance unhealthySeconds must not be negative", config.Routes[i].Path))
+			}
+			if b.UnhealthySeconds == 0 {
+				b.UnhealthySeconds = balanceDefaultUnhealthySeconds
+			}
+		}
+		if r := config.Routes[i].Retry; r != nil {
+			if r.MaxAttempts < 0 || r.BackoffMillis < 0 || r.MaxBackoffMillis < 0 || r.BudgetMillis < 0 {
+				config.panic(fmt.Sprintf("route %s retry values must not be negative", config.Routes[i].Path))
+			}
+			if r.MaxAttempts == 0 {
+				r.MaxAttempts = retryDefaultMaxAttempts
+			}
+			for _, c := range r.On {
+				if c != retryConnect && c != retryTimeout {
+					config.panic(fmt.Sprintf("route %s retry on must be one of %v, was %s", config.Routes[i].Path, retryConditions, c))
+				}
+			}
+			for _, s := range r.StatusCodes {
+				if s < 500 || s > 599 {
+					config.panic(fmt.Sprintf("route %s retry statusCodes must be 5xx, was %d", config.Routes[i].Path, s))
+				}
+			}
+			if len(r.On) == 0 && len(r.StatusCodes) == 0 {
+				r.On = append([]string{}, retryConditions...)
+				r.StatusCodes = append([]int{}, retryDefaultStatusCodes...)
+			}
+		}
+		for j, m := range config.Routes[i].Methods {
+			m = strings.ToUpper(strings.TrimSpace(m))
+			legal := false
+			for _, l := range httpLegalMethods {
+				legal = legal || l == m
+			}
+			if !legal {
+				config.panic(fmt.Sprintf("route %s method %s must be one of %v", config.Routes[i].Path, m, httpLegalMethods))
+			}
+			config.Routes[i].Methods[j] = m
+		}
+		if config.Routes[i].MaxBodyBytes < 0 {
+			config.panic(fmt.Sprintf("route %s maxBodyBytes must not be negative", config.Routes[i].Path))
+		}
+		if t := config.Routes[i].Timeouts; t != nil {
+			if t.RoundTripMillis < 0 || t.UpstreamReadMillis < 0 || t.UpstreamSocketMillis < 0 {
+				config.panic(fmt.Sprintf("route %s timeouts must not be negative", config.Routes[i].Path))
+			}
+		}
+		if h := config.Routes[i].Hedge; h != nil {
+			if h.DelayMillis < 0 || h.MaxParallel < 0 {
+				config.panic(fmt.Sprintf("route %s hedge values must not be negative", config.Routes[i].Path))
+			}
+			if h.Percentile < 0 || h.Percentile >= 100 {
+				config.panic(fmt.Sprintf("route %s hedge percentile must be between 0 and 100, was %v", config.Routes[i].Path, h.Percentile))
+			}
+			if h.DelayMillis == 0 && h.Percentile == 0 {
+				config.panic(fmt.Sprintf("route %s hedge requires delayMillis or percentile", config.Routes[i].Path))
+			}
+			if h.MaxParallel == 0 {
+				h.MaxParallel = hedgeDefaultMaxParallel
+			}
+		}
+		if m := config.Routes[i].Mirror; m != nil {
+			if r, ok := config.Resources[m.Resource]; !ok {
+				config.panic(fmt.Sprintf("route %s mirror resource %s is not declared", config.Routes[i].Path, m.Resource))
+			} else if r[0].URL.Scheme == fileScheme {
+				config.panic(fmt.Sprintf("route %s mirror resource %s can't have a file url", config.Routes[i].Path, m.Resource))
+			}
+			if m.Percent < 0 || m.Percent > 100 {
+				config.panic(fmt.Sprintf("route %s mirror percent must be between 0 and 100, was %v", config.Routes[i].Path, m.Percent))
+			}
+			if m.Percent == 0 {
+				m.Percent = 100
+			}
+		}
+		if config.Routes[i].hasAction() {
+			if len(config.Routes[i].Resource) > 0 {
+				config.panic(fmt.Sprintf("route %s with redirect or respond can't have a resource", config.Routes[i].Path))
+			}
+		} else if len(config.Routes[i].Resource) == 0 {
 			config.panic(fmt.Sprintf("route %s must have a resource", config.Routes[i].Path))
 		} else {
 			res := config.Routes[i].Resource
-			if res != about {
+			if res == purge && !config.Routes[i].hasJwt() {
+				config.panic(fmt.Sprintf("route %s with resource purge must have a jwt", config.Routes[i].Path))
+			}
+			if res != about && res != purge {
 				_, ok := config.Resources[res]
 				if !ok {
 					config.panic(fmt.Sprintf("route %s must have a resource, but %s is not declared", config.Routes[i].Path, res))
@@ -293,7 +439,7 @@ func (config Config) validateRoutes() *Config {
 			}
 		}
 	}
-	sort.Sort(Routes(config.Routes))
+	sort.Stable(Routes(config.Routes))
 	return &config
 }
 
@@ -330,6 +476,38 @@ func (config Config) compileRouteTransforms() *Config {
 		if len(config.Routes[i].Transform) > 0 && config.Routes[i].Transform[:1] != "/" {
 			config.panic(fmt.Sprintf("config error, illegal route transform %s", route.Transform))
 		}
+		if err := config.Routes[i].compileRewrite(); err != nil {
+			config.panic(fmt.Sprintf("config error, route %s %v", route.Path, err))
+		}
+	}
+	return &config
+}
+
+func (config Config) compileRouteMatchers() *Config {
+	for i := range config.Routes {
+		if err := config.Routes[i].compileMatchers(); err != nil {
+			config.panic(fmt.Sprintf("config error, route %s %v", config.Routes[i].Path, err))
+		}
+	}
+	return &config
+}
+
+func (config Config) compileRouteActions() *Config {
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.Redirect != nil && route.Respond != nil {
+			config.panic(fmt.Sprintf("config error, route %s must have either redirect or respond", route.Path))
+		}
+		if route.Redirect != nil {
+			if err := route.compileRedirect(); err != nil {
+				config.panic(fmt.Sprintf("config error, route %s %v", route.Path, err))
+			}
+		}
+		if route.Respond != nil {
+			if err := route.compileRespond(); err != nil {
+				config.panic(fmt.Sprintf("config error, route %s %v", route.Path, err))
+			}
+		}
 	}
 	return &config
 }
@@ -419,11 +597,157 @@ func (config Config) validateHTTPConfig() *Config {
 	return &config
 }
 
+func (config Config) loadTlsFiles() *Config {
+	certFile := len(config.Connection.Downstream.Tls.CertFile) > 0
+	keyFile := len(config.Connection.Downstream.Tls.KeyFile) > 0
+
+	if certFile || keyFile {
+		if !(certFile && keyFile) {
+			config.panic("TLS certFile and keyFile must be specified together")
+		}
+
+		if len(config.Connection.Downstream.Tls.Cert) > 0 || len(config.Connection.Downstream.Tls.Key) > 0 {
+			config.panic("cannot specify TLS cert and key with certFile and keyFile, use one or the other.")
+		}
+
+		cert, key, e := readTlsFiles(config.Connection.Downstream.Tls.CertFile, config.Connection.Downstream.Tls.KeyFile)
+		if e != nil {
+			config.panic(fmt.Sprintf("unable to read TLS certFile and keyFile, cause: %v", e))
+		}
+
+		config.Connection.Downstream.Tls.Cert = string(cert)
+		config.Connection.Downstream.Tls.Key = string(key)
+		log.Info().Msgf("TLS cert loaded from certFile %s and keyFile %s", config.Connection.Downstream.Tls.CertFile, config.Connection.Downstream.Tls.KeyFile)
+	}
+
+	return &config
+}
+
+func (config Config) validateSessionTickets() *Config {
+	st := config.Connection.Downstream.Tls.SessionTickets
+
+	if len(st.SecretFile) > 0 && len(st.SecretEnv) > 0 {
+		config.panic("TLS session tickets secretFile and secretEnv cannot be specified together")
+	}
+
+	if st.Disable && st.hasSecret() {
+		config.panic("TLS session tickets disabled, cannot specify secret.")
+	}
+
+	if st.hasSecret() {
+		if _, e := st.loadSecret(); e != nil {
+			config.panic(fmt.Sprintf("unable to load TLS session ticket secret, cause: %v", e))
+		}
+	}
+
+	if st.RotationHours == 0 {
+		config.Connection.Downstream.Tls.SessionTickets.RotationHours = sessionTicketDefaultRotationHours
+	} else if st.RotationHours < 0 {
+		config.panic(fmt.Sprintf("TLS session tickets rotationHours must be greater than 0, was %d", st.RotationHours))
+	}
+
+	if st.PreviousKeys == 0 {
+		config.Connection.Downstream.Tls.SessionTickets.PreviousKeys = sessionTicketDefaultPreviousKeys
+	} else if st.PreviousKeys < 0 {
+		config.panic(fmt.Sprintf("TLS session tickets previousKeys must be greater than 0, was %d", st.PreviousKeys))
+	}
+
+	return &config
+}
+
+// canonicalCompressionEncoding maps x-gzip and x-deflate aliases to the encodings j8a produces.
+func canonicalCompressionEncoding(raw string) ContentEncoding {
+	enc := NewContentEncoding(raw)
+	if enc.isGzip() {
+		return EncGzip
+	} else if enc.isDeflate() {
+		return EncDeflate
+	}
+	return enc
+}
+
+func (config Config) validateCompression() *Config {
+	c := config.Connection.Downstream.Compression
+
+	var encodings []string
+	for _, e := range c.Encodings {
+		enc := canonicalCompressionEncoding(e)
+		if _, ok := compressionLevels[enc]; !ok {
+			config.panic(fmt.Sprintf("compression encoding %s not supported, must be one of gzip, br, zstd, deflate", e))
+		}
+		encodings = append(encodings, string(enc))
+	}
+	config.Connection.Downstream.Compression.Encodings = encodings
+
+	if c.MinBodyBytes < 0 {
+		config.panic(fmt.Sprintf("compression minBodyBytes must not be negative, was %d", c.MinBodyBytes))
+	}
+
+	if c.MaxTranscodeBytes < 0 {
+		config.panic(fmt.Sprintf("compression maxTranscodeBytes must not be negative, was %d", c.MaxTranscodeBytes))
+	}
+
+	var contentTypes []string
+	for _, ct := range c.ContentTypes {
+		ct = strings.ToLower(strings.TrimSpace(ct))
+		if _, e := pathpkg.Match(ct, emptyString); e != nil || !strings.Contains(ct, "/") {
+			config.panic(fmt.Sprintf("compression contentType %s is not a valid media type pattern", ct))
+		}
+		contentTypes = append(contentTypes, ct)
+	}
+	config.Connection.Downstream.Compression.ContentTypes = contentTypes
+
+	levels := make(map[string]int)
+	for e, l := range c.Levels {
+		enc := canonicalCompressionEncoding(e)
+		r, ok := compressionLevels[enc]
+		if !ok {
+			config.panic(fmt.Sprintf("compression level for unsupported encoding %s", e))
+		}
+		if l < r[0] || l > r[1] {
+			config.panic(fmt.Sprintf("compression level for %s must be between %d and %d, was %d", enc, r[0], r[1], l))
+		}
+		levels[string(enc)] = l
+	}
+	config.Connection.Downstream.Compression.Levels = levels
+
+	log.Info().Msgf("compression encodings %v for content types %v, min body bytes %d",
+		config.Connection.Downstream.Compression.preference().Print(),
+		strings.Join(config.Connection.Downstream.Compression.contentTypes(), commaSpace), c.MinBodyBytes)
+	return &config
+}
+
+func (config Config) validatePolicies() *Config {
+	for name, policy := range config.Policies {
+		for _, lw := range policy {
+			for _, m := range lw.Match {
+				if !m.isValid() {
+					config.panic(fmt.Sprintf("policy %s label %s match rule must have a value and one of header, cookie or claim", name, lw.Label))
+				}
+			}
+		}
+	}
+	return &config
+}
+
+func (config Config) validateCache() *Config {
+	if config.Cache.MaxBytes < 0 {
+		config.panic(fmt.Sprintf("cache maxBytes must not be negative, was %d", config.Cache.MaxBytes))
+	}
+	if config.Cache.MaxBytes == 0 {
+		config.Cache.MaxBytes = cacheDefaultMaxBytes
+	}
+	if config.Routes.haveCache() {
+		log.Info().Msgf("response cache max bytes %d", config.Cache.MaxBytes)
+	}
+	return &config
+}
+
 const wildcardDomainPrefix = "*."
 const dot = "."
 
 func (config Config) validateAcmeConfig() *Config {
-	acmeProvider := len(config.Connection.Downstream.Tls.Acme.Provider) > 0
+	acmeProvider := len(config.Connection.Downstream.Tls.Acme.Provider) > 0 || len(config.Connection.Downstream.Tls.Acme.DirectoryUrl) > 0
 	acmeDomain := len(config.Connection.Downstream.Tls.Acme.Domains) > 0 && len(config.Connection.Downstream.Tls.Acme.Domains[0]) > 0
 	acmeEmail := len(config.Connection.Downstream.Tls.Acme.Email) > 0
 
@@ -442,10 +766,30 @@ func (config Config) validateAcmeConfig() *Config {
 			config.panic("cannot specify TLS private key with ACME configuration, it would be overridden.")
 		}
 
-		if config.Connection.Downstream.Http.Port != 80 {
+		challenge := strings.ToLower(config.Connection.Downstream.Tls.Acme.Challenge)
+		if len(challenge) == 0 {
+			config.Connection.Downstream.Tls.Acme.Challenge = http01S
+		} else if challenge != http01S && challenge != dns01S && challenge != tlsAlpn01S {
+			config.panic(fmt.Sprintf("ACME challenge must be one of %s | %s | %s, was %s", http01S, dns01S, tlsAlpn01S, challenge))
+		} else {
+			config.Connection.Downstream.Tls.Acme.Challenge = challenge
+		}
+		dns01Challenge := config.Connection.Downstream.Tls.Acme.isDns01()
+
+		if config.Connection.Downstream.Tls.Acme.Challenge == http01S && config.Connection.Downstream.Http.Port != 80 {
 			config.panic("HTTP listener must be configured and set to port 80 for ACME challenge")
 		}
 
+		if config.Connection.Downstream.Tls.Acme.isTlsAlpn01() && config.Connection.Downstream.Tls.Port != 443 {
+			config.panic(fmt.Sprintf("TLS listener must be configured and set to port 443 for ACME %s challenge", tlsAlpn01S))
+		}
+
+		if dns01Challenge {
+			if _, e := newAcmeDnsProvider(config.Connection.Downstream.Tls.Acme.Dns); e != nil {
+				config.panic(e.Error())
+			}
+		}
+
 		if !acmeProvider {
 			config.panic("ACME provider must be specified in ACME config")
 		}
@@ -460,6 +804,14 @@ func (config Config) validateAcmeConfig() *Config {
 
 		// ACME domain checks
 		for _, domain := range config.Connection.Downstream.Tls.Acme.Domains {
+			if strings.HasPrefix(domain, wildcardDomainPrefix) {
+				if !dns01Challenge {
+					config.panic(fmt.Sprintf("ACME domain validation does not support wildcard domain names without %s challenge, was %s", dns01S, domain))
+				}
+				//validate what's left of the wildcard like any other name.
+				domain = domain[len(wildcardDomainPrefix):]
+			}
+
 			if !govalidator.IsDNSName(domain) {
 				config.panic(fmt.Sprintf("ACME domain must be a valid DNS name, but was %s", domain))
 			}
@@ -468,8 +820,8 @@ func (config Config) validateAcmeConfig() *Config {
 				config.panic(fmt.Sprintf("ACME domain must be a valid FQDN name, but was %s", domain))
 			}
 
-			if domain[0:1] == wildcardDomainPrefix {
-				config.panic(fmt.Sprintf("ACME domain validation does not support wildcard domain names, was %s", domain))
+			if strings.Contains(domain, wildcard) {
+				config.panic(fmt.Sprintf("ACME domain validation only supports wildcards as the leftmost label, was %s", domain))
 			}
 
 			if string(domain[0]) == dot {
@@ -482,16 +834,39 @@ func (config Config) validateAcmeConfig() *Config {
 		}
 
 		// ACME provider checks
-		if _, supported := acmeProviders[config.Connection.Downstream.Tls.Acme.Provider]; !supported {
+		if len(config.Connection.Downstream.Tls.Acme.DirectoryUrl) > 0 {
+			u, e := url.Parse(config.Connection.Downstream.Tls.Acme.DirectoryUrl)
+			if e != nil || u.Scheme != "https" || len(u.Host) == 0 {
+				config.panic(fmt.Sprintf("ACME directory URL must be a valid https URL, but was %s", config.Connection.Downstream.Tls.Acme.DirectoryUrl))
+			}
+			//provider names the cache directory, so we need one.
+			if len(config.Connection.Downstream.Tls.Acme.Provider) == 0 {
+				config.Connection.Downstream.Tls.Acme.Provider = u.Hostname()
+			}
+		} else if _, supported := acmeProviders[config.Connection.Downstream.Tls.Acme.Provider]; !supported {
 			config.panic(fmt.Sprintf("ACME provider not supported: %s", config.Connection.Downstream.Tls.Acme.Provider))
 		}
 
+		// ACME external account binding checks
+		eabKeyId := len(config.Connection.Downstream.Tls.Acme.EabKeyId) > 0
+		eabHmacKey := len(config.Connection.Downstream.Tls.Acme.EabHmacKey) > 0
+		if eabKeyId != eabHmacKey {
+			config.panic("ACME external account binding needs both eabKeyId and eabHmacKey")
+		}
+		if _, e := base64.RawURLEncoding.DecodeString(config.Connection.Downstream.Tls.Acme.EabHmacKey); eabHmacKey && e != nil {
+			config.panic("ACME eabHmacKey must be base64url encoded")
+		}
+
 		// ACME email checks
 		if !govalidator.IsEmail(config.Connection.Downstream.Tls.Acme.Email) {
 			config.panic(fmt.Sprintf("ACME email must be a valid email address, but was %s", config.Connection.Downstream.Tls.Acme.Email))
 		}
 
-		log.Info().Msgf("By using the ACME provider %s you agree to the provider terms of service (%s)", acmeProviders[config.Connection.Downstream.Tls.Acme.Provider].friendlyName, acmeProviders[config.Connection.Downstream.Tls.Acme.Provider].tosURL)
+		if len(config.Connection.Downstream.Tls.Acme.DirectoryUrl) > 0 {
+			log.Info().Msgf("By using the ACME directory %s you agree to the provider terms of service", config.Connection.Downstream.Tls.Acme.DirectoryUrl)
+		} else {
+			log.Info().Msgf("By using the ACME provider %s you agree to the provider terms of service (%s)", acmeProviders[config.Connection.Downstream.Tls.Acme.Provider].friendlyName, acmeProviders[config.Connection.Downstream.Tls.Acme.Provider].tosURL)
+		}
 
 	}
 
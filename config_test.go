@@ -198,7 +198,7 @@ func TestParseResource(t *testing.T) {
 		t.Error("resource label not parsed, cannot perform upstream mapping")
 	}
 
-	wantURL := URL{"http", "localhost", "8081"}
+	wantURL := URL{Scheme: "http", Host: "localhost", Port: "8081"}
 	gotURL := customer[0].URL
 	if wantURL != gotURL {
 		t.Errorf("resource url parsed incorrectly. want %s got %s", wantURL, gotURL)
@@ -979,6 +979,251 @@ func TestValidateAcmeProviderFailsWithMissingPort80(t *testing.T) {
 	t.Error("no config panic for Acme provider without port 80 specified. should have panicked")
 }
 
+func dns01AcmeConfigWith(domain string) *Config {
+	config := acmeConfigWith(domain)
+	config.Connection.Downstream.Http.Port = 0
+	config.Connection.Downstream.Tls.Acme.Challenge = "DNS-01"
+	config.Connection.Downstream.Tls.Acme.Dns = AcmeDns{
+		Provider:   "rfc2136",
+		Nameserver: "127.0.0.1",
+	}
+	return config
+}
+
+func TestValidateAcmeDns01WildcardPasses(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("config did panic for wildcard domain with dns-01 challenge, cause: %v", r)
+		}
+	}()
+
+	config := dns01AcmeConfigWith("*.test.com").validateAcmeConfig()
+	if config.Connection.Downstream.Tls.Acme.Challenge != "dns-01" {
+		t.Errorf("ACME challenge should have been normalised to dns-01, but was %s", config.Connection.Downstream.Tls.Acme.Challenge)
+	}
+}
+
+func TestValidateAcmeDefaultsToHttp01Challenge(t *testing.T) {
+	config := acmeConfigWith("test.com").validateAcmeConfig()
+	if config.Connection.Downstream.Tls.Acme.Challenge != "http-01" {
+		t.Errorf("ACME challenge should default to http-01, but was %s", config.Connection.Downstream.Tls.Acme.Challenge)
+	}
+}
+
+func TestValidateAcmeDns01InnerWildcardFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for wildcard not in leftmost label")
+		}
+	}()
+
+	dns01AcmeConfigWith("sub.*.test.com").validateAcmeConfig()
+}
+
+func TestValidateAcmeInvalidChallengeFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for unsupported ACME challenge")
+		}
+	}()
+
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.Challenge = "smoke-signal-01"
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeDns01MissingNameserverFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for rfc2136 provider without nameserver")
+		}
+	}()
+
+	config := dns01AcmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.Dns.Nameserver = ""
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeDns01UnknownProviderFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for unknown ACME DNS provider")
+		}
+	}()
+
+	config := dns01AcmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.Dns.Provider = "carrierpigeon"
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeDirectoryUrlPasses(t *testing.T) {
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.Provider = ""
+	config.Connection.Downstream.Tls.Acme.DirectoryUrl = "https://acme.zerossl.com/v2/DV90"
+	config = config.validateAcmeConfig()
+
+	if config.Connection.Downstream.Tls.Acme.Provider != "acme.zerossl.com" {
+		t.Errorf("ACME provider should default to directory host, but was %s", config.Connection.Downstream.Tls.Acme.Provider)
+	}
+}
+
+func TestValidateAcmeDirectoryUrlWithCustomProviderNamePasses(t *testing.T) {
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.Provider = "pebble"
+	config.Connection.Downstream.Tls.Acme.DirectoryUrl = "https://localhost:14000/dir"
+	config = config.validateAcmeConfig()
+
+	if config.Connection.Downstream.Tls.Acme.Provider != "pebble" {
+		t.Errorf("ACME provider name should have been kept, but was %s", config.Connection.Downstream.Tls.Acme.Provider)
+	}
+}
+
+func TestValidateAcmeDirectoryUrlNotHttpsFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for ACME directory URL without https")
+		}
+	}()
+
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.DirectoryUrl = "http://localhost:14000/dir"
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeEabPasses(t *testing.T) {
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.EabKeyId = "kid-1"
+	config.Connection.Downstream.Tls.Acme.EabHmacKey = "dGhpcyBpcyBhIHNlY3JldCBobWFjIGtleQ"
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeEabKeyIdWithoutHmacFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for ACME eab key id without hmac key")
+		}
+	}()
+
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.EabKeyId = "kid-1"
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeEabHmacNotBase64Fails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for ACME eab hmac key not base64url")
+		}
+	}()
+
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Acme.EabKeyId = "kid-1"
+	config.Connection.Downstream.Tls.Acme.EabHmacKey = "not base64!"
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeTlsAlpn01WithoutPort80Passes(t *testing.T) {
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Http.Port = 0
+	config.Connection.Downstream.Tls.Port = 443
+	config.Connection.Downstream.Tls.Acme.Challenge = "tls-alpn-01"
+	config.validateAcmeConfig()
+}
+
+func TestValidateAcmeTlsAlpn01WithoutPort443Fails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for ACME tls-alpn-01 challenge without TLS port 443")
+		}
+	}()
+
+	config := acmeConfigWith("test.com")
+	config.Connection.Downstream.Tls.Port = 8443
+	config.Connection.Downstream.Tls.Acme.Challenge = "tls-alpn-01"
+	config.validateAcmeConfig()
+}
+
+func TestValidateSessionTicketsDefaults(t *testing.T) {
+	config := (&Config{}).validateSessionTickets()
+	if config.Connection.Downstream.Tls.SessionTickets.RotationHours != 24 ||
+		config.Connection.Downstream.Tls.SessionTickets.PreviousKeys != 2 {
+		t.Errorf("session ticket defaults not applied, was %v", config.Connection.Downstream.Tls.SessionTickets)
+	}
+}
+
+func TestValidateSessionTicketsDisabledWithSecretFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for disabled session tickets with secret")
+		}
+	}()
+
+	config := &Config{}
+	config.Connection.Downstream.Tls.SessionTickets = SessionTickets{Disable: true, SecretFile: "/tmp/secret"}
+	config.validateSessionTickets()
+}
+
+func TestValidateSessionTicketsMissingSecretFileFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for missing session ticket secret file")
+		}
+	}()
+
+	config := &Config{}
+	config.Connection.Downstream.Tls.SessionTickets = SessionTickets{SecretFile: "/not/there/secret"}
+	config.validateSessionTickets()
+}
+
+func TestValidateCompressionNormalises(t *testing.T) {
+	config := &Config{}
+	config.Connection.Downstream.Compression = Compression{
+		Encodings:    []string{"X-Gzip", "br", "x-deflate"},
+		ContentTypes: []string{" Text/* "},
+		Levels:       map[string]int{"x-gzip": 6, "br": 11},
+	}
+	config = config.validateCompression()
+
+	c := config.Connection.Downstream.Compression
+	if c.Encodings[0] != "gzip" || c.Encodings[2] != "deflate" {
+		t.Errorf("compression encodings should be canonical, got %v", c.Encodings)
+	}
+	if c.ContentTypes[0] != "text/*" {
+		t.Errorf("compression content types should be lowercase, got %v", c.ContentTypes)
+	}
+	if c.level(EncGzip) != 6 || c.level(EncBrotli) != 11 || c.level(EncZstd) != 1 {
+		t.Errorf("compression levels not applied, got %v", c.Levels)
+	}
+}
+
+func TestValidateCompressionFails(t *testing.T) {
+	tests := map[string]Compression{
+		"unknownEncoding":    {Encodings: []string{"compress"}},
+		"negativeMinBody":    {MinBodyBytes: -1},
+		"negativeTranscode":  {MaxTranscodeBytes: -1},
+		"badContentType":     {ContentTypes: []string{"json"}},
+		"badPattern":         {ContentTypes: []string{"text/["}},
+		"unknownLevel":       {Levels: map[string]int{"compress": 1}},
+		"gzipLevelTooHigh":   {Levels: map[string]int{"gzip": 10}},
+		"brotliLevelTooHigh": {Levels: map[string]int{"br": 12}},
+		"zstdLevelTooLow":    {Levels: map[string]int{"zstd": 0}},
+	}
+
+	for name, c := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("config did not panic for compression %v", c)
+				}
+			}()
+
+			config := &Config{}
+			config.Connection.Downstream.Compression = c
+			config.validateCompression()
+		})
+	}
+}
+
 // TestValidateAcmeProviderFailsWithCertSpecified
 func TestValidateAcmeProviderFailsWithCertSpecified(t *testing.T) {
 	defer func() {
@@ -1579,3 +1824,488 @@ func TestConfigValidationPanicsForBadResource(t *testing.T) {
 
 	config = config.validateRoutes()
 }
+
+func TestConfigValidationPanicsForBadDecompressRecompress(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Decompress = &Decompress{Recompress: "compress"}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationPanicsForNegativeDecompressMaxRatio(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Decompress = &Decompress{MaxRatio: -1}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationDecompressFromYml(t *testing.T) {
+	config := new(Config).parse([]byte(`
+routes:
+  - path: /upload
+    resource: about
+    decompress:
+      maxBytes: 1048576
+      maxRatio: 20
+      recompress: x-gzip
+`)).validateRoutes()
+
+	d := config.Routes[0].Decompress
+	if d == nil || d.MaxBytes != 1048576 || d.MaxRatio != 20 || d.recompress() != EncGzip {
+		t.Errorf("route decompress not parsed from yml, got %v", d)
+	}
+}
+
+func TestConfigValidationPanicsForEmptyCacheVary(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Cache = &RouteCache{Vary: []string{" "}}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationPanicsForNegativeCacheMaxBytes(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Cache.MaxBytes = -1
+
+	config = config.validateCache()
+}
+
+func TestConfigValidationCacheFromYml(t *testing.T) {
+	config := new(Config).parse([]byte(`
+cache:
+  maxBytes: 1048576
+routes:
+  - path: /get
+    resource: about
+    cache:
+      vary:
+        - x-tenant
+        - accept-language
+`)).validateRoutes().validateCache()
+
+	if config.Cache.MaxBytes != 1048576 {
+		t.Errorf("cache maxBytes not parsed from yml, got %d", config.Cache.MaxBytes)
+	}
+	c := config.Routes[0].Cache
+	if c == nil || len(c.Vary) != 2 || c.Vary[0] != "Accept-Language" || c.Vary[1] != "X-Tenant" {
+		t.Errorf("route cache vary not canonical and sorted, got %v", c)
+	}
+}
+
+func TestConfigValidationCacheMaxBytesDefault(t *testing.T) {
+	config := new(Config).validateCache()
+	if config.Cache.MaxBytes != cacheDefaultMaxBytes {
+		t.Errorf("want default cache maxBytes %d, got %d", cacheDefaultMaxBytes, config.Cache.MaxBytes)
+	}
+}
+
+func TestConfigValidationPanicsForNegativeCacheStaleIfError(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Cache = &RouteCache{StaleIfErrorSeconds: -1}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationPanicsForPurgeRouteWithoutJwt(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Resource = purge
+	config.Routes[0].Jwt = ""
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationPanicsForUndeclaredMirrorResource(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Mirror = &Mirror{Resource: "asdfadsf"}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationPanicsForMirrorPercentAbove100(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Mirror = &Mirror{Resource: config.Routes[0].Resource, Percent: 101}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationMirrorPercentDefault(t *testing.T) {
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	config.Routes[0].Mirror = &Mirror{Resource: config.Routes[0].Resource}
+	config = config.validateRoutes()
+
+	for _, r := range config.Routes {
+		if r.Mirror != nil && r.Mirror.Percent != 100 {
+			t.Errorf("want default mirror percent 100, got %v", r.Mirror.Percent)
+		}
+	}
+}
+
+func TestConfigValidationPanicsForInvalidPolicyMatch(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Policies["ab"][0].Match = []LabelMatch{{Header: "X-Canary", Cookie: "canary", Value: "blue"}}
+
+	config = config.validatePolicies()
+}
+
+func TestConfigValidationPanicsForStickyCookieWithoutPolicy(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Policy = ""
+	config.Routes[0].StickyCookie = "j8a-label"
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationPolicyMatchFromYml(t *testing.T) {
+	config := new(Config).parse([]byte(`
+policies:
+  canary:
+    - label: green
+      weight: 1
+    - label: blue
+      weight: 0
+      match:
+        - header: X-Canary
+          value: blue
+        - claim: groups
+          value: qa
+`)).validatePolicies()
+
+	m := config.Policies["canary"][1].Match
+	if len(m) != 2 || m[0].Header != "X-Canary" || m[1].Claim != "groups" || m[1].Value != "qa" {
+		t.Errorf("policy match rules not parsed from yml, got %v", m)
+	}
+}
+
+func TestConfigValidationPanicsForInvalidBalance(t *testing.T) {
+	tests := map[string]Balance{
+		"hash":             {Hash: "random"},
+		"headerWithoutKey": {Hash: balanceHeader},
+		"jwtSubWithoutJwt": {Hash: balanceJwtSub},
+		"negativeSeconds":  {Hash: balanceIP, UnhealthySeconds: -1},
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("config should have panicked")
+				}
+			}()
+
+			config := new(Config).readYmlFile("./j8acfg.yml")
+			//thou shall not pass!
+			config.Routes[0].Jwt = ""
+			config.Routes[0].Balance = &b
+
+			config = config.validateRoutes()
+		})
+	}
+}
+
+func TestConfigValidationDefaultsBalanceUnhealthySeconds(t *testing.T) {
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	b := &Balance{Hash: balanceCookie, Key: "sid", AffinityCookie: "j8a-affinity"}
+	config.Routes[0].Balance = b
+	config = config.validateRoutes()
+
+	if got := b.UnhealthySeconds; got != balanceDefaultUnhealthySeconds {
+		t.Errorf("want default unhealthySeconds %d, got %d", balanceDefaultUnhealthySeconds, got)
+	}
+}
+
+func TestConfigValidationPanicsForInvalidRetry(t *testing.T) {
+	tests := map[string]Retry{
+		"condition":       {On: []string{"reset"}},
+		"statusCode":      {StatusCodes: []int{404}},
+		"negativeBackoff": {BackoffMillis: -1},
+	}
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("config should have panicked")
+				}
+			}()
+
+			config := new(Config).readYmlFile("./j8acfg.yml")
+			//thou shall not pass!
+			config.Routes[0].Retry = &r
+
+			config = config.validateRoutes()
+		})
+	}
+}
+
+func TestConfigValidationRetryDefaultsFromYml(t *testing.T) {
+	config := new(Config).parse([]byte(`
+resources:
+  backend:
+    - url:
+        scheme: http
+        host: localhost
+        port: 8080
+routes:
+  - path: /
+    resource: backend
+    retry:
+      backoffMillis: 100
+`)).validateRoutes()
+
+	r := config.Routes[0].Retry
+	if r.MaxAttempts != retryDefaultMaxAttempts || len(r.On) != 2 || len(r.StatusCodes) != 3 || r.BackoffMillis != 100 {
+		t.Errorf("want retry defaults, got %+v", r)
+	}
+}
+
+func TestConfigValidationPanicsForInvalidHedge(t *testing.T) {
+	tests := map[string]*Hedge{
+		"noDelay":       {MaxParallel: 2},
+		"percentile100": {Percentile: 100},
+		"negativeDelay": {DelayMillis: -1},
+	}
+	for name, h := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("config should have panicked")
+				}
+			}()
+
+			config := new(Config).readYmlFile("./j8acfg.yml")
+			//thou shall not pass!
+			config.Routes[0].Hedge = h
+
+			config = config.validateRoutes()
+		})
+	}
+}
+
+func TestConfigValidationDefaultsHedgeMaxParallel(t *testing.T) {
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	h := &Hedge{Percentile: 95}
+	config.Routes[0].Hedge = h
+	config = config.validateRoutes()
+
+	if h.MaxParallel != hedgeDefaultMaxParallel {
+		t.Errorf("want default maxParallel %d, got %d", hedgeDefaultMaxParallel, h.MaxParallel)
+	}
+}
+
+func TestConfigValidationPanicsForNegativeRouteTimeouts(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Timeouts = &RouteTimeouts{UpstreamReadMillis: -1}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigValidationCanonicalisesRouteMethods(t *testing.T) {
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	config.Routes[0].Methods = []string{"get", " Post "}
+	m := config.Routes[0].Methods
+	config = config.validateRoutes()
+
+	if m[0] != "GET" || m[1] != "POST" {
+		t.Errorf("want methods upper case, got %v", m)
+	}
+}
+
+func TestConfigValidationPanicsForIllegalRouteMethod(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Methods = []string{"FETCH"}
+
+	config = config.validateRoutes()
+}
+
+func TestConfigPanicsForIllegalRouteMatcher(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Headers = []RouteMatcher{{Name: "Accept", Regex: "(["}}
+
+	config = config.compileRouteMatchers()
+}
+
+func TestConfigCompilesRouteMatchers(t *testing.T) {
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	config.Routes[0].Headers = []RouteMatcher{{Name: "x-api-version", Regex: "^2"}}
+	config = config.compileRouteMatchers()
+
+	m := config.Routes[0].Headers[0]
+	if m.Name != "X-Api-Version" || m.CompiledRegex == nil {
+		t.Errorf("want canonical header name and compiled regex, got %+v", m)
+	}
+}
+
+func TestConfigPanicsForIllegalRouteRewrite(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Rewrite = "/v2/{unknown}"
+
+	config = config.compileRoutePaths().compileRouteTransforms()
+}
+
+func TestConfigPanicsForRouteRedirectWithResource(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Redirect = &RouteRedirect{Target: "/v2"}
+
+	config = config.compileRoutePaths().compileRouteActions().validateRoutes()
+}
+
+func TestConfigPanicsForRouteRedirectAndRespond(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	//thou shall not pass!
+	config.Routes[0].Redirect = &RouteRedirect{Target: "/v2"}
+	config.Routes[0].Respond = &RouteRespond{}
+
+	config = config.compileRoutePaths().compileRouteActions()
+}
+
+func TestConfigRouteRespondWithoutResource(t *testing.T) {
+	config := new(Config).readYmlFile("./j8acfg.yml")
+	config.Routes[0].Resource = ""
+	config.Routes[0].Respond = &RouteRespond{Body: "ok"}
+
+	config = config.compileRoutePaths().compileRouteActions().validateRoutes()
+}
+
+func TestConfigParsesFileResource(t *testing.T) {
+	dir := t.TempDir()
+	config := new(Config).parse([]byte(fmt.Sprintf("---\nresources:\n  static:\n    - url:\n        scheme: file://\n        path: %s\n      index: [index.htm]\n", dir)))
+	config = config.reformatResourceUrlSchemes().reApplyResourceURLDefaults().validateResources()
+
+	r := config.Resources["static"][0]
+	if r.URL.Scheme != fileScheme || r.URL.Path != dir || r.URL.Port != "" || r.Index[0] != "index.htm" {
+		t.Errorf("want file resource with path and index, got %+v", r)
+	}
+}
+
+func TestConfigPanicsForFileResourceWithoutDirectory(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	//thou shall not pass!
+	config := new(Config).parse([]byte("---\nresources:\n  static:\n    - url:\n        scheme: file\n        path: /does/not/exist\n"))
+	config = config.reformatResourceUrlSchemes().reApplyResourceURLDefaults().validateResources()
+}
+
+func TestConfigPanicsForResourceNamedPurge(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config should have panicked")
+		}
+	}()
+
+	//thou shall not pass!
+	config := new(Config).parse([]byte("---\nresources:\n  purge:\n    - url:\n        scheme: http\n        host: localhost\n        port: 8080\n"))
+	config = config.reformatResourceUrlSchemes().reApplyResourceURLDefaults().validateResources()
+}
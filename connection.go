@@ -24,6 +24,9 @@ type Downstream struct {
 	// MaxBodyBytes is the maximum size of the incoming HTTP request body before it is rejected
 	MaxBodyBytes int64
 
+	// Compression policy for response bodies j8a encodes downstream. Optional
+	Compression Compression
+
 	// Http block. defaults to on
 	Http Http
 
@@ -31,6 +34,31 @@ type Downstream struct {
 	Tls Tls
 }
 
+// Compression controls which responses j8a compresses and how. Already encoded upstream responses are passed through.
+type Compression struct {
+	// Encodings j8a produces in order of server preference, used for Accept-Encoding elements with equal q-value.
+	// One or more of gzip | br | zstd | deflate. Defaults to gzip, br, zstd, deflate
+	Encodings []string
+
+	// MinBodyBytes is the smallest response body that is compressed, smaller bodies are sent identity. Defaults to 0
+	MinBodyBytes int
+
+	// ContentTypes that are compressed as media types, wildcards allowed, i.e. text/* or application/*+json.
+	// Defaults to text, json, javascript, xml and svg types. Responses without Content-Type are compressed
+	ContentTypes []string
+
+	// Levels per encoding, i.e. gzip: 6. gzip and deflate 1-9, br 0-11, zstd 1-22. Defaults to 1
+	Levels map[string]int
+
+	// Transcode upstream responses in an encoding the client doesn't accept, i.e. br to gzip or gzip to identity.
+	// Without it, those are passed through with a Vary header. Defaults to false
+	Transcode bool
+
+	// MaxTranscodeBytes is the largest decoded upstream response body j8a transcodes, larger bodies are passed
+	// through with a Vary header. Defaults to 16MiB
+	MaxTranscodeBytes int64
+}
+
 type Http struct {
 	// Serving HTTP on this port
 	Port int
@@ -49,14 +77,55 @@ type Tls struct {
 	// TLS secret key
 	Key string
 
+	// CertFile path to a PEM x509 certificate chain, i.e. managed by cert-manager or Vault agent. Watched for changes
+	CertFile string
+
+	// KeyFile path to the PEM secret key for CertFile. Watched for changes
+	KeyFile string
+
 	// Acme config for TLS. Optional, but conflicts with Cert and Key
 	Acme Acme
+
+	// DisableOcspStapling turns off fetching OCSP responses from the certificate's responder and stapling them
+	// to the TLS handshake. Defaults to false
+	DisableOcspStapling bool
+
+	// SessionTickets configures TLS session resumption. Optional
+	SessionTickets SessionTickets
+}
+
+// SessionTickets configures the keys encrypting TLS session tickets. Ticket keys are derived from a shared secret
+// so that all j8a instances with the same secret resume each other's sessions, also across restarts.
+type SessionTickets struct {
+	// Disable session tickets, clients then perform a full handshake on every connection. Defaults to false
+	Disable bool
+
+	// SecretFile containing the base64 encoded secret of at least 32 bytes
+	SecretFile string
+
+	// SecretEnv names the environment variable containing the base64 encoded secret, alternative to SecretFile
+	SecretEnv string
+
+	// RotationHours between ticket key rotations, defaults to 24
+	RotationHours int
+
+	// PreviousKeys kept after rotation to resume sessions with older tickets, defaults to 2
+	PreviousKeys int
 }
 
 type Acme struct {
-	// Acme Provider, currently supports letsencrypt
+	// Acme Provider, currently supports letsencrypt. With DirectoryUrl this is a free form name for the cache directory
 	Provider string
 
+	// DirectoryUrl of any ACME server, i.e. ZeroSSL, step-ca or Pebble. Overrides the Provider endpoint
+	DirectoryUrl string
+
+	// EabKeyId for External Account Binding, required by some ACME servers
+	EabKeyId string
+
+	// EabHmacKey for External Account Binding as base64url
+	EabHmacKey string
+
 	// Domain
 	Domains []string
 
@@ -65,6 +134,48 @@ type Acme struct {
 
 	// Number of days before certificate expiry that triggers first renewal attempt
 	GracePeriodDays int
+
+	// Challenge type, one of http-01 | dns-01 | tls-alpn-01. Defaults to http-01. dns-01 is required for wildcard domains
+	Challenge string
+
+	// Dns provider settings for the dns-01 challenge
+	Dns AcmeDns
+}
+
+// AcmeDns configures how j8a publishes TXT records for the ACME dns-01 challenge.
+type AcmeDns struct {
+	// Provider, one of rfc2136 | exec
+	Provider string
+
+	// Nameserver receiving RFC2136 dynamic updates as host:port, port defaults to 53
+	Nameserver string
+
+	// Zone to update. Optional, looked up via SOA query against Nameserver if empty
+	Zone string
+
+	// TsigKey name for signed RFC2136 updates. Optional
+	TsigKey string
+
+	// TsigSecret as base64 for signed RFC2136 updates
+	TsigSecret string
+
+	// TsigAlgorithm for signed RFC2136 updates, defaults to hmac-sha256
+	TsigAlgorithm string
+
+	// Command is run as `command present|cleanup <fqdn> <value>` by the exec provider
+	Command string
+
+	// Resolvers used to check TXT record propagation as host:port. Optional, defaults to system resolvers
+	Resolvers []string
+
+	// TTL of the TXT record in seconds, defaults to 120
+	TTL int
+
+	// PropagationTimeoutSeconds is the maximum wait for the TXT record to become visible, defaults to 120
+	PropagationTimeoutSeconds int
+
+	// PollingIntervalSeconds between propagation checks, defaults to 2
+	PollingIntervalSeconds int
 }
 
 // Upstream connection params for remote servers that are being proxied
@@ -0,0 +1,120 @@
+package j8a
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const decompressDefaultMaxRatio = 100
+
+const contentEncoding415 = "request content encoding %s not supported, must be one of %s"
+const decompressTooLarge = "decompressed request body exceeds limit of %d bytes"
+const decompressRatioTooLarge = "decompressed request body exceeds ratio %d:1 of compressed size"
+const decompressCorrupt = "unable to decompress request body with content encoding %s"
+const dwnBodyDecompressed = "downstream request body decompressed from %s (%d/%d) compressed/decompressed bytes, forwarded as %s"
+
+// DecompressContentEncodings are the request body encodings we decode.
+var DecompressContentEncodings = AcceptEncoding{EncGzip, EncBrotli, EncZstd, EncDeflate}
+
+func (d Decompress) maxBytes(max int64) int64 {
+	if d.MaxBytes > 0 {
+		return d.MaxBytes
+	}
+	return max
+}
+
+func (d Decompress) maxRatio() int64 {
+	if d.MaxRatio > 0 {
+		return d.MaxRatio
+	}
+	return decompressDefaultMaxRatio
+}
+
+func (d Decompress) recompress() ContentEncoding {
+	if len(d.Recompress) > 0 {
+		return canonicalCompressionEncoding(d.Recompress)
+	}
+	return EncIdentity
+}
+
+// decompressReader streams body from enc so we can stop reading once we hit the limit, without inflating it all.
+func decompressReader(enc ContentEncoding, body []byte, limit int64) (io.Reader, error) {
+	switch {
+	case enc.isGzip():
+		return gzip.NewReader(bytes.NewReader(body))
+	case enc.isBrotli():
+		return brotli.NewReader(bytes.NewReader(body)), nil
+	case enc.isZstd():
+		return zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(limit+1)))
+	case enc.isDeflate():
+		return inflateReader(body), nil
+	default:
+		return nil, fmt.Errorf(decompressCorrupt, enc)
+	}
+}
+
+// decompressRequestBody decodes the downstream request body for routes with Decompress. Responds with 415, 413
+// or 400 and returns false if the body can't be proxied.
+func (proxy *Proxy) decompressRequestBody() bool {
+	d := proxy.Route.Decompress
+	enc := NewContentEncoding(proxy.Dwn.Req.Header.Get(contentEncoding))
+	if !enc.isEncoded() || len(proxy.Dwn.Body) == 0 {
+		return true
+	}
+
+	if !enc.isAtomic() || !DecompressContentEncodings.isCompatible(enc) {
+		proxy.Dwn.Resp.Writer.Header().Set(acceptEncoding, DecompressContentEncodings.Print())
+		sendStatusCodeAsJSON(proxy.respondWith(415, fmt.Sprintf(contentEncoding415, enc, DecompressContentEncodings.Print())))
+		return false
+	}
+
+	//whichever limit is lower. we read one byte more to detect exceeding it.
+	limit := d.maxBytes(proxy.maxBodyBytes())
+	msg := fmt.Sprintf(decompressTooLarge, limit)
+	if ratioLimit := int64(len(proxy.Dwn.Body)) * d.maxRatio(); ratioLimit < limit {
+		limit = ratioLimit
+		msg = fmt.Sprintf(decompressRatioTooLarge, d.maxRatio())
+	}
+
+	rd, err := decompressReader(enc, proxy.Dwn.Body, limit)
+	var dec []byte
+	if err == nil {
+		dec, err = ioutil.ReadAll(io.LimitReader(rd, limit+1))
+		if c, ok := rd.(io.Closer); ok {
+			c.Close()
+		} else if z, ok := rd.(*zstd.Decoder); ok {
+			z.Close()
+		}
+	}
+	//zstd refuses frames needing more memory than the limit upfront.
+	tooLarge := errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded)
+	if err != nil && !tooLarge {
+		sendStatusCodeAsJSON(proxy.respondWith(400, fmt.Sprintf(decompressCorrupt, enc)))
+		return false
+	}
+	if tooLarge || int64(len(dec)) > limit {
+		sendStatusCodeAsJSON(proxy.respondWith(413, msg))
+		return false
+	}
+
+	compressed := len(proxy.Dwn.Body)
+	proxy.Dwn.BodyEncoding = d.recompress()
+	proxy.Dwn.Body = *proxy.compression().encode(proxy.Dwn.BodyEncoding, dec)
+
+	infoOrTraceEv(proxy).
+		Str(path, proxy.Dwn.Path).
+		Str(method, proxy.Dwn.Method).
+		Str(XRequestID, proxy.XRequestID).
+		Int(bodyBytes, len(proxy.Dwn.Body)).
+		Int64(dwnElpsdMicros, time.Since(proxy.Dwn.startDate).Microseconds()).
+		Msgf(dwnBodyDecompressed, enc, compressed, len(dec), proxy.Dwn.BodyEncoding)
+	return true
+}
@@ -0,0 +1,141 @@
+package j8a
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func mockDecompressProxy(enc string, body []byte, d Decompress) Proxy {
+	Runner = mockRuntime()
+	Runner.Connection.Downstream.MaxBodyBytes = 65535
+
+	proxy := mockProxy(body, "0", "/path", "/path", "/path", "", "")
+	//decompression happens before the first upstream attempt
+	proxy.Up.Atmpt = nil
+	proxy.Dwn.Body = body
+	proxy.Dwn.Method = "POST"
+	proxy.Dwn.Req.Header.Set(contentEncoding, enc)
+	proxy.Route.Decompress = &d
+	return proxy
+}
+
+func TestDecompressRequestBody(t *testing.T) {
+	body := []byte(strings.Repeat(`{"key":"value"}`, 100))
+
+	tests := map[string]struct {
+		enc        string
+		compressed []byte
+		decompress Decompress
+		want       ContentEncoding
+		decode     func([]byte) *[]byte
+	}{
+		"gzip":             {"gzip", *Gzip(body), Decompress{}, EncIdentity, nil},
+		"xGzip":            {"x-gzip", *Gzip(body), Decompress{}, EncIdentity, nil},
+		"brotli":           {"br", *BrotliEncode(body), Decompress{}, EncIdentity, nil},
+		"zstd":             {"zstd", *ZstdEncode(body), Decompress{}, EncIdentity, nil},
+		"deflate":          {"deflate", *Deflate(body), Decompress{}, EncIdentity, nil},
+		"brotliToGzip":     {"br", *BrotliEncode(body), Decompress{Recompress: "gzip"}, EncGzip, Gunzip},
+		"gzipToZstd":       {"gzip", *Gzip(body), Decompress{Recompress: "zstd"}, EncZstd, ignoreDecodeErr(ZstdDecode)},
+		"atMaxBytesOfBody": {"gzip", *Gzip(body), Decompress{MaxBytes: int64(len(body))}, EncIdentity, nil},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy := mockDecompressProxy(tt.enc, tt.compressed, tt.decompress)
+			if !proxy.decompressRequestBody() {
+				t.Fatalf("request body should have been decompressed")
+			}
+			if proxy.Dwn.BodyEncoding != tt.want {
+				t.Errorf("forwarded body encoding want %v, got %v", tt.want, proxy.Dwn.BodyEncoding)
+			}
+			got := proxy.Dwn.Body
+			if tt.decode != nil {
+				got = *tt.decode(got)
+			}
+			if !bytes.Equal(body, got) {
+				t.Errorf("forwarded body should decode to original")
+			}
+		})
+	}
+}
+
+func TestDecompressRequestBodyRejects(t *testing.T) {
+	body := []byte(strings.Repeat(`{"key":"value"}`, 1000))
+
+	tests := map[string]struct {
+		enc        string
+		compressed []byte
+		decompress Decompress
+		wantCode   int
+	}{
+		"compressUnsupported": {"compress", body, Decompress{}, 415},
+		"customUnsupported":   {"gzip, br", body, Decompress{}, 415},
+		"corruptGzip":         {"gzip", []byte("notgzip"), Decompress{}, 400},
+		"maxBytes":            {"gzip", *Gzip(body), Decompress{MaxBytes: int64(len(body) - 1)}, 413},
+		"maxRatio":            {"gzip", *Gzip(body), Decompress{MaxRatio: 2}, 413},
+		"zstdMaxBytes":        {"zstd", *ZstdEncode(body), Decompress{MaxBytes: 1024}, 413},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy := mockDecompressProxy(tt.enc, tt.compressed, tt.decompress)
+			if proxy.decompressRequestBody() {
+				t.Fatalf("request body should have been rejected")
+			}
+			if proxy.Dwn.Resp.StatusCode != tt.wantCode {
+				t.Errorf("status code want %d, got %d", tt.wantCode, proxy.Dwn.Resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestDecompressRequestBodyDefaultRatioGuardsZipBomb(t *testing.T) {
+	bomb := *Gzip(make([]byte, 10*1024*1024))
+	proxy := mockDecompressProxy("gzip", bomb, Decompress{MaxBytes: 100 * 1024 * 1024})
+	if proxy.decompressRequestBody() {
+		t.Fatalf("zip bomb should have been rejected, ratio %d:1", (10*1024*1024)/len(bomb))
+	}
+	if proxy.Dwn.Resp.StatusCode != 413 {
+		t.Errorf("zip bomb should be rejected with 413, got %d", proxy.Dwn.Resp.StatusCode)
+	}
+}
+
+func TestDecompressRequestBody415SendsAcceptEncoding(t *testing.T) {
+	proxy := mockDecompressProxy("compress", []byte("compressed"), Decompress{})
+	proxy.decompressRequestBody()
+	if got := proxy.Dwn.Resp.Writer.Header().Get(acceptEncoding); got != "gzip, br, zstd, deflate" {
+		t.Errorf("415 should send Accept-Encoding with supported encodings, got %v", got)
+	}
+}
+
+func TestDecompressRequestBodyIdentityUnchanged(t *testing.T) {
+	body := []byte(`{"key":"value"}`)
+	proxy := mockDecompressProxy("", body, Decompress{})
+	if !proxy.decompressRequestBody() || !bytes.Equal(body, proxy.Dwn.Body) || len(proxy.Dwn.BodyEncoding) > 0 {
+		t.Errorf("identity request body should be proxied unchanged")
+	}
+}
+
+func TestScaffoldUpstreamRequestDecompressedBodyHeaders(t *testing.T) {
+	body := []byte(strings.Repeat(`{"key":"value"}`, 100))
+
+	proxy := mockDecompressProxy("br", *BrotliEncode(body), Decompress{})
+	proxy.decompressRequestBody()
+	proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+	upReq := scaffoldUpstreamRequest(&proxy)
+	if got := upReq.Header.Get(contentEncoding); len(got) > 0 {
+		t.Errorf("decompressed body should be sent upstream without Content-Encoding, got %v", got)
+	}
+	if upReq.ContentLength != int64(len(body)) {
+		t.Errorf("upstream Content-Length want %d, got %d", len(body), upReq.ContentLength)
+	}
+
+	proxy = mockDecompressProxy("br", *BrotliEncode(body), Decompress{Recompress: "gzip"})
+	proxy.decompressRequestBody()
+	proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+	upReq = scaffoldUpstreamRequest(&proxy)
+	if got := upReq.Header.Get(contentEncoding); got != "gzip" {
+		t.Errorf("recompressed body should be sent upstream with Content-Encoding gzip, got %v", got)
+	}
+}
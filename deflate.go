@@ -0,0 +1,56 @@
+package j8a
+
+import (
+	"bytes"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zlib"
+	"io"
+	"io/ioutil"
+)
+
+const deflateLevel int = 1
+
+var deflatePools = newLevelPools(flate.BestSpeed, flate.BestCompression, func(level int) interface{} {
+	var buf bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&buf, level)
+	return w
+})
+
+// Deflate encodes a []byte to a zlib stream, the format of Content-Encoding deflate (RFC 9110 8.4.1.2).
+func Deflate(input []byte) *[]byte {
+	return DeflateLevel(input, deflateLevel)
+}
+
+// DeflateLevel encodes a []byte to a zlib stream with compression level 1-9
+func DeflateLevel(input []byte, level int) *[]byte {
+	deflatePool := deflatePools.get(level)
+	wrt, _ := deflatePool.Get().(*zlib.Writer)
+	buf := &bytes.Buffer{}
+	wrt.Reset(buf)
+
+	_, _ = wrt.Write(input)
+	_ = wrt.Close()
+	defer deflatePool.Put(wrt)
+
+	enc := buf.Bytes()
+	return &enc
+}
+
+// Inflate decodes a []byte from a zlib stream, or raw deflate as sent by some servers for Content-Encoding deflate.
+func Inflate(input []byte) (*[]byte, error) {
+	rd := inflateReader(input)
+	dec, err := ioutil.ReadAll(rd)
+	_ = rd.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &dec, nil
+}
+
+// inflateReader reads a zlib stream, falling back to raw deflate if body has no zlib header.
+func inflateReader(body []byte) io.ReadCloser {
+	if rd, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+		return rd
+	}
+	return flate.NewReader(bytes.NewReader(body))
+}
@@ -0,0 +1,85 @@
+package j8a
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zlib"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// ignoreDecodeErr adapts decoders returning errors to tables of decoders like Gunzip, tests compare the result.
+func ignoreDecodeErr(decode func([]byte) (*[]byte, error)) func([]byte) *[]byte {
+	return func(b []byte) *[]byte {
+		dec, err := decode(b)
+		if err != nil {
+			return &[]byte{}
+		}
+		return dec
+	}
+}
+
+func TestDeflateEncoder(t *testing.T) {
+	json := []byte("{\"routes\": [{\n\t\t\t\"path\": \"/about\",\n\t\t\t\"resource\": \"aboutj8a\"\n\t\t},\n\t\t{\n\t\t\t\"path\": \"/customer\",\n\t\t\t\"resource\": \"customer\",\n\t\t\t\"policy\": \"ab\"\n\t\t}\n\t]}")
+	df := *Deflate(json)
+
+	if len(df) >= len(json) {
+		t.Errorf("deflate compression not working, encoded size %d not smaller than data size %d", len(df), len(json))
+	}
+
+	//must be a zlib stream, readable by any zlib reader, i.e. downstream clients.
+	rd, err := zlib.NewReader(bytes.NewBuffer(df))
+	if err != nil {
+		t.Fatalf("deflate data has no zlib header, cause %v", err)
+	}
+	dec, _ := ioutil.ReadAll(rd)
+	if c := bytes.Compare(json, dec); c != 0 {
+		t.Errorf("deflate data not readable by zlib reader")
+	}
+}
+
+func TestInflateRawDeflate(t *testing.T) {
+	json := []byte(`{"key":"value"}`)
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(json)
+	w.Close()
+
+	if dec, err := Inflate(buf.Bytes()); err != nil || !bytes.Equal(json, *dec) {
+		t.Errorf("raw deflate should inflate, got %v", err)
+	}
+}
+
+func TestInflateEmpty(t *testing.T) {
+	if dec, err := Inflate([]byte{1, 0, 0, 255, 255}); err != nil || len(*dec) != 0 {
+		t.Errorf("empty deflate block should inflate to empty []byte, but got %v", err)
+	}
+}
+
+func TestInflateCorrupt(t *testing.T) {
+	df := *Deflate([]byte(`{"key":"value"}`))
+	if _, err := Inflate(df[:len(df)-6]); err == nil {
+		t.Errorf("truncated deflate data should return error")
+	}
+}
+
+func TestDeflateThenInflatePoolIntegrity(t *testing.T) {
+	var wg sync.WaitGroup
+
+	for i := 0; i <= 10000; i++ {
+		json := []byte(fmt.Sprintf(`{ "key":"value %v" }`, rand.Float64()*float64(i)))
+		wg.Add(1)
+
+		go func() {
+			if dec, err := Inflate(*Deflate(json)); err != nil || bytes.Compare(json, *dec) != 0 {
+				t.Error("inflated data is not equal to original")
+			}
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,232 @@
+package j8a
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const fileScheme = "file"
+const defaultIndexFile = "index.html"
+const fileServed = "file served from resource"
+const filePath = "filePath"
+const fileNotFound = "file not found"
+const fileForbidden = "file forbidden"
+const sniffLen = 512
+const wellKnown = ".well-known"
+
+// fileSidecars are precompressed copies of a file, i.e. app.js.br next to app.js, in order of preference.
+var fileSidecars = AcceptEncoding{EncBrotli, EncGzip}
+var fileSidecarExt = map[ContentEncoding]string{EncBrotli: ".br", EncGzip: ".gz"}
+
+// validFileRoot checks the directory of a file resource.
+func (r ResourceMapping) validFileRoot() error {
+	if len(r.URL.Host) > 0 || len(r.URL.Port) > 0 {
+		return errors.New("file url can't have host or port")
+	}
+	if !filepath.IsAbs(r.URL.Path) {
+		return fmt.Errorf("file url needs an absolute path, was: %v", r.URL.Path)
+	}
+	if fi, err := os.Stat(r.URL.Path); err != nil || !fi.IsDir() {
+		return fmt.Errorf("file url path needs to be a directory, was: %v", r.URL.Path)
+	}
+	return nil
+}
+
+func (r ResourceMapping) indexFiles() []string {
+	if len(r.Index) == 0 {
+		return []string{defaultIndexFile}
+	}
+	return r.Index
+}
+
+// fileName maps the upstream request URI to a file below the resource directory. Hidden files are never served,
+// except for the well-known URIs of RFC 8615 at the top of the directory, i.e. /.well-known/security.txt
+func (r ResourceMapping) fileName(uri string) (string, bool) {
+	p, _, _ := strings.Cut(uri, Q)
+	p, err := url.PathUnescape(p)
+	if err != nil {
+		return emptyString, false
+	}
+	//cleaning the rooted path drops .. segments above the resource directory
+	p = filepath.Clean(filepath.FromSlash(slashS + p))
+	for i, s := range strings.Split(p, string(filepath.Separator)) {
+		if s == ".." || strings.HasPrefix(s, dot) && !(i == 1 && s == wellKnown) {
+			return emptyString, false
+		}
+	}
+	return filepath.Join(r.URL.Path, p), true
+}
+
+// hasFileResource is true for routes that serve files instead of proxying upstream.
+func (route Route) hasFileResource() bool {
+	r := Runner.Resources[route.Resource]
+	return len(r) > 0 && r[0].URL.Scheme == fileScheme
+}
+
+// fileResponseWriter records the response http.ServeContent sends, so it can be logged.
+type fileResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	written    int64
+}
+
+func (w *fileResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *fileResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// serveFile serves the file for the request from the route's file resource. Conditional and range requests are
+// handled by http.ServeContent.
+func (proxy *Proxy) serveFile() {
+	if proxy.Dwn.Method != "GET" && proxy.Dwn.Method != "HEAD" {
+		proxy.Dwn.Resp.Writer.Header().Set(allow, "GET, HEAD")
+		sendStatusCodeAsJSON(proxy.respondWith(405, fmt.Sprintf(httpMethodNotAllowed, proxy.Dwn.Method)))
+		return
+	}
+
+	r := Runner.Resources[proxy.Route.Resource][0]
+	name, ok := r.fileName(proxy.upstreamRequestURI())
+	if !ok {
+		sendStatusCodeAsJSON(proxy.respondWith(404, fileNotFound))
+		return
+	}
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		if !strings.HasSuffix(proxy.Dwn.Path, slashS) {
+			proxy.redirectToDirectory()
+			return
+		}
+		name, fi, err = r.indexFile(name)
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		if os.IsPermission(err) {
+			sendStatusCodeAsJSON(proxy.respondWith(403, fileForbidden))
+		} else {
+			sendStatusCodeAsJSON(proxy.respondWith(404, fileNotFound))
+		}
+		return
+	}
+
+	ct, err := fileContentType(name)
+	if err != nil {
+		sendStatusCodeAsJSON(proxy.respondWith(403, fileForbidden))
+		return
+	}
+	enc, served, sfi := proxy.negotiateFileSidecar(name, fi)
+	f, err := os.Open(served)
+	if err != nil {
+		sendStatusCodeAsJSON(proxy.respondWith(403, fileForbidden))
+		return
+	}
+	defer f.Close()
+
+	proxy.writeStandardResponseHeaders()
+	header := proxy.Dwn.Resp.Writer.Header()
+	header.Set(contentType, ct)
+	header.Set(etagS, fileETag(sfi, enc))
+	if enc != EncIdentity {
+		header.Set(contentEncoding, enc.print())
+	}
+
+	w := &fileResponseWriter{ResponseWriter: proxy.Dwn.Resp.Writer}
+	http.ServeContent(w, proxy.Dwn.Req, name, sfi.ModTime(), f)
+
+	proxy.Dwn.Resp.StatusCode = w.statusCode
+	proxy.Dwn.Resp.ContentLength = w.written
+	proxy.Dwn.Resp.ContentEncoding = enc
+
+	infoOrTraceEv(proxy).
+		Str(filePath, served).
+		Str(XRequestID, proxy.XRequestID).
+		Msg(fileServed)
+	logHandledDownstreamRoundtrip(proxy)
+}
+
+// indexFile finds the first index file in dir.
+func (r ResourceMapping) indexFile(dir string) (string, os.FileInfo, error) {
+	err := os.ErrNotExist
+	for _, index := range r.indexFiles() {
+		name := filepath.Join(dir, index)
+		var fi os.FileInfo
+		if fi, err = os.Stat(name); err == nil && fi.Mode().IsRegular() {
+			return name, fi, nil
+		}
+	}
+	return emptyString, nil, err
+}
+
+// redirectToDirectory redirects directory requests without trailing slash, so relative links in index files resolve.
+// The redirect is relative to the last segment of the cleaned path, so it can't leave the host.
+func (proxy *Proxy) redirectToDirectory() {
+	p := filepath.ToSlash(filepath.Clean(filepath.FromSlash(slashS + proxy.Dwn.Path)))
+	loc := dot + slashS
+	if base := p[strings.LastIndex(p, slashS)+1:]; len(base) > 0 {
+		loc += base + slashS
+	}
+	if _, q, ok := strings.Cut(proxy.Dwn.URI, Q); ok {
+		loc += Q + q
+	}
+	proxy.Dwn.Resp.Writer.Header().Set(location, loc)
+	sendStatusCodeAsJSON(proxy.respondWith(http.StatusMovedPermanently, emptyString))
+}
+
+// negotiateFileSidecar picks a precompressed sidecar of name acceptable downstream. It returns identity and name
+// itself if there isn't one.
+func (proxy *Proxy) negotiateFileSidecar(name string, fi os.FileInfo) (ContentEncoding, string, os.FileInfo) {
+	var available AcceptEncoding
+	sfis := make(map[ContentEncoding]os.FileInfo)
+	for _, enc := range fileSidecars {
+		if sfi, err := os.Stat(name + fileSidecarExt[enc]); err == nil && sfi.Mode().IsRegular() {
+			available = append(available, enc)
+			sfis[enc] = sfi
+		}
+	}
+	if len(available) == 0 {
+		return EncIdentity, name, fi
+	}
+
+	proxy.Dwn.Resp.Writer.Header().Add(varyS, AcceptEncodingS)
+	if enc := proxy.Dwn.AcceptEncoding.negotiate(available); len(enc) > 0 {
+		return enc, name + fileSidecarExt[enc], sfis[enc]
+	}
+	return EncIdentity, name, fi
+}
+
+// fileContentType by extension, else sniffed from the uncompressed file.
+func fileContentType(name string) (string, error) {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); len(ct) > 0 {
+		return ct, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return emptyString, err
+	}
+	defer f.Close()
+	b := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, b)
+	return http.DetectContentType(b[:n]), nil
+}
+
+// fileETag changes with the file's modification time, size and encoding.
+func fileETag(fi os.FileInfo, enc ContentEncoding) string {
+	if enc == EncIdentity {
+		return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return fmt.Sprintf(`"%x-%x-%s"`, fi.ModTime().UnixNano(), fi.Size(), enc)
+}
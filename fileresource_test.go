@@ -0,0 +1,255 @@
+package j8a
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mockFileResource serves route / from a directory with an index file, a script with precompressed sidecars, a file
+// without extension, a hidden file and a subdirectory.
+func mockFileResource(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":     "<html>index</html>",
+		"app.js":         "console.log('app')",
+		"app.js.br":      "brotli",
+		"app.js.gz":      "gzip",
+		"data":           "plain text without extension",
+		".secret":        "secret",
+		"sub/index.html": "<html>sub</html>",
+		"sub/home.htm":   "<html>home</html>",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	Runner = mockRuntime()
+	Runner.Resources["static"] = []ResourceMapping{{Name: "static", URL: URL{Scheme: fileScheme, Path: dir}}}
+	Runner.Routes[0].Resource = "static"
+	return dir
+}
+
+func fileClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{DisableCompression: true},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func TestFileResourceFileName(t *testing.T) {
+	r := ResourceMapping{URL: URL{Scheme: fileScheme, Path: "/var/www"}}
+	tests := map[string]struct {
+		uri  string
+		want string
+		ok   bool
+	}{
+		"file":      {"/app.js?v=1", "/var/www/app.js", true},
+		"escaped":   {"/my%20file.txt", "/var/www/my file.txt", true},
+		"traversal": {"/../../etc/passwd", "/var/www/etc/passwd", true},
+		"escapedUp": {"/%2e%2e/etc/passwd", "/var/www/etc/passwd", true},
+		"hidden":    {"/.git/config", "", false},
+		"hiddenSub": {"/assets/.env", "", false},
+		"wellKnown": {"/.well-known/security.txt", "/var/www/.well-known/security.txt", true},
+		"nestedWK":  {"/assets/.well-known/x", "", false},
+		"badEscape": {"/%zz", "", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := r.fileName(tt.uri)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("want %s %v, got %s %v", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestFileResourceWithProxyHandler(t *testing.T) {
+	mockFileResource(t)
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	tests := map[string]struct {
+		method   string
+		path     string
+		ae       string
+		status   int
+		body     string
+		ct       string
+		encoding string
+	}{
+		"index":         {"GET", "/", "", 200, "<html>index</html>", "text/html; charset=utf-8", ""},
+		"identity":      {"GET", "/app.js", "", 200, "console.log('app')", "text/javascript; charset=utf-8", ""},
+		"brotli":        {"GET", "/app.js", "gzip, br", 200, "brotli", "text/javascript; charset=utf-8", "br"},
+		"gzip":          {"GET", "/app.js", "gzip", 200, "gzip", "text/javascript; charset=utf-8", "gzip"},
+		"gzipPreferred": {"GET", "/app.js", "br;q=0.5, gzip", 200, "gzip", "text/javascript; charset=utf-8", "gzip"},
+		"sniffed":       {"GET", "/data", "", 200, "plain text without extension", "text/plain; charset=utf-8", ""},
+		"head":          {"HEAD", "/app.js", "", 200, "", "text/javascript; charset=utf-8", ""},
+		"hidden":        {"GET", "/.secret", "", 404, "", "", ""},
+		"missing":       {"GET", "/missing.js", "", 404, "", "", ""},
+		"directory":     {"GET", "/sub", "", 301, "", "", ""},
+		"subIndex":      {"GET", "/sub/", "", 200, "<html>sub</html>", "text/html; charset=utf-8", ""},
+		"post":          {"POST", "/app.js", "", 405, "", "", ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+			if len(tt.ae) > 0 {
+				req.Header.Set(AcceptEncodingS, tt.ae)
+			}
+			resp, err := fileClient().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("want status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.status != 200 {
+				return
+			}
+			if string(body) != tt.body {
+				t.Errorf("want body %s, got %s", tt.body, body)
+			}
+			if got := resp.Header.Get(contentType); got != tt.ct {
+				t.Errorf("want content type %s, got %s", tt.ct, got)
+			}
+			if got := resp.Header.Get(contentEncoding); got != tt.encoding {
+				t.Errorf("want content encoding %s, got %s", tt.encoding, got)
+			}
+		})
+	}
+}
+
+func TestFileResourceRedirectsDirectoryWithSlash(t *testing.T) {
+	mockFileResource(t)
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := fileClient().Get(server.URL + "/sub?a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get(location); got != "./sub/?a=b" {
+		t.Errorf("want redirect to directory with slash, got %s", got)
+	}
+}
+
+func TestFileResourceRedirectsDirectoryOnHost(t *testing.T) {
+	mockFileResource(t)
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := fileClient().Get(server.URL + "//evil.com/..//sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get(location); got != "./sub/" {
+		t.Errorf("want redirect relative to cleaned path, got %s", got)
+	}
+}
+
+func TestFileResourceIndexFiles(t *testing.T) {
+	mockFileResource(t)
+	Runner.Resources["static"][0].Index = []string{"home.htm", "index.html"}
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := fileClient().Get(server.URL + "/sub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "<html>home</html>" {
+		t.Errorf("want first index file, got %s", body)
+	}
+	resp, _ = fileClient().Get(server.URL + "/")
+	if resp.StatusCode != 200 {
+		t.Errorf("want next index file if first is missing, got %d", resp.StatusCode)
+	}
+}
+
+func TestFileResourceConditionalAndRangeRequests(t *testing.T) {
+	mockFileResource(t)
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := fileClient().Get(server.URL + "/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := resp.Header.Get(etagS)
+	lastModified := resp.Header.Get(lastModifiedS)
+	if len(etag) == 0 || len(lastModified) == 0 || !strings.Contains(resp.Header.Get(varyS), AcceptEncodingS) {
+		t.Fatalf("want etag, last modified and vary, got %v", resp.Header)
+	}
+
+	tests := map[string]struct {
+		header string
+		value  string
+		status int
+		body   string
+	}{
+		"ifNoneMatch":     {ifNoneMatchS, etag, 304, ""},
+		"ifModifiedSince": {ifModifiedSinceS, lastModified, 304, ""},
+		"staleEtag":       {ifNoneMatchS, `"stale"`, 200, "console.log('app')"},
+		"range":           {"Range", "bytes=0-6", 206, "console"},
+		"badRange":        {"Range", "bytes=100-200", 416, ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", server.URL+"/app.js", nil)
+			req.Header.Set(tt.header, tt.value)
+			resp, err := fileClient().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Errorf("want status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if len(tt.body) > 0 && string(body) != tt.body {
+				t.Errorf("want body %s, got %s", tt.body, body)
+			}
+		})
+	}
+
+	//sidecars have their own etag
+	req, _ := http.NewRequest("GET", server.URL+"/app.js", nil)
+	req.Header.Set(AcceptEncodingS, "br")
+	req.Header.Set(ifNoneMatchS, etag)
+	resp, _ = fileClient().Do(req)
+	if resp.StatusCode != 200 || resp.Header.Get(etagS) == etag {
+		t.Errorf("want brotli sidecar with different etag, got %d %s", resp.StatusCode, resp.Header.Get(etagS))
+	}
+}
+
+func TestValidFileRoot(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, []byte("x"), 0644)
+
+	tests := map[string]struct {
+		url  URL
+		want bool
+	}{
+		"dir":      {URL{Scheme: fileScheme, Path: dir}, true},
+		"relative": {URL{Scheme: fileScheme, Path: "static"}, false},
+		"file":     {URL{Scheme: fileScheme, Path: file}, false},
+		"missing":  {URL{Scheme: fileScheme, Path: filepath.Join(dir, "missing")}, false},
+		"host":     {URL{Scheme: fileScheme, Host: "localhost", Path: dir}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := (ResourceMapping{URL: tt.url}).validFileRoot(); (err == nil) != tt.want {
+				t.Errorf("want valid %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
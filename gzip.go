@@ -12,13 +12,11 @@ const gzipLevel int = 1
 var gzipMagicBytes = []byte{0x1f, 0x8b}
 var gzipSmall = []byte{31, 139, 8, 0, 0, 0, 0, 0, 0, 255, 170, 174, 5, 4, 0, 0, 255, 255, 67, 191, 166, 163, 2, 0, 0, 0}
 
-var zipPool = sync.Pool{
-	New: func() interface{} {
-		var buf bytes.Buffer
-		w, _ := gzip.NewWriterLevel(&buf, gzipLevel)
-		return w
-	},
-}
+var zipPools = newLevelPools(gzip.BestSpeed, gzip.BestCompression, func(level int) interface{} {
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, level)
+	return w
+})
 
 var unzipPool = sync.Pool{
 	New: func() interface{} {
@@ -30,6 +28,12 @@ var unzipPool = sync.Pool{
 
 // Gzip a []byte
 func Gzip(input []byte) *[]byte {
+	return GzipLevel(input, gzipLevel)
+}
+
+// GzipLevel gzips a []byte with compression level 1-9
+func GzipLevel(input []byte, level int) *[]byte {
+	zipPool := zipPools.get(level)
 	wrt, _ := zipPool.Get().(*gzip.Writer)
 	buf := &bytes.Buffer{}
 	wrt.Reset(buf)
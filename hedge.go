@@ -0,0 +1,223 @@
+package j8a
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const hedgeDefaultMaxParallel = 2
+const hedgeLatencySamples = 256
+const hedgeMinLatencySamples = 20
+
+const upstreamAttemptHedged = "upstream attempt hedged after %v"
+const upstreamHedgeWon = "upstream hedged attempt won, cancelling others"
+
+// Hedge sends another upstream attempt to an alternate resource member if the previous one hasn't returned headers
+// within a delay. The first response other than 5xx wins, the others are cancelled. Hedged attempts don't count
+// towards retry attempts.
+type Hedge struct {
+	// DelayMillis before each hedged attempt. If Percentile is set, this is used until enough latencies are known.
+	DelayMillis int
+
+	// Percentile of recent upstream header latencies for the route used as delay, i.e. 95
+	Percentile float64
+
+	// MaxParallel is the maximum number of parallel upstream attempts including the first one, defaults to 2
+	MaxParallel int
+
+	latencies latencyWindow
+}
+
+// latencyWindow keeps the most recent upstream header latencies of a route.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < hedgeLatencySamples {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % hedgeLatencySamples
+}
+
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	s := append([]time.Duration{}, w.samples...)
+	w.mu.Unlock()
+	if len(s) < hedgeMinLatencySamples {
+		return 0, false
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	i := int(p/100*float64(len(s))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s) {
+		i = len(s) - 1
+	}
+	return s[i], true
+}
+
+// delay before the next hedged attempt, false if the route doesn't hedge yet.
+func (h *Hedge) delay() (time.Duration, bool) {
+	if h.Percentile > 0 {
+		if d, ok := h.latencies.percentile(h.Percentile); ok {
+			return d, true
+		}
+	}
+	return time.Duration(h.DelayMillis) * time.Millisecond, h.DelayMillis > 0
+}
+
+func (proxy *Proxy) isHedgeable() bool {
+	return proxy.Route != nil &&
+		proxy.Route.Hedge != nil &&
+		(proxy.Dwn.Method == "GET" || proxy.Dwn.Method == "HEAD") &&
+		!proxy.isWebsocketUpgrade()
+}
+
+type hedgeResult struct {
+	index   int
+	resp    *http.Response
+	err     error
+	latency time.Duration
+}
+
+// won is true for a response that ends the race. Upstream 5xx wait for the other attempts.
+func (r hedgeResult) won() bool {
+	return r.err == nil && r.resp != nil && r.resp.StatusCode < 500
+}
+
+// performHedgedUpstreamRequest races up to MaxParallel upstream attempts for headers. When it returns, the attempt
+// that won is proxy.Up.Atmpt and the last of proxy.Up.Atmpts. If none won, it's the last 5xx response, or else the
+// attempt that failed last.
+func performHedgedUpstreamRequest(proxy *Proxy) (*http.Response, error) {
+	h := proxy.Route.Hedge
+	results := make(chan hedgeResult, h.MaxParallel)
+	start := func() {
+		index := len(proxy.Up.Atmpts) - 1
+		began := proxy.Up.Atmpt.startDate
+		req := scaffoldUpstreamRequest(proxy)
+		go func() {
+			resp, err := httpClient.Do(req)
+			results <- hedgeResult{index, resp, err, time.Since(began)}
+		}()
+	}
+
+	start()
+	pending, launched := 1, 1
+	var failed hedgeResult
+	var hedge <-chan time.Time
+	if d, ok := h.delay(); ok && h.MaxParallel > 1 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		hedge = t.C
+	}
+
+	for {
+		select {
+		case <-hedge:
+			d, _ := h.delay()
+			scaffoldUpAttemptLog(proxy).
+				Msgf(upstreamAttemptHedged, d)
+			proxy.nextAttempt()
+			proxy.Up.Hedges++
+			start()
+			pending++
+			launched++
+			hedge = nil
+			if launched < h.MaxParallel {
+				hedge = time.After(d)
+			}
+		case r := <-results:
+			pending--
+			a := &proxy.Up.Atmpts[r.index]
+			if r.resp != nil {
+				h.latencies.add(r.latency)
+			}
+			if r.won() {
+				proxy.cancelHedgedAttempts(r.index, results, pending)
+				closeHedgeResult(failed)
+				proxy.settleHedgedAttempt(r.index, r.resp)
+				scaffoldUpAttemptLog(proxy).
+					RawJSON(upResHeaders, jsonifyUpstreamHeaders(proxy)).
+					Msg(upstreamHedgeWon)
+				return r.resp, nil
+			}
+			select {
+			case <-a.Aborted:
+				a.AbortedFlag = true
+			default:
+			}
+			//an upstream 5xx is a better answer than an error if no attempt wins
+			if r.resp != nil || failed.resp == nil {
+				closeHedgeResult(failed)
+				failed = r
+			}
+			if pending == 0 {
+				proxy.settleHedgedAttempt(failed.index, failed.resp)
+				return failed.resp, failed.err
+			}
+		case <-proxy.Dwn.Timeout:
+			proxy.Dwn.TimeoutFlag = true
+			scaffoldUpAttemptLog(proxy).
+				Msg(downstreamRtFired)
+			proxy.cancelHedgedAttempts(-1, results, pending)
+			closeHedgeResult(failed)
+			return nil, nil
+		case <-proxy.Dwn.Aborted:
+			proxy.Dwn.AbortedFlag = true
+			scaffoldUpAttemptLog(proxy).
+				Msg(downstreamReqAborted)
+			proxy.cancelHedgedAttempts(-1, results, pending)
+			closeHedgeResult(failed)
+			return nil, nil
+		}
+	}
+}
+
+// cancelHedgedAttempts other than winner and closes their responses once they arrive. Responses that arrive anyway
+// are latency samples.
+func (proxy *Proxy) cancelHedgedAttempts(winner int, results chan hedgeResult, pending int) {
+	for i := range proxy.Up.Atmpts {
+		if i != winner && proxy.Up.Atmpts[i].CancelFunc != nil {
+			proxy.Up.Atmpts[i].AbortedFlag = true
+			proxy.Up.Atmpts[i].CancelFunc()
+		}
+	}
+	h := proxy.Route.Hedge
+	go func() {
+		for ; pending > 0; pending-- {
+			r := <-results
+			if r.resp != nil {
+				h.latencies.add(r.latency)
+			}
+			closeHedgeResult(r)
+		}
+	}()
+}
+
+func closeHedgeResult(r hedgeResult) {
+	if r.resp != nil && r.resp.Body != nil {
+		r.resp.Body.Close()
+	}
+}
+
+// settleHedgedAttempt moves attempt i last, where body processing and retries expect the current attempt.
+func (proxy *Proxy) settleHedgedAttempt(i int, resp *http.Response) {
+	a := proxy.Up.Atmpts
+	last := len(a) - 1
+	if i != last {
+		a[i], a[last] = a[last], a[i]
+		a[i].Count, a[last].Count = a[last].Count, a[i].Count
+	}
+	proxy.Up.Atmpt = &a[last]
+	proxy.Up.Atmpt.resp = resp
+}
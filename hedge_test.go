@@ -0,0 +1,243 @@
+package j8a
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockHedgeUpstream serves route / from two members. Member 8081 answers after slow, member 8082 right away. Both
+// answer with statusCode and fail with err if set. Requests are counted and cancellations sent to cancelled.
+func mockHedgeUpstream(slow time.Duration, statusCode int, err error) (*int32, chan string) {
+	Runner = mockRuntime()
+	Runner.Resources["default"] = []ResourceMapping{
+		{Name: "default", Labels: []string{"simple"}, URL: URL{Scheme: "http", Host: "localhost", Port: "8081"}},
+		{Name: "default", Labels: []string{"simple"}, URL: URL{Scheme: "http", Host: "localhost", Port: "8082"}},
+	}
+
+	var n int32
+	cancelled := make(chan string, 10)
+	httpClient = &MockHttp{}
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&n, 1)
+		if req.URL.Port() == "8081" {
+			select {
+			case <-time.After(slow):
+			case <-req.Context().Done():
+				cancelled <- req.URL.Port()
+				return nil, req.Context().Err()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			StatusCode: statusCode,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"port":"` + req.URL.Port() + `"}`))),
+		}, nil
+	}
+	return &n, cancelled
+}
+
+func TestLatencyWindowPercentile(t *testing.T) {
+	var w latencyWindow
+	for i := 1; i < hedgeMinLatencySamples; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := w.percentile(95); ok {
+		t.Errorf("want no percentile before %d samples", hedgeMinLatencySamples)
+	}
+
+	w = latencyWindow{}
+	for i := 1; i <= 100; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	if got, _ := w.percentile(95); got != 95*time.Millisecond {
+		t.Errorf("want p95 95ms, got %v", got)
+	}
+
+	//old samples drop out of the window
+	for i := 0; i < hedgeLatencySamples; i++ {
+		w.add(time.Second)
+	}
+	if got, _ := w.percentile(50); got != time.Second {
+		t.Errorf("want p50 1s after window moved on, got %v", got)
+	}
+}
+
+func TestHedgeDelay(t *testing.T) {
+	h := &Hedge{DelayMillis: 50, Percentile: 90}
+	if d, ok := h.delay(); !ok || d != 50*time.Millisecond {
+		t.Errorf("want delayMillis before latencies are known, got %v", d)
+	}
+	for i := 1; i <= 100; i++ {
+		h.latencies.add(time.Duration(i) * time.Millisecond)
+	}
+	if d, ok := h.delay(); !ok || d != 90*time.Millisecond {
+		t.Errorf("want p90 of latencies, got %v", d)
+	}
+	if _, ok := (&Hedge{Percentile: 90}).delay(); ok {
+		t.Errorf("want no hedging without delayMillis before latencies are known")
+	}
+}
+
+func TestIsHedgeable(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		hedge  *Hedge
+		want   bool
+	}{
+		"get":     {"GET", &Hedge{}, true},
+		"head":    {"HEAD", &Hedge{}, true},
+		"post":    {"POST", &Hedge{}, false},
+		"put":     {"PUT", &Hedge{}, false},
+		"noHedge": {"GET", nil, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			proxy := mockProxy(nil, "0", "/path", "/path", "/get", "", "")
+			proxy.Dwn.Method = tt.method
+			proxy.Route.Hedge = tt.hedge
+			if got := proxy.isHedgeable(); got != tt.want {
+				t.Errorf("want hedgeable %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHedgedAttemptWinsOverSlowMember(t *testing.T) {
+	n, cancelled := mockHedgeUpstream(5*time.Second, 200, nil)
+	Runner.Routes[0].Hedge = &Hedge{DelayMillis: 20, MaxParallel: 2}
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !bytes.Contains(body, []byte("8082")) {
+		t.Errorf("want 200 from hedged member, got %d %s", resp.StatusCode, body)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("want hedged response before slow member, took %v", time.Since(start))
+	}
+	if got := atomic.LoadInt32(n); got != 2 {
+		t.Errorf("want 2 upstream attempts, got %d", got)
+	}
+	select {
+	case p := <-cancelled:
+		if p != "8081" {
+			t.Errorf("want slow member cancelled, got %s", p)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("want losing attempt cancelled")
+	}
+}
+
+func TestHedgeNotSentForFastMember(t *testing.T) {
+	n, _ := mockHedgeUpstream(0, 200, nil)
+	Runner.Routes[0].Hedge = &Hedge{DelayMillis: 1000, MaxParallel: 3}
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("want 200, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(n); got != 1 {
+		t.Errorf("want no hedged attempt, got %d upstream attempts", got)
+	}
+}
+
+func TestHedgedAttemptsAllFail(t *testing.T) {
+	n, _ := mockHedgeUpstream(50*time.Millisecond, 0, errors.New("connection refused"))
+	Runner.Connection.Upstream.MaxAttempts = 1
+	Runner.Routes[0].Hedge = &Hedge{DelayMillis: 10, MaxParallel: 2}
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 502 {
+		t.Errorf("want 502 when all hedged attempts fail, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(n); got != 2 {
+		t.Errorf("want 2 upstream attempts, got %d", got)
+	}
+}
+
+func TestHedgedServerErrorDoesNotWin(t *testing.T) {
+	mockHedgeUpstream(0, 200, nil)
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		statusCode := 503
+		if req.URL.Port() == "8081" {
+			time.Sleep(100 * time.Millisecond)
+			statusCode = 200
+		}
+		return &http.Response{
+			StatusCode: statusCode,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"port":"` + req.URL.Port() + `"}`))),
+		}, nil
+	}
+	Runner.Routes[0].Hedge = &Hedge{DelayMillis: 10, MaxParallel: 2}
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !bytes.Contains(body, []byte("8081")) {
+		t.Errorf("want 200 from slower healthy member, got %d %s", resp.StatusCode, body)
+	}
+	if got := len(Runner.Routes[0].Hedge.latencies.samples); got != 2 {
+		t.Errorf("want latency samples of both completed attempts, got %d", got)
+	}
+}
+
+func TestHedgedAttemptsDontCountAsRetries(t *testing.T) {
+	n, _ := mockHedgeUpstream(50*time.Millisecond, 0, errors.New("connection refused"))
+	Runner.Connection.Upstream.MaxAttempts = 2
+	Runner.Routes[0].Hedge = &Hedge{DelayMillis: 10, MaxParallel: 2}
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	if _, err := http.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(n); got < 3 {
+		t.Errorf("want retry after hedged attempts failed, got %d upstream attempts", got)
+	}
+}
+
+func TestSettleHedgedAttemptMovesWinnerLast(t *testing.T) {
+	proxy := &Proxy{Up: Up{Atmpts: []Atmpt{{Count: 1, Label: "a"}, {Count: 2, Label: "b"}}, Count: 2}}
+	resp := &http.Response{StatusCode: 200}
+	proxy.settleHedgedAttempt(0, resp)
+	if proxy.Up.Atmpt != &proxy.Up.Atmpts[1] || proxy.Up.Atmpt.Label != "a" || proxy.Up.Atmpt.Count != 2 || proxy.Up.Atmpt.resp != resp {
+		t.Errorf("want winner as last attempt with count 2, got %+v", proxy.Up.Atmpt)
+	}
+	if proxy.Up.Atmpts[0].Label != "b" || proxy.Up.Atmpts[0].Count != 1 {
+		t.Errorf("want loser first with count 1, got %+v", proxy.Up.Atmpts[0])
+	}
+}
@@ -1,6 +1,7 @@
 package j8a
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/http"
@@ -23,14 +24,18 @@ func scaffoldHTTPClient(runtime *Runtime) HTTPClient {
 	socketTimeoutDuration := time.Duration(runtime.Connection.Upstream.SocketTimeoutSeconds) * time.Second
 	readTimeoutDuration := time.Duration(runtime.Connection.Upstream.ReadTimeoutSeconds) * time.Second
 	tlsInsecureSkipVerify := runtime.Connection.Upstream.TlsInsecureSkipVerify
+	keepAliveIntervalDuration := getKeepAliveIntervalDuration()
 
 	httpClient = &http.Client{
 		Transport: &http.Transport{
 			DisableCompression: true,
-			DialContext: (&net.Dialer{
-				Timeout:   socketTimeoutDuration,
-				KeepAlive: getKeepAliveIntervalDuration(),
-			}).DialContext,
+			//routes can override the socket timeout through the request context
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{
+					Timeout:   upstreamSocketTimeout(ctx, socketTimeoutDuration),
+					KeepAlive: keepAliveIntervalDuration,
+				}).DialContext(ctx, network, addr)
+			},
 			//TLS handshake timeout is the same as connection timeout
 			TLSHandshakeTimeout: tLSHandshakeTimeoutDuration,
 			TLSClientConfig: &tls.Config{
@@ -61,9 +61,9 @@ func TestBrotliEncodingOn404SendsEncodedBrotli(t *testing.T) {
 	}
 }
 
-func TestDeflateAcceptEncodingOn404Sends406(t *testing.T) {
-	DownstreamAcceptEncodingContentEncodingHTTP11("deflate", true, "406", "/", t)
-	DownstreamAcceptEncodingContentEncodingHTTP11("x-deflate", true, "406", "/", t)
+func TestDeflateAcceptEncodingOn404SendsDeflate(t *testing.T) {
+	DownstreamAcceptEncodingContentEncodingHTTP11("deflate", true, "deflate", "/", t)
+	DownstreamAcceptEncodingContentEncodingHTTP11("x-deflate", true, "deflate", "/", t)
 }
 
 func TestCompressAcceptEncodingOn404Sends406(t *testing.T) {
@@ -241,9 +241,17 @@ func TestBrotliEncodingOnProxyHandlerSendsJ8aEncodedBrotli(t *testing.T) {
 	}
 }
 
-func TestDeflateEncodingOnProxyHandlerSends406(t *testing.T) {
-	DownstreamAcceptEncodingContentEncodingHTTP11("deflate", true, "406", "/mse6/get", t)
-	DownstreamAcceptEncodingContentEncodingHTTP11("x-deflate", true, "406", "/mse6/get", t)
+func TestDeflateEncodingOnProxyHandlerSendsDeflate(t *testing.T) {
+	DownstreamAcceptEncodingContentEncodingHTTP11("deflate", true, "deflate", "/mse6/get", t)
+	DownstreamAcceptEncodingContentEncodingHTTP11("x-deflate", true, "deflate", "/mse6/get", t)
+}
+
+func TestZstdEncodingOnProxyHandlerSendsEncodedZstd(t *testing.T) {
+	resp := DownstreamContentEncodingIntegrity("zstd", true, "zstd", false, "/mse6/get", t)
+	raw, err := j8a.ZstdDecode(resp)
+	if err != nil || !strings.Contains(string(*raw), "mse6") {
+		t.Errorf("zstd response should contain mse6 response")
+	}
 }
 
 func TestCompressEncodingOnProxyHandlerSends406(t *testing.T) {
@@ -268,7 +276,7 @@ func TestIdentityCOMMAGzipEncodingOnAboutHandlerSendsPreferredIdentity(t *testin
 }
 
 func TestIdentityCOMMABadEncodingOnAboutHandlerSendsIdentity(t *testing.T) {
-	DownstreamAcceptEncodingContentEncodingHTTP11("deflate, identity", true, "identity", "/about", t)
+	DownstreamAcceptEncodingContentEncodingHTTP11("compress, identity", true, "identity", "/about", t)
 }
 
 func TestBadEncodingOnAboutHandlerSends406(t *testing.T) {
@@ -300,8 +308,8 @@ func TestBrotliEncodingOnAboutHandlerSendsEncodedBrotli(t *testing.T) {
 }
 
 func TestDeflateEncodingOnAboutHandler(t *testing.T) {
-	DownstreamAcceptEncodingContentEncodingHTTP11("deflate", true, "406", "/about", t)
-	DownstreamAcceptEncodingContentEncodingHTTP11("x-deflate", true, "406", "/about", t)
+	DownstreamAcceptEncodingContentEncodingHTTP11("deflate", true, "deflate", "/about", t)
+	DownstreamAcceptEncodingContentEncodingHTTP11("x-deflate", true, "deflate", "/about", t)
 }
 
 func TestCompressEncodingOnAboutHandler(t *testing.T) {
@@ -1,8 +1,6 @@
 package content
 
 import (
-	"bytes"
-	"github.com/klauspost/compress/flate"
 	"github.com/simonmittag/j8a"
 	"io/ioutil"
 	"net/http"
@@ -133,9 +131,25 @@ func TestContentEncodingPermutationsOnProxyHandlerUpstreamNoContentEncoding(t *t
 			false,
 			"nocontentenc",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/mse6/nocontentenc",
+		"deflateAcceptEncodingSendsDeflate": {"/mse6/nocontentenc",
 			"deflate",
 			true,
+			200,
+			"deflate",
+			false,
+			"nocontentenc",
+		},
+		"zstdAcceptEncodingSendsZstd": {"/mse6/nocontentenc",
+			"zstd",
+			true,
+			200,
+			"zstd",
+			false,
+			"nocontentenc",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/mse6/nocontentenc",
+			"compress",
+			true,
 			406,
 			"identity",
 			false,
@@ -283,9 +297,17 @@ func TestContentEncodingPermutationsOnProxyHandlerUpstreamUnknownContentEncoding
 			false,
 			"unknowncontentenc",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/mse6/unknowncontentenc",
+		"deflateAcceptEncodingSendsEncodedWithVary": {"/mse6/unknowncontentenc",
 			"deflate",
 			true,
+			200,
+			"unknown",
+			true,
+			"unknowncontentenc",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/mse6/unknowncontentenc",
+			"compress",
+			true,
 			406,
 			"identity",
 			false,
@@ -432,9 +454,17 @@ func TestContentEncodingPermutationsOnProxyHandlerUpstreamIdentity(t *testing.T)
 			false,
 			"get",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/mse6/get",
+		"deflateAcceptEncodingSendsDeflate": {"/mse6/get",
 			"deflate",
 			true,
+			200,
+			"deflate",
+			false,
+			"get",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/mse6/get",
+			"compress",
+			true,
 			406,
 			"identity",
 			false,
@@ -596,9 +626,17 @@ func TestContentEncodingPermutationsOnProxyHandlerUpstreamGzip(t *testing.T) {
 			false,
 			"gzip",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/mse6/gzip",
+		"deflateAcceptEncodingSendsGzipWithVary": {"/mse6/gzip",
 			"deflate",
 			true,
+			200,
+			"gzip",
+			true,
+			"gzip",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/mse6/gzip",
+			"compress",
+			true,
 			406,
 			"identity",
 			false,
@@ -744,9 +782,17 @@ func TestContentEncodingPermutationsOnProxyHandlerUpstreamBrotli(t *testing.T) {
 			false,
 			"brotli",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/mse6/brotli",
+		"deflateAcceptEncodingSendsBrotliWithVary": {"/mse6/brotli",
 			"deflate",
 			true,
+			200,
+			"br",
+			true,
+			"brotli",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/mse6/brotli",
+			"compress",
+			true,
 			406,
 			"identity",
 			false,
@@ -892,9 +938,17 @@ func TestContentEncodingPermutationsOnProxyHandlerUpstreamDeflate(t *testing.T)
 			false,
 			"deflate",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/mse6/deflate",
+		"deflateAcceptEncodingSendsDeflate": {"/mse6/deflate",
 			"deflate",
 			true,
+			200,
+			"deflate",
+			false,
+			"deflate",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/mse6/deflate",
+			"compress",
+			true,
 			406,
 			"identity",
 			false,
@@ -1025,9 +1079,25 @@ func TestContentEncodingPermutationsOnAboutHandler(t *testing.T) {
 			false,
 			"ServerID",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/about",
+		"deflateAcceptEncodingSendsDeflate": {"/about",
 			"deflate",
 			true,
+			200,
+			"deflate",
+			false,
+			"ServerID",
+		},
+		"zstdAcceptEncodingSendsZstd": {"/about",
+			"zstd",
+			true,
+			200,
+			"zstd",
+			false,
+			"ServerID",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/about",
+			"compress",
+			true,
 			406,
 			"identity",
 			false,
@@ -1158,8 +1228,16 @@ func TestContentEncodingPermutationsOnStatusCodeResponseInProxyHandler(t *testin
 			false,
 			"404",
 		},
-		"deflateAcceptEncodingSends406ResponseCode": {"/badslug",
+		"deflateAcceptEncodingSendsDeflate": {"/badslug",
+			"deflate",
+			true,
+			404,
 			"deflate",
+			false,
+			"404",
+		},
+		"compressAcceptEncodingSends406ResponseCode": {"/badslug",
+			"compress",
 			true,
 			406,
 			"identity",
@@ -1235,7 +1313,13 @@ func DownstreamContentEncodingFullIntegrity(reqUrlSlug string, reqAcceptEncoding
 		} else if wantResContentEncodingHeader == "gzip" {
 			body = *j8a.Gunzip(body)
 		} else if wantResContentEncodingHeader == "deflate" {
-			body, _ = ioutil.ReadAll(flate.NewReader(bytes.NewBuffer(body)))
+			if dec, err := j8a.Inflate(body); err == nil {
+				body = *dec
+			}
+		} else if wantResContentEncodingHeader == "zstd" {
+			if dec, err := j8a.ZstdDecode(body); err == nil {
+				body = *dec
+			}
 		}
 
 		if !strings.Contains(string(body), wantResBodyContent) {
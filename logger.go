@@ -17,6 +17,7 @@ const dwnReqMethod = "dwnReqMethod"
 const dwnReqUserAgent = "dwnReqUserAgent"
 const dwnReqHttpVer = "dwnReqHttpVer"
 const dwnReqTlsVer = "dwnReqTlsVer"
+const dwnReqTlsResumed = "dwnReqTlsResumed"
 const dwnReqListnr = "dwnReqListnr"
 const upBytesRead = "upBytesRead"
 const upBytesWrite = "upBytesWrite"
@@ -0,0 +1,87 @@
+package j8a
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const mirrorResourceS = "mirrorResource"
+const mirrorReqURI = "mirrorReqURI"
+const mirrorResCode = "mirrorResCode"
+const mirrorElpsdMicros = "mirrorElpsdMicros"
+const mirrorErr = "mirrorErr"
+const mirrorResponseDiscarded = "mirror response discarded"
+const mirrorRequestFailed = "mirror request failed"
+const mirrorRequestDropped = "mirror request dropped, too many in flight"
+
+// mirrorMaxInFlight bounds the mirrored requests waiting for slow mirror resources.
+const mirrorMaxInFlight = 256
+
+var mirrorsInFlight = make(chan struct{}, mirrorMaxInFlight)
+
+func (m Mirror) sample() bool {
+	return rand.Float64()*100 < m.Percent
+}
+
+// mirror sends a copy of the request to the mirror resource without waiting for it. The mirrored response is
+// discarded, it never affects the downstream response. Mirrors are dropped while too many are in flight.
+func (proxy *Proxy) mirror() {
+	m := proxy.Route.Mirror
+	if proxy.isWebsocketUpgrade() || !m.sample() {
+		return
+	}
+	resource := Runner.Resources[m.Resource]
+	if len(resource) == 0 {
+		return
+	}
+	url := &resource[rand.Intn(len(resource))].URL
+	uri := proxy.resolveURI(url)
+
+	select {
+	case mirrorsInFlight <- struct{}{}:
+	default:
+		infoOrDebugEv(proxy).
+			Str(XRequestID, proxy.XRequestID).
+			Str(mirrorResourceS, m.Resource).
+			Str(mirrorReqURI, uri).
+			Msg(mirrorRequestDropped)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(proxy.upstreamContext(context.Background()), proxy.upstreamReadTimeout())
+	//the downstream body is buffered, so the mirror can read it as well.
+	req, err := http.NewRequestWithContext(ctx, proxy.Dwn.Method, uri, proxy.bodyReader())
+	if err != nil {
+		cancel()
+		<-mirrorsInFlight
+		return
+	}
+	proxy.setUpstreamRequestHeaders(req)
+
+	ev := infoOrDebugEv(proxy).
+		Str(XRequestID, proxy.XRequestID).
+		Str(mirrorResourceS, m.Resource).
+		Str(mirrorReqURI, uri)
+
+	start := time.Now()
+	go func() {
+		defer func() { <-mirrorsInFlight }()
+		defer cancel()
+		res, err := httpClient.Do(req)
+		if err != nil {
+			ev.Str(mirrorErr, err.Error()).
+				Int64(mirrorElpsdMicros, time.Since(start).Microseconds()).
+				Msg(mirrorRequestFailed)
+			return
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		ev.Int(mirrorResCode, res.StatusCode).
+			Int64(mirrorElpsdMicros, time.Since(start).Microseconds()).
+			Msg(mirrorResponseDiscarded)
+	}()
+}
@@ -0,0 +1,144 @@
+package j8a
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirrorSample(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if (Mirror{Percent: 0}).sample() {
+			t.Errorf("want 0 percent never sampled")
+		}
+		if !(Mirror{Percent: 100}).sample() {
+			t.Errorf("want 100 percent always sampled")
+		}
+	}
+}
+
+func TestMirrorSendsCopyOfRequest(t *testing.T) {
+	Runner = mockRuntime()
+	httpClient = &MockHttp{}
+	mirrored := make(chan *http.Request, 1)
+	mirroredBody := make(chan []byte, 1)
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		mirroredBody <- b
+		mirrored <- req
+		return &http.Response{
+			StatusCode: 500,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte("mirror failed"))),
+		}, nil
+	}
+
+	body := []byte(`{"key":"value"}`)
+	proxy := mockProxy(body, "0", "/path", "/path", "/path/mirror?q=1", "", "")
+	proxy.Dwn.Method = "POST"
+	proxy.Dwn.Body = body
+	proxy.Dwn.Req.Header.Set("X-Custom", "custom")
+	proxy.Route.Mirror = &Mirror{Resource: "default", Percent: 100}
+	proxy.mirror()
+
+	select {
+	case req := <-mirrored:
+		if req.Method != "POST" || req.URL.String() != "http://localhost:8083/path/mirror?q=1" {
+			t.Errorf("want POST to mirror resource, got %s %s", req.Method, req.URL)
+		}
+		if req.Header.Get(XRequestID) != proxy.XRequestID || req.Header.Get("X-Custom") != "custom" {
+			t.Errorf("want downstream headers and X-Request-Id mirrored, got %v", req.Header)
+		}
+		if got := <-mirroredBody; !bytes.Equal(body, got) {
+			t.Errorf("want buffered body mirrored, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("want request mirrored")
+	}
+
+	//the downstream body is still readable for the primary upstream request
+	if b, _ := ioutil.ReadAll(proxy.bodyReader()); !bytes.Equal(body, b) {
+		t.Errorf("want downstream body intact after mirroring, got %s", b)
+	}
+}
+
+func TestMirrorFailureIsDiscarded(t *testing.T) {
+	Runner = mockRuntime()
+	httpClient = &MockHttp{}
+	done := make(chan struct{})
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		defer close(done)
+		return nil, errors.New("connection refused")
+	}
+
+	proxy := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	proxy.Dwn.Method = "GET"
+	proxy.Route.Mirror = &Mirror{Resource: "default", Percent: 100}
+	proxy.mirror()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("want request mirrored")
+	}
+	if proxy.Dwn.Resp.StatusCode != 0 {
+		t.Errorf("want downstream response untouched by mirror, got %d", proxy.Dwn.Resp.StatusCode)
+	}
+}
+
+func TestMirrorUsesRouteTimeout(t *testing.T) {
+	Runner = mockRuntime()
+	httpClient = &MockHttp{}
+	deadline := make(chan time.Duration, 1)
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		d, _ := req.Context().Deadline()
+		deadline <- time.Until(d)
+		return nil, errors.New("connection refused")
+	}
+
+	proxy := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	proxy.Dwn.Method = "GET"
+	proxy.Route.Mirror = &Mirror{Resource: "default", Percent: 100}
+	proxy.Route.Timeouts = &RouteTimeouts{UpstreamReadMillis: 200}
+	proxy.mirror()
+
+	select {
+	case d := <-deadline:
+		if d > 200*time.Millisecond {
+			t.Errorf("want mirror deadline from route upstream read timeout, got %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("want request mirrored")
+	}
+}
+
+func TestMirrorDroppedWhenTooManyInFlight(t *testing.T) {
+	Runner = mockRuntime()
+	httpClient = &MockHttp{}
+	var mirrored int32
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&mirrored, 1)
+		return nil, errors.New("connection refused")
+	}
+	for i := 0; i < mirrorMaxInFlight; i++ {
+		mirrorsInFlight <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < mirrorMaxInFlight; i++ {
+			<-mirrorsInFlight
+		}
+	}()
+
+	proxy := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	proxy.Dwn.Method = "GET"
+	proxy.Route.Mirror = &Mirror{Resource: "default", Percent: 100}
+	proxy.mirror()
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&mirrored); got != 0 {
+		t.Errorf("want mirror dropped while too many are in flight, got %d mirrored", got)
+	}
+}
@@ -0,0 +1,187 @@
+package j8a
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspRequestContentType = "application/ocsp-request"
+
+// ocspMaxResponseBytes caps what we read from a remote OCSP responder, real responses are a few KB at most.
+const ocspMaxResponseBytes = 1 << 20
+const ocspFetchTimeout = time.Second * 10
+const ocspRetryInterval = time.Hour
+const ocspMinRefreshInterval = time.Minute
+
+var errOcspNoResponder = errors.New("no OCSP responder specified in TLS certificate")
+var errOcspNoIssuer = errors.New("no issuer found in TLS certificate chain, cannot create OCSP request")
+
+var ocspClient = &http.Client{Timeout: ocspFetchTimeout}
+
+// OcspStaple is a verified OCSP response for a downstream leaf certificate.
+type OcspStaple struct {
+	Raw              []byte
+	Status           int
+	ThisUpdate       time.Time
+	NextUpdate       time.Time
+	RevokedAt        time.Time
+	RevocationReason int
+}
+
+// isFresh tells us if the staple can still be served. Responses without NextUpdate are treated as always fresh,
+// see RFC6960 4.2.2.1, we replace them on the next refresh anyway.
+func (o OcspStaple) isFresh() bool {
+	return o.NextUpdate.IsZero() || time.Now().Before(o.NextUpdate)
+}
+
+// refreshIn returns the wait period before fetching a new response, halfway through the validity window
+// so that we have plenty of time to retry before NextUpdate.
+func (o OcspStaple) refreshIn() time.Duration {
+	if o.NextUpdate.IsZero() {
+		return ocspRetryInterval
+	}
+	d := time.Until(o.ThisUpdate.Add(o.NextUpdate.Sub(o.ThisUpdate) / 2))
+	if d < ocspMinRefreshInterval {
+		d = ocspMinRefreshInterval
+	}
+	return d
+}
+
+func (o OcspStaple) printStatus() string {
+	switch o.Status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// ocspRefreshInterval decides when the stapling daemon runs next, based on the staple currently served.
+// Failed attempts are retried hourly, or sooner if the served staple expires before that.
+func ocspRefreshInterval(served *OcspStaple, err error) time.Duration {
+	if served == nil {
+		return ocspRetryInterval
+	}
+	if err == nil {
+		return served.refreshIn()
+	}
+	if d := time.Until(served.NextUpdate) / 2; !served.NextUpdate.IsZero() && d < ocspRetryInterval {
+		if d < ocspMinRefreshInterval {
+			d = ocspMinRefreshInterval
+		}
+		return d
+	}
+	return ocspRetryInterval
+}
+
+func (r *Runtime) initOcspStapling() {
+	if r.Connection.Downstream.Tls.DisableOcspStapling {
+		log.Info().Msg("OCSP stapling for TLS certificate disabled")
+		return
+	}
+
+	//TLS is served without staple until the first response arrives, so a slow responder doesn't delay startup.
+	go func() {
+		wait := ocspRefreshInterval(r.ReloadableCert.stapleOcsp())
+		for {
+			time.Sleep(wait)
+			wait = ocspRefreshInterval(r.ReloadableCert.stapleOcsp())
+		}
+	}()
+}
+
+// findIssuer looks for the certificate that signed the leaf inside the chain we serve.
+func findIssuer(chain *tls.Certificate, leaf *x509.Certificate) (*x509.Certificate, error) {
+	for _, c := range chain.Certificate[1:] {
+		ca, err := x509.ParseCertificate(c)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(ca.RawSubject, leaf.RawIssuer) && leaf.CheckSignatureFrom(ca) == nil {
+			return ca, nil
+		}
+	}
+	return nil, errOcspNoIssuer
+}
+
+// fetchOcspStaple asks the leaf certificate's OCSP responders for the current status, one after the other,
+// and returns the first response that verifies against the issuer.
+func fetchOcspStaple(chain *tls.Certificate) (*OcspStaple, error) {
+	if chain == nil || len(chain.Certificate) == 0 {
+		return nil, errors.New("no certificate data found")
+	}
+
+	leaf := chain.Leaf
+	if leaf == nil {
+		var e error
+		if leaf, e = x509.ParseCertificate(chain.Certificate[0]); e != nil {
+			return nil, e
+		}
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errOcspNoResponder
+	}
+
+	issuer, e1 := findIssuer(chain, leaf)
+	if e1 != nil {
+		return nil, e1
+	}
+
+	req, e2 := ocsp.CreateRequest(leaf, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if e2 != nil {
+		return nil, e2
+	}
+
+	var err error
+	for _, responder := range leaf.OCSPServer {
+		var raw []byte
+		raw, err = postOcspRequest(responder, req)
+		if err != nil {
+			continue
+		}
+
+		var res *ocsp.Response
+		res, err = ocsp.ParseResponseForCert(raw, leaf, issuer)
+		if err != nil {
+			err = fmt.Errorf("invalid OCSP response from %s, cause: %v", responder, err)
+			continue
+		}
+
+		return &OcspStaple{
+			Raw:              raw,
+			Status:           res.Status,
+			ThisUpdate:       res.ThisUpdate,
+			NextUpdate:       res.NextUpdate,
+			RevokedAt:        res.RevokedAt,
+			RevocationReason: res.RevocationReason,
+		}, nil
+	}
+	return nil, err
+}
+
+func postOcspRequest(responder string, req []byte) ([]byte, error) {
+	res, e1 := ocspClient.Post(responder, ocspRequestContentType, bytes.NewReader(req))
+	if e1 != nil {
+		return nil, e1
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned HTTP status %d", responder, res.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, ocspMaxResponseBytes))
+}
@@ -0,0 +1,333 @@
+package j8a
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// mockOcspResponder is a local OCSP responder stand-in that signs responses with the test CA.
+type mockOcspResponder struct {
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	status int
+	valid  time.Duration
+	hits   int
+}
+
+func (m *mockOcspResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.hits++
+	body, _ := io.ReadAll(r.Body)
+	req, err := ocsp.ParseRequest(body)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	now := time.Now().Add(-time.Minute)
+	tmpl := ocsp.Response{
+		Status:       m.status,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(m.valid),
+	}
+	if m.status == ocsp.Revoked {
+		tmpl.RevokedAt = now.Add(-time.Hour)
+		tmpl.RevocationReason = ocsp.KeyCompromise
+	}
+	res, _ := ocsp.CreateResponse(m.ca, m.ca, tmpl, m.caKey)
+	w.Header().Set(contentType, "application/ocsp-response")
+	w.Write(res)
+}
+
+// mockOcspChain creates a CA and a leaf certificate pointing to responder URL as PEM, CA included in the chain.
+func mockOcspChain(t *testing.T, responder string) (*mockOcspResponder, []byte, []byte) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "j8a ocsp test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("unable to create test CA, cause: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDer)
+
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	//every chain gets its own leaf serial, like a renewed certificate would.
+	leafSerial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	leafTmpl := &x509.Certificate{
+		SerialNumber: leafSerial,
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{responder},
+	}
+	leafDer, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("unable to create test leaf certificate, cause: %v", err)
+	}
+
+	var cert bytes.Buffer
+	pem.Encode(&cert, &pem.Block{Type: "CERTIFICATE", Bytes: leafDer})
+	pem.Encode(&cert, &pem.Block{Type: "CERTIFICATE", Bytes: caDer})
+
+	keyDer, _ := x509.MarshalECPrivateKey(leafKey)
+	key := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return &mockOcspResponder{ca: ca, caKey: caKey, status: ocsp.Good, valid: time.Hour * 24}, cert.Bytes(), key
+}
+
+func mockOcspRunner(t *testing.T) (*mockOcspResponder, *httptest.Server) {
+	var m *mockOcspResponder
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.ServeHTTP(w, r)
+	}))
+
+	var cert, key []byte
+	m, cert, key = mockOcspChain(t, srv.URL)
+
+	mockRunner()
+	Runner.Connection.Downstream.Tls.Cert = string(cert)
+	Runner.Connection.Downstream.Tls.Key = string(key)
+	if err := Runner.ReloadableCert.triggerInit(); err != nil {
+		t.Fatalf("unable to init test certificate, cause: %v", err)
+	}
+	return m, srv
+}
+
+func TestStapleOcspGoodResponse(t *testing.T) {
+	m, srv := mockOcspRunner(t)
+	defer srv.Close()
+
+	staple, err := Runner.ReloadableCert.stapleOcsp()
+	if err != nil {
+		t.Fatalf("OCSP staple should have been fetched, but got %v", err)
+	}
+	if staple.Status != ocsp.Good {
+		t.Errorf("OCSP staple should have status good, but was %s", staple.printStatus())
+	}
+	if m.hits != 1 {
+		t.Errorf("OCSP responder should have been called once, but was %d", m.hits)
+	}
+	if !bytes.Equal(Runner.ReloadableCert.Cert.OCSPStaple, staple.Raw) {
+		t.Errorf("OCSP response not stapled to certificate")
+	}
+}
+
+func TestInitOcspStaplingDoesNotWaitForResponder(t *testing.T) {
+	m, srv := mockOcspRunner(t)
+	defer srv.Close()
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		m.ServeHTTP(w, r)
+	})
+
+	start := time.Now()
+	Runner.initOcspStapling()
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("OCSP stapling should start in the background, but blocked for %v", d)
+	}
+
+	stapled := func() bool {
+		Runner.ReloadableCert.mu.Lock()
+		defer Runner.ReloadableCert.mu.Unlock()
+		return Runner.ReloadableCert.Cert.OCSPStaple != nil
+	}
+	for deadline := time.Now().Add(2 * time.Second); !stapled(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("OCSP response should have been stapled in the background")
+		}
+	}
+}
+
+func TestStapleOcspRevokedResponseNotStapled(t *testing.T) {
+	m, srv := mockOcspRunner(t)
+	defer srv.Close()
+
+	Runner.ReloadableCert.stapleOcsp()
+	m.status = ocsp.Revoked
+
+	staple, err := Runner.ReloadableCert.stapleOcsp()
+	if err == nil {
+		t.Errorf("revoked OCSP response should have returned error")
+	}
+	if staple != nil {
+		t.Errorf("no staple should be served for revoked certificate")
+	}
+	if Runner.ReloadableCert.Cert.OCSPStaple != nil {
+		t.Errorf("previous good OCSP response should have been removed for revoked certificate")
+	}
+}
+
+func TestStapleOcspUnknownResponseNotStapled(t *testing.T) {
+	m, srv := mockOcspRunner(t)
+	defer srv.Close()
+	m.status = ocsp.Unknown
+
+	_, err := Runner.ReloadableCert.stapleOcsp()
+	if err == nil {
+		t.Errorf("unknown OCSP response should have returned error")
+	}
+	if Runner.ReloadableCert.Cert.OCSPStaple != nil {
+		t.Errorf("unknown OCSP response should not be stapled")
+	}
+}
+
+func TestStapleOcspKeepsCachedResponseWhenResponderDown(t *testing.T) {
+	_, srv := mockOcspRunner(t)
+
+	good, _ := Runner.ReloadableCert.stapleOcsp()
+	srv.Close()
+
+	served, err := Runner.ReloadableCert.stapleOcsp()
+	if err == nil {
+		t.Errorf("unreachable OCSP responder should have returned error")
+	}
+	if served != good {
+		t.Errorf("cached OCSP response should still be served")
+	}
+	if !bytes.Equal(Runner.ReloadableCert.Cert.OCSPStaple, good.Raw) {
+		t.Errorf("cached OCSP response should still be stapled")
+	}
+}
+
+func TestStapleOcspDetachesExpiredResponseWhenResponderDown(t *testing.T) {
+	_, srv := mockOcspRunner(t)
+
+	good, _ := Runner.ReloadableCert.stapleOcsp()
+	good.NextUpdate = time.Now().Add(-time.Second)
+	srv.Close()
+
+	served, _ := Runner.ReloadableCert.stapleOcsp()
+	if served != nil {
+		t.Errorf("expired OCSP response should not be served")
+	}
+	if Runner.ReloadableCert.Cert.OCSPStaple != nil {
+		t.Errorf("expired OCSP response should have been detached")
+	}
+}
+
+func TestTriggerInitReattachesCachedOcspStaple(t *testing.T) {
+	m, srv := mockOcspRunner(t)
+	defer srv.Close()
+
+	good, _ := Runner.ReloadableCert.stapleOcsp()
+	if err := Runner.ReloadableCert.triggerInit(); err != nil {
+		t.Fatalf("unable to re-init certificate, cause: %v", err)
+	}
+	if !bytes.Equal(Runner.ReloadableCert.Cert.OCSPStaple, good.Raw) {
+		t.Errorf("cached OCSP response should have been stapled on re-init")
+	}
+	if m.hits != 1 {
+		t.Errorf("re-init should not call OCSP responder, but was called %d times", m.hits)
+	}
+}
+
+func TestSwapCertPrunesOcspStaplesOfReplacedCert(t *testing.T) {
+	_, srv := mockOcspRunner(t)
+	defer srv.Close()
+
+	Runner.ReloadableCert.stapleOcsp()
+	old := formatSerial(Runner.ReloadableCert.Cert.Leaf.SerialNumber)
+
+	_, cert, key := mockOcspChain(t, srv.URL)
+	if err := Runner.ReloadableCert.swap(cert, key); err != nil {
+		t.Fatalf("unable to swap certificate, cause: %v", err)
+	}
+	if _, ok := Runner.ReloadableCert.ocspStaples[old]; ok {
+		t.Errorf("OCSP response of replaced certificate #%v should have been pruned", old)
+	}
+}
+
+func TestGetCertificateFuncWhileStapling(t *testing.T) {
+	_, srv := mockOcspRunner(t)
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if c, _ := Runner.ReloadableCert.GetCertificateFunc(&tls.ClientHelloInfo{}); c == nil {
+				t.Errorf("certificate should be served while stapling")
+				return
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		Runner.ReloadableCert.stapleOcsp()
+	}
+	<-done
+}
+
+func TestFetchOcspStapleNoResponder(t *testing.T) {
+	mockTlsConfig()
+	_, err := fetchOcspStaple(Runner.ReloadableCert.Cert)
+	if err != errOcspNoResponder {
+		t.Errorf("certificate without OCSP responder should return errOcspNoResponder, but got %v", err)
+	}
+}
+
+func TestFetchOcspStapleNoIssuer(t *testing.T) {
+	_, srv := mockOcspRunner(t)
+	defer srv.Close()
+
+	leafOnly := tls.Certificate{
+		Certificate: Runner.ReloadableCert.Cert.Certificate[:1],
+		Leaf:        Runner.ReloadableCert.Cert.Leaf,
+	}
+	_, err := fetchOcspStaple(&leafOnly)
+	if err != errOcspNoIssuer {
+		t.Errorf("certificate without issuer in chain should return errOcspNoIssuer, but got %v", err)
+	}
+}
+
+func TestOcspStapleRefreshIn(t *testing.T) {
+	now := time.Now()
+	staple := OcspStaple{ThisUpdate: now, NextUpdate: now.Add(time.Hour * 48)}
+	if d := staple.refreshIn(); d < time.Hour*23 || d > time.Hour*24 {
+		t.Errorf("OCSP staple should refresh halfway through validity, but was %v", d)
+	}
+
+	staple = OcspStaple{ThisUpdate: now.Add(-time.Hour * 48), NextUpdate: now.Add(time.Second)}
+	if d := staple.refreshIn(); d != ocspMinRefreshInterval {
+		t.Errorf("OCSP staple refresh should not be shorter than %v, but was %v", ocspMinRefreshInterval, d)
+	}
+
+	staple = OcspStaple{ThisUpdate: now}
+	if d := staple.refreshIn(); d != ocspRetryInterval {
+		t.Errorf("OCSP staple without next update should refresh in %v, but was %v", ocspRetryInterval, d)
+	}
+}
+
+func TestOcspRefreshIntervalAfterFailure(t *testing.T) {
+	if d := ocspRefreshInterval(nil, errOcspNoResponder); d != ocspRetryInterval {
+		t.Errorf("failed OCSP fetch without staple should retry in %v, but was %v", ocspRetryInterval, d)
+	}
+
+	served := &OcspStaple{ThisUpdate: time.Now().Add(-time.Hour * 47), NextUpdate: time.Now().Add(time.Minute * 30)}
+	if d := ocspRefreshInterval(served, errOcspNoIssuer); d >= time.Minute*30 {
+		t.Errorf("failed OCSP fetch should retry before served staple expires, but was %v", d)
+	}
+}
@@ -1,7 +1,9 @@
 package j8a
 
 import (
+	"fmt"
 	"math/rand"
+	"net/http"
 	"sort"
 )
 
@@ -9,8 +11,22 @@ import (
 type LabelWeight struct {
 	Label  string
 	Weight float64
+	//Match pins the label for requests matching any rule, regardless of weight
+	Match []LabelMatch
 }
 
+//LabelMatch matches a request by the value of a header, cookie or validated jwt claim. Only one of them is set.
+type LabelMatch struct {
+	Header string
+	Cookie string
+	Claim  string
+	Value  string
+}
+
+const labelPinned = "policy label pinned by match rule"
+const labelSticky = "policy label pinned by sticky cookie"
+const labelS = "label"
+
 //Policy defines an array of LabelWeights used for routing
 type Policy []LabelWeight
 
@@ -28,22 +44,130 @@ func (policy Policy) Less(i, j int) bool {
 //resolve a label inside a policy
 func (policy Policy) resolveLabel() string {
 	dice := rand.Float64()
-	sort.Sort(Policy(policy))
+	//sort a copy, the shared policy stays in config order for match rules and concurrent requests.
+	weighted := make(Policy, len(policy))
+	copy(weighted, policy)
+	sort.Stable(weighted)
 	var cw []float64
 	//add up cumulative weights 0 < w < 1
-	for i := 1; i <= len(policy); i++ {
+	for i := 1; i <= len(weighted); i++ {
 		var sum float64 = 0
-		for i := range policy[0:i] {
-			sum += policy[i].Weight
+		for i := range weighted[0:i] {
+			sum += weighted[i].Weight
 		}
 		cw = append(cw, sum)
 	}
 	//select a random number 0 < dice < 1 inside distribution
 	//and map label
-	for i := 0; i < len(policy); i++ {
+	for i := 0; i < len(weighted); i++ {
 		if dice < cw[i] {
-			return policy[i].Label
+			return weighted[i].Label
 		}
 	}
 	return "default"
 }
+
+func (m LabelMatch) isValid() bool {
+	set := 0
+	for _, v := range []string{m.Header, m.Cookie, m.Claim} {
+		if len(v) > 0 {
+			set++
+		}
+	}
+	return set == 1 && len(m.Value) > 0
+}
+
+func (m LabelMatch) matches(req *http.Request, claims map[string]interface{}) bool {
+	switch {
+	case len(m.Header) > 0:
+		for _, v := range req.Header.Values(m.Header) {
+			if v == m.Value {
+				return true
+			}
+		}
+	case len(m.Cookie) > 0:
+		if c, err := req.Cookie(m.Cookie); err == nil {
+			return c.Value == m.Value
+		}
+	case len(m.Claim) > 0:
+		switch c := claims[m.Claim].(type) {
+		case nil:
+			return false
+		case []interface{}:
+			for _, v := range c {
+				if fmt.Sprint(v) == m.Value {
+					return true
+				}
+			}
+		default:
+			return fmt.Sprint(c) == m.Value
+		}
+	}
+	return false
+}
+
+//matchLabel returns the first label with a rule matching the request
+func (policy Policy) matchLabel(req *http.Request, claims map[string]interface{}) (string, bool) {
+	for _, lw := range policy {
+		for _, m := range lw.Match {
+			if m.matches(req, claims) {
+				return lw.Label, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (policy Policy) hasLabel(label string) bool {
+	for _, lw := range policy {
+		if lw.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+//policyLabel resolves the label of the route's policy once per request, so cache keys and upstream mapping agree.
+func (proxy *Proxy) policyLabel(route Route) string {
+	if len(proxy.label) == 0 && len(route.Policy) > 0 {
+		proxy.label = proxy.resolvePolicyLabel(route, Runner.Policies[route.Policy])
+	}
+	return proxy.label
+}
+
+//resolvePolicyLabel pins a label with match rules, then a sticky cookie, before resolving it by weight.
+func (proxy *Proxy) resolvePolicyLabel(route Route, policy Policy) string {
+	req := proxy.Dwn.Req
+	if label, ok := policy.matchLabel(req, proxy.Dwn.JwtClaims); ok {
+		infoOrTraceEv(proxy).
+			Str(XRequestID, proxy.XRequestID).
+			Str(policyMsg, route.Policy).
+			Str(labelS, label).
+			Msg(labelPinned)
+		return label
+	}
+
+	if len(route.StickyCookie) > 0 {
+		if c, err := req.Cookie(route.StickyCookie); err == nil && policy.hasLabel(c.Value) {
+			infoOrTraceEv(proxy).
+				Str(XRequestID, proxy.XRequestID).
+				Str(policyMsg, route.Policy).
+				Str(labelS, c.Value).
+				Msg(labelSticky)
+			return c.Value
+		}
+	}
+
+	label := policy.resolveLabel()
+	if len(route.StickyCookie) > 0 {
+		http.SetCookie(proxy.Dwn.Resp.Writer, &http.Cookie{
+			Name:     route.StickyCookie,
+			Value:    label,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return label
+}
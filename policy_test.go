@@ -1,6 +1,7 @@
 package j8a
 
 import (
+	"net/http"
 	"testing"
 )
 
@@ -74,3 +75,147 @@ func TestPolicyResolveLabelWithDistributedWeight(t *testing.T) {
 		t.Errorf("incorrect amount of greens labels resolved, wanted percentage %v, got %v", wantGreen, pcgotgreen)
 	}
 }
+
+func mockCanaryPolicy() Policy {
+	return Policy{
+		LabelWeight{
+			Label:  "green",
+			Weight: 1,
+		},
+		LabelWeight{
+			Label:  "blue",
+			Weight: 0,
+			Match: []LabelMatch{
+				{Header: "X-Canary", Value: "blue"},
+				{Cookie: "canary", Value: "blue"},
+				{Claim: "groups", Value: "qa"},
+			},
+		},
+	}
+}
+
+func TestLabelMatchIsValid(t *testing.T) {
+	tests := map[string]struct {
+		m    LabelMatch
+		want bool
+	}{
+		"header":      {LabelMatch{Header: "X-Canary", Value: "blue"}, true},
+		"cookie":      {LabelMatch{Cookie: "canary", Value: "blue"}, true},
+		"claim":       {LabelMatch{Claim: "sub", Value: "qa"}, true},
+		"noValue":     {LabelMatch{Header: "X-Canary"}, false},
+		"noSelector":  {LabelMatch{Value: "blue"}, false},
+		"twoSelector": {LabelMatch{Header: "X-Canary", Cookie: "canary", Value: "blue"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.m.isValid(); got != tt.want {
+				t.Errorf("want valid %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLabelMatchMatches(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":    "tester",
+		"groups": []interface{}{"dev", "qa"},
+		"level":  float64(3),
+	}
+	tests := map[string]struct {
+		m      LabelMatch
+		header string
+		cookie string
+		want   bool
+	}{
+		"header":          {LabelMatch{Header: "X-Canary", Value: "blue"}, "blue", "", true},
+		"headerOther":     {LabelMatch{Header: "X-Canary", Value: "blue"}, "green", "", false},
+		"headerMissing":   {LabelMatch{Header: "X-Canary", Value: "blue"}, "", "", false},
+		"cookie":          {LabelMatch{Cookie: "canary", Value: "blue"}, "", "blue", true},
+		"cookieOther":     {LabelMatch{Cookie: "canary", Value: "blue"}, "", "green", false},
+		"claim":           {LabelMatch{Claim: "sub", Value: "tester"}, "", "", true},
+		"claimArray":      {LabelMatch{Claim: "groups", Value: "qa"}, "", "", true},
+		"claimNumber":     {LabelMatch{Claim: "level", Value: "3"}, "", "", true},
+		"claimMissing":    {LabelMatch{Claim: "email", Value: "tester"}, "", "", false},
+		"claimArrayOther": {LabelMatch{Claim: "groups", Value: "ops"}, "", "", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if len(tt.header) > 0 {
+				req.Header.Set("X-Canary", tt.header)
+			}
+			if len(tt.cookie) > 0 {
+				req.AddCookie(&http.Cookie{Name: "canary", Value: tt.cookie})
+			}
+			if got := tt.m.matches(req, claims); got != tt.want {
+				t.Errorf("want match %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolvePolicyLabelPinnedByMatch(t *testing.T) {
+	Runner = mockRuntime()
+	route := Route{Policy: "canary", StickyCookie: "j8a-label"}
+
+	proxy := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	proxy.Dwn.Req.Header.Set("X-Canary", "blue")
+	if got := proxy.resolvePolicyLabel(route, mockCanaryPolicy()); got != "blue" {
+		t.Errorf("want label pinned by header, got %s", got)
+	}
+	if got := proxy.Dwn.Resp.Writer.Header().Get("Set-Cookie"); len(got) > 0 {
+		t.Errorf("want no sticky cookie for pinned label, got %s", got)
+	}
+
+	claims := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	claims.Dwn.JwtClaims = map[string]interface{}{"groups": []interface{}{"qa"}}
+	if got := claims.resolvePolicyLabel(route, mockCanaryPolicy()); got != "blue" {
+		t.Errorf("want label pinned by jwt claim, got %s", got)
+	}
+}
+
+func TestMatchLabelKeepsConfigOrderAfterResolveLabel(t *testing.T) {
+	p := Policy{
+		LabelWeight{Label: "blue", Weight: 0.9, Match: []LabelMatch{{Header: "X-Canary", Value: "on"}}},
+		LabelWeight{Label: "green", Weight: 0.1, Match: []LabelMatch{{Cookie: "canary", Value: "on"}}},
+	}
+	p.resolveLabel()
+
+	req, _ := http.NewRequest("GET", "/path", nil)
+	req.Header.Set("X-Canary", "on")
+	req.AddCookie(&http.Cookie{Name: "canary", Value: "on"})
+	if got, _ := p.matchLabel(req, nil); got != "blue" {
+		t.Errorf("want first match rule in config order after resolving by weight, got %s", got)
+	}
+}
+
+func TestResolvePolicyLabelStickyCookie(t *testing.T) {
+	Runner = mockRuntime()
+	route := Route{Policy: "canary", StickyCookie: "j8a-label"}
+
+	first := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	if got := first.resolvePolicyLabel(route, mockCanaryPolicy()); got != "green" {
+		t.Errorf("want label resolved by weight, got %s", got)
+	}
+	c := (&http.Response{Header: first.Dwn.Resp.Writer.Header()}).Cookies()
+	if len(c) != 1 || c[0].Name != "j8a-label" || c[0].Value != "green" || !c[0].HttpOnly {
+		t.Errorf("want sticky cookie with resolved label, got %v", c)
+	}
+
+	sticky := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	sticky.Dwn.Req.AddCookie(&http.Cookie{Name: "j8a-label", Value: "blue"})
+	for i := 0; i < 10; i++ {
+		if got := sticky.resolvePolicyLabel(route, mockCanaryPolicy()); got != "blue" {
+			t.Errorf("want label from sticky cookie, got %s", got)
+		}
+	}
+	if got := sticky.Dwn.Resp.Writer.Header().Get("Set-Cookie"); len(got) > 0 {
+		t.Errorf("want no new sticky cookie, got %s", got)
+	}
+
+	unknown := mockProxy(nil, "0", "/path", "/path", "/path", "", "")
+	unknown.Dwn.Req.AddCookie(&http.Cookie{Name: "j8a-label", Value: "red"})
+	if got := unknown.resolvePolicyLabel(route, mockCanaryPolicy()); got != "green" {
+		t.Errorf("want unknown sticky label ignored, got %s", got)
+	}
+}
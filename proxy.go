@@ -61,12 +61,14 @@ const (
 	EncXDeflate  ContentEncoding = "x-deflate"
 	EncCompress  ContentEncoding = "compress"
 	EncXCompress ContentEncoding = "x-compress"
+	EncZstd      ContentEncoding = "zstd"
 )
 
 var GzipContentEncodings = AcceptEncoding{EncGzip, EncXGzip}
-var CompressedContentEncodings = AcceptEncoding{EncBrotli, EncGzip, EncXGzip, EncDeflate, EncXDeflate, EncCompress, EncXCompress}
-var SupportedContentEncodings = AcceptEncoding{EncStar, EncIdentity, EncBrotli, EncGzip, EncXGzip}
-var UnsupportedContentEncodings = AcceptEncoding{EncDeflate, EncXDeflate, EncCompress, EncXCompress}
+var DeflateContentEncodings = AcceptEncoding{EncDeflate, EncXDeflate}
+var CompressedContentEncodings = AcceptEncoding{EncBrotli, EncGzip, EncXGzip, EncDeflate, EncXDeflate, EncCompress, EncXCompress, EncZstd}
+var SupportedContentEncodings = AcceptEncoding{EncStar, EncIdentity, EncBrotli, EncGzip, EncXGzip, EncDeflate, EncXDeflate, EncZstd}
+var UnsupportedContentEncodings = AcceptEncoding{EncCompress, EncXCompress}
 
 func NewContentEncoding(raw string) ContentEncoding {
 	encs := strings.TrimFunc(raw, func(r rune) bool {
@@ -130,9 +132,55 @@ func (c ContentEncoding) isBrotli() bool {
 	return c == EncBrotli
 }
 
+func (c ContentEncoding) isDeflate() bool {
+	for _, ce := range DeflateContentEncodings {
+		if ce == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (c ContentEncoding) isZstd() bool {
+	return c == EncZstd
+}
+
 const xdash string = "x-"
 
+const semicolon = ";"
+const qParam = "q"
+
+// coding strips Accept-Encoding parameters, i.e. gzip for "gzip;q=0.5"
+func (c ContentEncoding) coding() ContentEncoding {
+	if i := strings.Index(string(c), semicolon); i >= 0 {
+		return NewContentEncoding(string(c)[:i])
+	}
+	return c
+}
+
+// q is the RFC 9110 quality value of an Accept-Encoding element, defaults to 1. Malformed values are treated
+// as 0, i.e. not acceptable.
+func (c ContentEncoding) q() float64 {
+	params := strings.Split(string(c), semicolon)
+	for _, p := range params[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		//parameter names are case-insensitive, see RFC 9110 12.4.2
+		if strings.EqualFold(strings.TrimSpace(kv[0]), qParam) {
+			if len(kv) < 2 {
+				return 0
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || q < 0 || q > 1 {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
 func (c ContentEncoding) matches(encoding ContentEncoding) bool {
+	c = c.coding()
 	if len(c) == 0 && encoding == EncIdentity {
 		return true
 	} else if len(string(encoding)) == 0 {
@@ -160,18 +208,45 @@ func (ae AcceptEncoding) hasAtLeastOneValidEncoding() bool {
 		valid = true
 	} else {
 		for _, ce := range ae {
-			valid = valid || ce.isSupported()
+			valid = valid || (ce.coding().isSupported() && ce.q() > 0)
 		}
 	}
 	return valid
 }
 
+// isCompatible is true if enc is acceptable with a q-value above 0.
 func (ae AcceptEncoding) isCompatible(enc ContentEncoding) bool {
-	var comp = false
+	return ae.qOf(enc) > 0
+}
+
+// qOf is the q-value for enc. Explicitly listed codings take precedence over *, so "*, gzip;q=0" refuses gzip.
+func (ae AcceptEncoding) qOf(enc ContentEncoding) float64 {
+	var star = -1.0
 	for _, ce := range ae {
-		comp = comp || ce.matches(enc)
+		if ce.coding() == EncStar {
+			star = ce.q()
+		} else if ce.matches(enc) {
+			return ce.q()
+		}
 	}
-	return comp
+	if star >= 0 && len(enc) > 0 {
+		return star
+	}
+	return 0
+}
+
+// negotiate picks the acceptable encoding with the highest q-value. Ties go to the first in the server's preference.
+// Returns empty ContentEncoding if none of the preferred encodings are acceptable.
+func (ae AcceptEncoding) negotiate(preference AcceptEncoding) ContentEncoding {
+	var best ContentEncoding
+	var bestQ = 0.0
+	for _, enc := range preference {
+		if q := ae.qOf(enc); q > bestQ {
+			best = enc
+			bestQ = q
+		}
+	}
+	return best
 }
 
 const commaSpace = ", "
@@ -204,8 +279,8 @@ type Atmpt struct {
 	startDate       time.Time
 }
 
-func (atmpt Atmpt) print() string {
-	return fmt.Sprintf("%d/%d", atmpt.Count, Runner.Connection.Upstream.MaxAttempts)
+func (atmpt Atmpt) print(maxAttempts int) string {
+	return fmt.Sprintf("%d/%d", atmpt.Count, maxAttempts)
 }
 
 // Resp wraps downstream http response writer and data
@@ -223,6 +298,8 @@ type Up struct {
 	Atmpt  *Atmpt
 	Atmpts []Atmpt
 	Count  int
+	// Hedges are attempts racing another one, they don't count towards maxAttempts
+	Hedges int
 }
 
 // Down wraps downstream exchange
@@ -236,14 +313,18 @@ type Down struct {
 	UserAgent      string
 	AcceptEncoding AcceptEncoding
 	Body           []byte
+	BodyEncoding   ContentEncoding
+	JwtClaims      map[string]interface{}
 	Aborted        <-chan struct{}
 	AbortedFlag    bool
 	Timeout        <-chan struct{}
 	TimeoutFlag    bool
+	timer          *time.Timer
 	ReqTooLarge    bool
 	startDate      time.Time
 	HttpVer        string
 	TlsVer         string
+	TlsResumed     bool
 	Port           int
 	Listener       string
 }
@@ -255,6 +336,10 @@ type Proxy struct {
 	Up           Up
 	Dwn          Down
 	Route        *Route
+	cache        *proxyCache
+	flight       *flight
+	//label of the route policy, once resolved
+	label string
 }
 
 func (proxy *Proxy) hasDownstreamAbortedOrTimedout() bool {
@@ -276,13 +361,24 @@ func (proxy *Proxy) hasDownstreamAbortedOrTimedout() bool {
 }
 
 func (proxy *Proxy) resolveUpstreamURI() string {
-	uri := proxy.Up.Atmpt.URL.String() + proxy.Dwn.URI
-	if len(proxy.Route.Transform) > 0 {
+	return proxy.resolveURI(proxy.Up.Atmpt.URL)
+}
+
+func (proxy *Proxy) resolveURI(url *URL) string {
+	return url.String() + proxy.upstreamRequestURI()
+}
+
+// upstreamRequestURI is the downstream request URI after the route's rewrite or transform.
+func (proxy *Proxy) upstreamRequestURI() string {
+	uri := proxy.Dwn.URI
+	if proxy.Route.CompiledRewrite != nil {
+		uri = proxy.rewrite()
+	} else if len(proxy.Route.Transform) > 0 {
 		t := proxy.Route.Transform
 		if t == "/" {
 			t = ""
 		}
-		uri = proxy.Up.Atmpt.URL.String() + strings.Replace(proxy.Dwn.URI, proxy.Route.Path, t, 1)
+		uri = strings.Replace(proxy.Dwn.URI, proxy.Route.Path, t, 1)
 	}
 	return uri
 }
@@ -315,18 +411,10 @@ const upstreamRetriesStopped = "upstream retries stopped"
 
 func (proxy *Proxy) shouldRetryUpstreamAttempt() bool {
 
-	// part one is checking for repeatable methods. we don't retry i.e. POST
-	retry := false
-Retry:
-	for _, method := range httpRepeatableMethods {
-		if proxy.Dwn.Method == method {
-			if proxy.Up.Atmpt.Count < Runner.Connection.Upstream.MaxAttempts {
-				retry = true
-				break Retry
-			}
-			retry = false
-		}
-	}
+	// part one is checking for repeatable methods. we don't retry i.e. POST without Idempotency-Key
+	retry := proxy.isRepeatable() &&
+		proxy.attempts() < proxy.maxAttempts() &&
+		proxy.isRetryableFailure()
 
 	// once downstream context has signalled, do not re-attempt upstream
 	if proxy.hasDownstreamAbortedOrTimedout() {
@@ -341,6 +429,10 @@ Retry:
 	return retry
 }
 
+func (proxy *Proxy) isWebsocketUpgrade() bool {
+	return proxy.Dwn.Req != nil && proxy.Dwn.Req.Header.Get(UpgradeHeader) == websocket
+}
+
 func (proxy *Proxy) hasMadeUpstreamAttempt() bool {
 	return proxy.Up.Atmpt != nil && proxy.Up.Atmpt.resp != nil
 }
@@ -358,7 +450,7 @@ func (proxy *Proxy) parseIncoming(request *http.Request) *Proxy {
 	//set request new request context for timeout
 	ctx, cancel := context.WithCancel(context.TODO())
 	proxy.Dwn.Timeout = ctx.Done()
-	time.AfterFunc(Runner.getDownstreamRoundTripTimeoutDuration(), func() {
+	proxy.Dwn.timer = time.AfterFunc(Runner.getDownstreamRoundTripTimeoutDuration(), func() {
 		cancel()
 	})
 
@@ -374,6 +466,7 @@ func (proxy *Proxy) parseIncoming(request *http.Request) *Proxy {
 	proxy.Dwn.AcceptEncoding = parseAcceptEncoding(request)
 	proxy.Dwn.HttpVer = parseHTTPVer(request)
 	proxy.Dwn.TlsVer = parseTlsVersion(request)
+	proxy.Dwn.TlsResumed = request.TLS != nil && request.TLS.DidResume
 	proxy.Dwn.UserAgent = parseUserAgent(request)
 	proxy.Dwn.Method = parseMethod(request)
 	proxy.Dwn.Listener = parseListener(request)
@@ -387,8 +480,6 @@ func (proxy *Proxy) parseIncoming(request *http.Request) *Proxy {
 		Str(XRequestID, proxy.XRequestID).
 		Msg(headerParsed)
 
-	proxy.parseRequestBody(request)
-
 	return proxy
 }
 
@@ -399,7 +490,7 @@ const STAR = "*"
 func parseAcceptEncoding(request *http.Request) AcceptEncoding {
 	//case insensitive
 	var ae AcceptEncoding
-	raw := request.Header.Get(AcceptEncodingS)
+	raw := strings.Join(request.Header.Values(AcceptEncodingS), COMMA)
 
 	//do not assume this header is set.
 	encs := strings.Split(raw, COMMA)
@@ -434,6 +525,14 @@ const dwnBodyReadAbort = "downstream request body read aborted, cause: %v"
 const dwnBodyRead = "downstream request body read (%d/%d) bytes/content-length"
 const timeout = "timeout"
 
+// maxBodyBytes of the route, or the connection default.
+func (proxy *Proxy) maxBodyBytes() int64 {
+	if proxy.Route != nil && proxy.Route.MaxBodyBytes > 0 {
+		return proxy.Route.MaxBodyBytes
+	}
+	return Runner.Connection.Downstream.MaxBodyBytes
+}
+
 func (proxy *Proxy) parseRequestBody(request *http.Request) {
 	//content length 0, do not read just go back
 	if request.ContentLength == 0 {
@@ -445,12 +544,12 @@ func (proxy *Proxy) parseRequestBody(request *http.Request) {
 	}
 
 	//only try to parse the request if supplied content-length is within limits
-	if request.ContentLength >= Runner.Connection.Downstream.MaxBodyBytes {
+	if request.ContentLength >= proxy.maxBodyBytes() {
 		proxy.Dwn.ReqTooLarge = true
 		infoOrTraceEv(proxy).
 			Str(XRequestID, proxy.XRequestID).
 			Int64(dwnElpsdMicros, time.Since(proxy.Dwn.startDate).Microseconds()).
-			Msgf(dwnBodyContentLengthExceedsMaxBytes, request.ContentLength, Runner.Connection.Downstream.MaxBodyBytes)
+			Msgf(dwnBodyContentLengthExceedsMaxBytes, request.ContentLength, proxy.maxBodyBytes())
 		return
 	}
 
@@ -458,7 +557,7 @@ func (proxy *Proxy) parseRequestBody(request *http.Request) {
 	//No need to close request.Body of type io.ReadCloser, see: https://golang.org/pkg/net/http/#Request
 	bodyReader := bufio.NewReader(http.MaxBytesReader(proxy.Dwn.Resp.Writer,
 		request.Body,
-		Runner.Connection.Downstream.MaxBodyBytes))
+		proxy.maxBodyBytes()))
 
 	var err error
 	var buf []byte
@@ -467,14 +566,14 @@ func (proxy *Proxy) parseRequestBody(request *http.Request) {
 
 	buf, err = ioutil.ReadAll(bodyReader)
 	n := len(buf)
-	if int64(n) > Runner.Connection.Downstream.MaxBodyBytes {
+	if int64(n) > proxy.maxBodyBytes() {
 		proxy.Dwn.ReqTooLarge = true
 		infoOrTraceEv(proxy).
 			Str(path, proxy.Dwn.Path).
 			Str(method, proxy.Dwn.Method).
 			Str(XRequestID, proxy.XRequestID).
 			Int64(dwnElpsdMicros, time.Since(proxy.Dwn.startDate).Microseconds()).
-			Msgf(dwnBodyTooLarge, n, Runner.Connection.Downstream.MaxBodyBytes)
+			Msgf(dwnBodyTooLarge, n, proxy.maxBodyBytes())
 	} else if err != nil && err != io.EOF {
 		ev := infoOrTraceEv(proxy).
 			Str(path, proxy.Dwn.Path).
@@ -625,7 +724,7 @@ const upAtmptCnt = "upAtmptCnt"
 
 func (proxy *Proxy) nextAttempt() *Proxy {
 	next := Atmpt{
-		URL:            proxy.Up.Atmpt.URL,
+		URL:            proxy.alternateMember(),
 		Label:          proxy.Up.Atmpt.Label,
 		Count:          proxy.Up.Atmpt.Count + 1,
 		StatusCode:     0,
@@ -659,35 +758,82 @@ func (proxy *Proxy) copyUpstreamResponseHeaders() {
 	}
 }
 
-const upstreamEncodeFlate = "upstream response body re-encoded with flate before passing downstream"
-const upstreamEncodeBr = "upstream response body re-encoded with brotli before passing downstream"
-const upstreamEncodeGzip = "upstream response body re-encoded with gzip before passing downstream"
+const upstreamEncode = "upstream response body re-encoded with %s before passing downstream"
 const upstreamCopyNoRecode = "upstream response body copied without re-coding before passing downstream"
 const upstreamResponseNoBody = "upstream response has no body, nothing to copy before passing downstream"
 
 const varyS = "Vary"
 
+func (atmpt Atmpt) contentType() string {
+	if atmpt.resp == nil {
+		return emptyString
+	}
+	return atmpt.resp.Header.Get(contentType)
+}
+
+func (proxy *Proxy) compression() Compression {
+	if Runner == nil {
+		return Compression{}
+	}
+	return Runner.Connection.Downstream.Compression
+}
+
+const etagS = "ETag"
+const weakEtagPrefix = "W/"
+const upstreamTranscode = "upstream response body transcoded from %s to %s before passing downstream"
+const upstreamTranscodeFailed = "upstream response body not transcoded from %s, cause: %v. Copied without re-coding"
+
+// shouldTranscode is true if we can decode the upstream response and the client doesn't accept its encoding.
+func (proxy *Proxy) shouldTranscode() bool {
+	upEnc := proxy.Up.Atmpt.ContentEncoding
+	return proxy.compression().Transcode &&
+		upEnc.isAtomic() &&
+		proxy.compression().decodable(upEnc) &&
+		!proxy.Dwn.AcceptEncoding.isCompatible(upEnc)
+}
+
+func (proxy *Proxy) transcodeUpstreamResponseBody() {
+	atmpt := *proxy.Up.Atmpt
+	dec, err := decode(atmpt.ContentEncoding, *atmpt.respBody, proxy.compression().maxTranscodeBytes())
+	if err != nil {
+		proxy.Dwn.Resp.Body = atmpt.respBody
+		proxy.Dwn.Resp.ContentEncoding = atmpt.ContentEncoding
+		scaffoldUpAttemptLog(proxy).
+			Msgf(upstreamTranscodeFailed, atmpt.ContentEncoding, err)
+		return
+	}
+
+	enc := proxy.compression().negotiate(proxy.Dwn.AcceptEncoding, false, atmpt.contentType(), len(dec))
+	proxy.Dwn.Resp.Body = proxy.compression().encode(enc, dec)
+	proxy.Dwn.Resp.ContentEncoding = enc
+
+	//the bytes changed, so a strong validator from upstream no longer applies.
+	if etag := proxy.Dwn.Resp.Writer.Header().Get(etagS); len(etag) > 0 && !strings.HasPrefix(etag, weakEtagPrefix) {
+		proxy.Dwn.Resp.Writer.Header().Set(etagS, weakEtagPrefix+etag)
+	}
+	scaffoldUpAttemptLog(proxy).
+		Msgf(upstreamTranscode, atmpt.ContentEncoding, enc)
+}
+
 func (proxy *Proxy) encodeUpstreamResponseBody() {
 	atmpt := *proxy.Up.Atmpt
 	if atmpt.respBody != nil && len(*atmpt.respBody) > 0 {
 
-		//we pass through all compressed responses as is, including unsupported deflate and compress codecs.
-		//this includes custom encodings, i.e. multiple compressions in series.
-		if atmpt.ContentEncoding.isEncoded() {
+		//we pass through all compressed responses as is, including the unsupported compress codec.
+		//this includes custom encodings, i.e. multiple compressions in series. Unless asked to transcode.
+		if atmpt.ContentEncoding.isEncoded() && proxy.shouldTranscode() {
+			proxy.transcodeUpstreamResponseBody()
+		} else if atmpt.ContentEncoding.isEncoded() {
 			proxy.Dwn.Resp.Body = atmpt.respBody
 			proxy.Dwn.Resp.ContentEncoding = atmpt.ContentEncoding
 			scaffoldUpAttemptLog(proxy).
 				Msgf(upstreamCopyNoRecode)
-		} else if proxy.Dwn.AcceptEncoding.isCompatible(EncGzip) {
-			proxy.Dwn.Resp.Body = Gzip(*atmpt.respBody)
-			proxy.Dwn.Resp.ContentEncoding = EncGzip
-			scaffoldUpAttemptLog(proxy).
-				Msg(upstreamEncodeGzip)
-		} else if proxy.Dwn.AcceptEncoding.isCompatible(EncBrotli) {
-			proxy.Dwn.Resp.Body = BrotliEncode(*atmpt.respBody)
-			proxy.Dwn.Resp.ContentEncoding = EncBrotli
+		} else if enc := proxy.compression().negotiate(proxy.Dwn.AcceptEncoding, false, atmpt.contentType(),
+			len(*atmpt.respBody)); enc.isCompressed() {
+			proxy.Dwn.Resp.Body = proxy.compression().encode(enc, *atmpt.respBody)
+			proxy.Dwn.Resp.ContentEncoding = enc
 			scaffoldUpAttemptLog(proxy).
-				Msg(upstreamEncodeBr)
+				Msgf(upstreamEncode, enc)
 		} else {
 			proxy.Dwn.Resp.Body = atmpt.respBody
 			if len(atmpt.ContentEncoding) > 0 {
@@ -835,6 +981,10 @@ func (proxy *Proxy) validateJwt() bool {
 		}
 
 		ok = parsed != nil && err == nil
+		if ok {
+			//only validated claims are used for routing
+			proxy.Dwn.JwtClaims, _ = parsed.AsMap(context.Background())
+		}
 	} else {
 		err = errors.New("jwt bearer token not present")
 	}
@@ -253,6 +253,109 @@ func TestContentEncodingisBrotli(t *testing.T) {
 	}
 }
 
+func TestContentEncodingIsDeflate(t *testing.T) {
+	for _, df := range []string{"deflate", "x-deflate", "Deflate "} {
+		if !NewContentEncoding(df).isDeflate() {
+			t.Errorf("%v should be deflate", df)
+		}
+	}
+
+	if NewContentEncoding("gzip").isDeflate() {
+		t.Error("should not be deflate")
+	}
+}
+
+func TestContentEncodingIsZstd(t *testing.T) {
+	zs := NewContentEncoding("zstd")
+	if !zs.isZstd() {
+		t.Error("should be zstd")
+	}
+
+	zs2 := NewContentEncoding("br")
+	if zs2.isZstd() {
+		t.Error("should not be zstd")
+	}
+}
+
+func TestContentEncodingQ(t *testing.T) {
+	tests := map[string]float64{
+		"gzip":          1,
+		"gzip;q=0.5":    0.5,
+		"gzip ; q=0":    0,
+		"gzip;q=1.000":  1,
+		"gzip;level=1":  1,
+		"gzip;q=2":      0,
+		"gzip;q=abc":    0,
+		"gzip;q":        0,
+		"br;x=y;q=0.25": 0.25,
+	}
+	for raw, want := range tests {
+		if got := NewContentEncoding(raw).q(); got != want {
+			t.Errorf("q-value for %s want %v, got %v", raw, want, got)
+		}
+	}
+	if got := ContentEncoding("gzip;Q=0").q(); got != 0 {
+		t.Errorf("q parameter name should be case-insensitive, got q-value %v", got)
+	}
+}
+
+func TestContentEncodingCoding(t *testing.T) {
+	if got := NewContentEncoding("GZip ; q=0.5").coding(); got != EncGzip {
+		t.Errorf("coding want gzip, got %v", got)
+	}
+	if got := NewContentEncoding("br").coding(); got != EncBrotli {
+		t.Errorf("coding want br, got %v", got)
+	}
+}
+
+func TestAcceptEncodingQOf(t *testing.T) {
+	ae := mockAcceptEncoding("gzip;q=0.5, *;q=0.1, br;q=0")
+	if q := ae.qOf(EncGzip); q != 0.5 {
+		t.Errorf("gzip q-value want 0.5, got %v", q)
+	}
+	if q := ae.qOf(EncXGzip); q != 0.5 {
+		t.Errorf("x-gzip q-value want 0.5, got %v", q)
+	}
+	if q := ae.qOf(EncZstd); q != 0.1 {
+		t.Errorf("zstd q-value from * want 0.1, got %v", q)
+	}
+	if ae.isCompatible(EncBrotli) {
+		t.Errorf("br with q=0 should not be compatible despite *")
+	}
+}
+
+func TestAcceptEncodingNegotiate(t *testing.T) {
+	preference := AcceptEncoding{EncGzip, EncBrotli}
+	if got := mockAcceptEncoding("br;q=0.9, gzip;q=0.9").negotiate(preference); got != EncGzip {
+		t.Errorf("equal q-value should negotiate server preference gzip, got %v", got)
+	}
+	if got := mockAcceptEncoding("br;q=0.9, gzip;q=0.8").negotiate(preference); got != EncBrotli {
+		t.Errorf("higher q-value should negotiate br, got %v", got)
+	}
+	if got := mockAcceptEncoding("gzip;q=0, br;q=0").negotiate(preference); len(got) > 0 {
+		t.Errorf("refused encodings should not negotiate, got %v", got)
+	}
+}
+
+func TestAcceptEncodingHasAtLeastOneValidEncodingRefusedFails(t *testing.T) {
+	if mockAcceptEncoding("gzip;q=0, identity;q=0").hasAtLeastOneValidEncoding() {
+		t.Error("refused encodings are not valid")
+	}
+	if !mockAcceptEncoding("gzip;q=0.1").hasAtLeastOneValidEncoding() {
+		t.Error("gzip with q-value is valid")
+	}
+}
+
+func TestParseAcceptEncodingMultipleHeaders(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Add(AcceptEncodingS, "gzip;q=0")
+	req.Header.Add(AcceptEncodingS, "br")
+	ae := parseAcceptEncoding(req)
+	if len(ae) != 2 || ae.isCompatible(EncGzip) || !ae.isCompatible(EncBrotli) {
+		t.Errorf("multiple Accept-Encoding headers should be combined, got %v", ae)
+	}
+}
+
 func TestSupportedContentEncoding(t *testing.T) {
 	supported := []ContentEncoding{
 		NewContentEncoding("gzip"),
@@ -261,6 +364,9 @@ func TestSupportedContentEncoding(t *testing.T) {
 		NewContentEncoding("br"),
 		NewContentEncoding("br\n"),
 		NewContentEncoding("\nbr\n"),
+		NewContentEncoding("deflate"),
+		NewContentEncoding("x-deflate"),
+		NewContentEncoding("zstd"),
 	}
 	for _, ce := range supported {
 		if !ce.isSupported() {
@@ -274,8 +380,8 @@ func TestUnSupportedContentEncoding(t *testing.T) {
 		NewContentEncoding("xgzip"),
 		NewContentEncoding("ddgzip "),
 		NewContentEncoding("ddsfa"),
-		NewContentEncoding("deflate"),
-		NewContentEncoding("x-deflate"),
+		NewContentEncoding("compress"),
+		NewContentEncoding("x-compress"),
 		NewContentEncoding("br--"),
 		NewContentEncoding("br\n!"),
 		NewContentEncoding("\nbr\nsdfsd"),
@@ -760,6 +866,138 @@ func TestUpstreamNobody(t *testing.T) {
 	}
 }
 
+func TestEncodeUpstreamResponseBodyZstdAndDeflate(t *testing.T) {
+	Runner = mockRuntime()
+	body := []byte(`{"mse6":"Hello from the get endpoint"}`)
+
+	tests := map[string]struct {
+		acceptEncoding AcceptEncoding
+		want           ContentEncoding
+		decode         func([]byte) *[]byte
+	}{
+		"zstd":      {AcceptEncoding{EncZstd}, EncZstd, ignoreDecodeErr(ZstdDecode)},
+		"deflate":   {AcceptEncoding{EncDeflate}, EncDeflate, ignoreDecodeErr(Inflate)},
+		"x-deflate": {AcceptEncoding{EncXDeflate}, EncDeflate, ignoreDecodeErr(Inflate)},
+		"gzipFirst": {AcceptEncoding{EncDeflate, EncZstd, EncGzip}, EncGzip, Gunzip},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy := mockProxy(body, "0", "/path", "/path", "/get", "", "")
+			proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+			proxy.Up.Atmpt.respBody = &body
+			proxy.Dwn.AcceptEncoding = tt.acceptEncoding
+			proxy.encodeUpstreamResponseBody()
+
+			if proxy.Dwn.Resp.ContentEncoding != tt.want {
+				t.Errorf("downstream content encoding want %v, got %v", tt.want, proxy.Dwn.Resp.ContentEncoding)
+			}
+			if got := proxy.Dwn.Resp.Writer.Header().Get(contentEncoding); got != tt.want.print() {
+				t.Errorf("downstream Content-Encoding header want %v, got %v", tt.want, got)
+			}
+			if got := proxy.Dwn.Resp.Writer.Header().Get(varyS); len(got) > 0 {
+				t.Errorf("downstream should not send Vary for negotiated encoding, got %v", got)
+			}
+			if c := bytes.Compare(body, *tt.decode(*proxy.Dwn.Resp.Body)); c != 0 {
+				t.Errorf("downstream body did not decode to upstream body")
+			}
+		})
+	}
+}
+
+func TestEncodeUpstreamResponseBodyTranscode(t *testing.T) {
+	body := []byte(`{"mse6":"Hello from the brotli endpoint"}`)
+
+	tests := map[string]struct {
+		transcode      bool
+		upEncoding     ContentEncoding
+		upBody         []byte
+		acceptEncoding string
+		want           ContentEncoding
+		wantVary       bool
+		decode         func([]byte) *[]byte
+	}{
+		"brotliToGzip":          {true, EncBrotli, *BrotliEncode(body), "gzip", EncGzip, false, Gunzip},
+		"gzipToIdentity":        {true, EncGzip, *Gzip(body), "", EncIdentity, false, nil},
+		"zstdToDeflate":         {true, EncZstd, *ZstdEncode(body), "deflate", EncDeflate, false, ignoreDecodeErr(Inflate)},
+		"compatibleBrotli":      {true, EncBrotli, *BrotliEncode(body), "gzip, br", EncBrotli, false, BrotliDecode},
+		"disabledPassesVary":    {false, EncBrotli, *BrotliEncode(body), "gzip", EncBrotli, true, BrotliDecode},
+		"corruptPassesVary":     {true, EncGzip, []byte("notgzip"), "br", EncGzip, true, nil},
+		"customPassesVary":      {true, NewContentEncoding("gzip, br"), body, "identity", NewContentEncoding("gzip, br"), true, nil},
+		"unsupportedPassesVary": {true, EncCompress, body, "gzip", EncCompress, true, nil},
+		"tooLargePassesVary":    {true, EncGzip, *Gzip(bytes.Repeat(body, 100)), "br", EncGzip, true, nil},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			Runner.Connection.Downstream.Compression.Transcode = tt.transcode
+			Runner.Connection.Downstream.Compression.MaxTranscodeBytes = 1024
+
+			proxy := mockProxy(tt.upBody, "0", "/path", "/path", "/get", "", "")
+			proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+			proxy.Up.Atmpt.respBody = &tt.upBody
+			proxy.Up.Atmpt.ContentEncoding = tt.upEncoding
+			proxy.Dwn.AcceptEncoding = mockAcceptEncoding(tt.acceptEncoding)
+			proxy.encodeUpstreamResponseBody()
+
+			if proxy.Dwn.Resp.ContentEncoding != tt.want {
+				t.Errorf("downstream content encoding want %v, got %v", tt.want, proxy.Dwn.Resp.ContentEncoding)
+			}
+			if gotVary := len(proxy.Dwn.Resp.Writer.Header().Get(varyS)) > 0; gotVary != tt.wantVary {
+				t.Errorf("downstream Vary header want %v, got %v", tt.wantVary, gotVary)
+			}
+			if tt.decode != nil && !bytes.Equal(body, *tt.decode(*proxy.Dwn.Resp.Body)) {
+				t.Errorf("downstream body did not decode to upstream body")
+			}
+			if tt.want == EncIdentity && !bytes.Equal(body, *proxy.Dwn.Resp.Body) {
+				t.Errorf("downstream identity body want %s, got %s", body, *proxy.Dwn.Resp.Body)
+			}
+		})
+	}
+}
+
+func TestTranscodeUpstreamResponseBodyWeakensEtag(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Connection.Downstream.Compression.Transcode = true
+	body := *BrotliEncode([]byte(`{"mse6":"Hello from the brotli endpoint"}`))
+
+	proxy := mockProxy(body, "0", "/path", "/path", "/get", "", "")
+	proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+	proxy.Up.Atmpt.respBody = &body
+	proxy.Up.Atmpt.ContentEncoding = EncBrotli
+	proxy.Dwn.AcceptEncoding = AcceptEncoding{EncGzip}
+	proxy.Dwn.Resp.Writer.Header().Set(etagS, `"abc"`)
+	proxy.encodeUpstreamResponseBody()
+
+	if got := proxy.Dwn.Resp.Writer.Header().Get(etagS); got != `W/"abc"` {
+		t.Errorf("transcoded response should have weak ETag, got %v", got)
+	}
+}
+
+func TestTranscodeUpstreamResponseBodyLargerThanRouteMaxBodyBytes(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Connection.Downstream.Compression.Transcode = true
+	Runner.Connection.Downstream.MaxBodyBytes = 1024
+	body := bytes.Repeat([]byte(`{"mse6":"Hello from the brotli endpoint"}`), 100)
+	upBody := *BrotliEncode(body)
+
+	proxy := mockProxy(upBody, "0", "/path", "/path", "/get", "", "")
+	proxy.Route = &Route{Path: "/path", MaxBodyBytes: 16}
+	proxy.Up.Atmpt = &proxy.Up.Atmpts[0]
+	proxy.Up.Atmpt.respBody = &upBody
+	proxy.Up.Atmpt.ContentEncoding = EncBrotli
+	proxy.Dwn.AcceptEncoding = AcceptEncoding{EncGzip}
+	proxy.encodeUpstreamResponseBody()
+
+	if proxy.Dwn.Resp.ContentEncoding != EncGzip {
+		t.Errorf("response larger than route maxBodyBytes should transcode to gzip, got %v", proxy.Dwn.Resp.ContentEncoding)
+	}
+	if !bytes.Equal(body, *Gunzip(*proxy.Dwn.Resp.Body)) {
+		t.Errorf("downstream body did not decode to upstream body")
+	}
+}
+
 func TestValidateJwtNoClaims(t *testing.T) {
 	Runner = mockJwtRuntime("jwt",
 		"RS256",
@@ -42,6 +42,7 @@ const jwtBearerTokenMissing = "jwt bearer token missing, invalid, expired or una
 const unableToMapUpstreamResource = "unable to map upstream resource"
 const upstreamResourceNotFound = "upstream resource not found"
 const httpRequestEntityTooLarge = "http request entity too large, limit is %d bytes"
+const httpMethodNotAllowed = "http method %s not allowed for route"
 const downstreamRequestAbortedBeforeFirstUpstream = "downstream request aborted or timed out before first upstream attempt"
 
 var httpRequestInvalidAcceptEncoding string
@@ -61,9 +62,7 @@ func proxyHandler(response http.ResponseWriter, request *http.Request, exec prox
 
 	//all malformed requests are rejected here and we return a 400
 	if !validate(proxy) {
-		if proxy.Dwn.ReqTooLarge {
-			sendStatusCodeAsJSON(proxy.respondWith(413, fmt.Sprintf(httpRequestEntityTooLarge, Runner.Connection.Downstream.MaxBodyBytes)))
-		} else if !proxy.Dwn.AcceptEncoding.hasAtLeastOneValidEncoding() {
+		if !proxy.Dwn.AcceptEncoding.hasAtLeastOneValidEncoding() {
 			sendStatusCodeAsJSON(proxy.respondWith(406, formatInvalidAcceptEncoding()))
 		} else {
 			sendStatusCodeAsJSON(proxy.respondWith(400, badOrMalFormedRequest))
@@ -71,6 +70,15 @@ func proxyHandler(response http.ResponseWriter, request *http.Request, exec prox
 		return
 	}
 
+	//routes are matched before reading the body, so their body size limits apply without buffering it.
+	matched := matchRoutes(request, proxy)
+	if matched {
+		proxy.applyRouteTimeouts()
+		if !proxy.parseRouteRequest(request) {
+			return
+		}
+	}
+
 	//if we timed out or aborted during downstream request parsing we stop the handler before the first upstream attempt.
 	if proxy.hasDownstreamAbortedOrTimedout() {
 		infoOrTraceEv(proxy).Str(path, proxy.Dwn.Path).
@@ -82,36 +90,82 @@ func proxyHandler(response http.ResponseWriter, request *http.Request, exec prox
 		return
 	}
 
-	if matchRoutes(request, proxy) {
+	if matched {
 		if proxy.Route.hasJwt() && !proxy.validateJwt() {
 			sendStatusCodeAsJSON(proxy.respondWith(401, jwtBearerTokenMissing))
+			return
+		}
+		if proxy.Route.Redirect != nil {
+			proxy.redirect()
+			return
+		}
+		if proxy.Route.Respond != nil {
+			proxy.respond()
+			return
+		}
+		if proxy.Route.hasFileResource() {
+			proxy.serveFile()
+			return
+		}
+		if proxy.Route.Resource == purge {
+			purgeHandler(proxy)
+			return
+		}
+		if proxy.Route.Decompress != nil && !proxy.decompressRequestBody() {
+			return
+		}
+		if proxy.Route.Cache != nil && proxy.serveFromCache() {
+			return
+		}
+		if proxy.isCoalescable() {
+			if proxy.coalesce() {
+				return
+			}
+			defer proxy.landFlight()
 		}
 		url, label, mapped := proxy.Route.mapURL(proxy)
 		if mapped {
+			if proxy.Route.Mirror != nil {
+				proxy.mirror()
+			}
 			//mapped requests are sent to proxyfuncs.
 			exec(proxy.firstAttempt(url, label))
 		} else {
 			//unmapped request means an internal configuration error in server
 			sendStatusCodeAsJSON(proxy.respondWith(503, unableToMapUpstreamResource))
 		}
+	} else if allowed := Runner.allowedMethods(request); len(allowed) > 0 {
+		//routes matched all but the method
+		proxy.Dwn.Resp.Writer.Header().Set(allow, strings.Join(allowed, ", "))
+		sendStatusCodeAsJSON(proxy.respondWith(405, fmt.Sprintf(httpMethodNotAllowed, proxy.Dwn.Method)))
 	} else {
 		sendStatusCodeAsJSON(proxy.respondWith(404, upstreamResourceNotFound))
 	}
 }
 
-// TODO this needs a new order. Exact matches first, then prefix matches. inside all these, longer matches first, then shorter ones.
-// try a custom sorter for routes.
+// matchRoutes sets the first route matching request. Routes are sorted at config load, exact before prefix paths and
+// longer before shorter ones.
 func matchRoutes(request *http.Request, proxy *Proxy) bool {
 	matched := false
-	for _, route := range Runner.Routes {
+	Runner.eachRoute(request, func(route Route) bool {
 		if matched = route.match(request); matched {
 			proxy.setRoute(&route)
-			break
 		}
-	}
+		return matched
+	})
 	return matched
 }
 
+// parseRouteRequest reads the body within the route limit. It responds and returns false if the body is too large.
+func (proxy *Proxy) parseRouteRequest(request *http.Request) bool {
+	proxy.parseRequestBody(request)
+	if proxy.Dwn.ReqTooLarge {
+		sendStatusCodeAsJSON(proxy.respondWith(413, fmt.Sprintf(httpRequestEntityTooLarge, proxy.maxBodyBytes())))
+		return false
+	}
+	return true
+}
+
 func validate(proxy *Proxy) bool {
 	return proxy.hasLegalHTTPMethod() &&
 		!proxy.Dwn.ReqTooLarge &&
@@ -130,7 +184,8 @@ func handleHTTP(proxy *Proxy) {
 	}
 
 	if !processUpstreamResponse(proxy, upstreamResponse, upstreamError) {
-		if proxy.shouldRetryUpstreamAttempt() {
+		proxy.markMemberUnhealthy(upstreamResponse, upstreamError)
+		if proxy.shouldRetryUpstreamAttempt() && proxy.backoff() {
 			handleHTTP(proxy.nextAttempt())
 		} else {
 			//sends 504 for downstream timeout, 504 for upstream timeout, 499 for downstream remote hangup,
@@ -139,6 +194,8 @@ func handleHTTP(proxy *Proxy) {
 				sendStatusCodeAsJSON(proxy.respondWith(504, gatewayTimeoutTriggeredByDownstreamEvent))
 			} else if proxy.Dwn.AbortedFlag == true {
 				sendStatusCodeAsJSON(proxy.respondWith(499, connectionClosedByRemoteUserAgent))
+			} else if proxy.serveStaleIfError() {
+				//stale cache entry was served
 			} else if proxy.hasUpstreamAttemptAborted() {
 				sendStatusCodeAsJSON(proxy.respondWith(504, gatewayTimeoutTriggeredByUpstreamEvent))
 			} else {
@@ -153,13 +210,13 @@ const keepAlive = "Keep-Alive"
 
 func scaffoldUpstreamRequest(proxy *Proxy) *http.Request {
 	//this context is used to time out the upstream request
-	ctx, cancel := context.WithCancel(context.TODO())
+	ctx, cancel := context.WithCancel(proxy.upstreamContext(context.TODO()))
 
 	//remember the cancelFunc, we may need to call it before it times out from the outside
 	proxy.Up.Atmpt.CancelFunc = cancel
 
 	//will call the cancel func in it's own goroutine after timeout seconds.
-	time.AfterFunc(time.Duration(Runner.Connection.Upstream.ReadTimeoutSeconds)*time.Second, func() {
+	time.AfterFunc(proxy.upstreamReadTimeout(), func() {
 		cancel()
 	})
 
@@ -177,7 +234,13 @@ func scaffoldUpstreamRequest(proxy *Proxy) *http.Request {
 		Msg(upstreamURIResolved)
 
 	proxy.Up.Atmpt.Aborted = upstreamRequest.Context().Done()
+	proxy.setUpstreamRequestHeaders(upstreamRequest)
+
+	return upstreamRequest
+}
 
+// setUpstreamRequestHeaders copies downstream request headers and adds our own.
+func (proxy *Proxy) setUpstreamRequestHeaders(upstreamRequest *http.Request) {
 	//this contains all accept encodings for content negotiation but is guaranteed to have one valid value.
 	upstreamRequest.Header.Add(acceptEncoding, proxy.Dwn.AcceptEncoding.Print())
 
@@ -190,11 +253,21 @@ func scaffoldUpstreamRequest(proxy *Proxy) *http.Request {
 		}
 	}
 
+	//body was decompressed for the route, tell upstream what it's getting instead.
+	if len(proxy.Dwn.BodyEncoding) > 0 {
+		if proxy.Dwn.BodyEncoding.isEncoded() {
+			upstreamRequest.Header.Set(contentEncoding, proxy.Dwn.BodyEncoding.print())
+		} else {
+			upstreamRequest.Header.Del(contentEncoding)
+		}
+	}
+
+	//stale cache entries are revalidated with our own validators
+	proxy.setConditionalHeaders(upstreamRequest.Header)
+
 	//this is redundant for HTTP/1.1, spec ref: https://datatracker.ietf.org/doc/html/rfc2616#section-8.1.3
 	//upstreamRequest.Header.Set(connectionS, keepAlive)
 	upstreamRequest.Header.Set(XRequestID, proxy.XRequestID)
-
-	return upstreamRequest
 }
 
 const upResHeaders = "upResHeaders"
@@ -208,50 +281,52 @@ const downstreamRtFired = "downstream roundtrip timeout fired"
 const downstreamReqAborted = "downstream request aborted"
 
 func performUpstreamRequest(proxy *Proxy) (*http.Response, error) {
-	//get a reference to this before any race conditions may occur
-	attemptIndex := proxy.Up.Count - 1
+	if proxy.isHedgeable() {
+		return performHedgedUpstreamRequest(proxy)
+	}
+
+	//the goroutine only ever touches this attempt, proxy.Up.Atmpt moves on with retries.
+	atmpt := proxy.Up.Atmpt
 	req := scaffoldUpstreamRequest(proxy)
-	var upstreamResponse *http.Response
-	var upstreamError error
+	result := make(chan upstreamResult, 1)
 
 	go func() {
 		defer func() {
 			//this should never happen in production, http client doesn't panic
 			//if it does, abort only one request as opposed to shutting down the server.
 			if err := recover(); err != nil {
-				upstreamError = errors.New(upstreamReqAborted)
-				defer func() {
-					if err := recover(); err != nil {
-						//ignore
-					}
-				}()
-				proxy.Up.Atmpts[attemptIndex].CancelFunc()
+				if atmpt.CancelFunc != nil {
+					atmpt.CancelFunc()
+				}
+				result <- upstreamResult{err: errors.New(upstreamReqAborted)}
 			}
 		}()
 
 		//this blocks until upstream headers come in
-		upstreamResponse, upstreamError = httpClient.Do(req)
-		proxy.Up.Atmpt.resp = upstreamResponse
-
-		if proxy.Up.Atmpts[attemptIndex].CompleteHeader != nil &&
-			!proxy.Up.Atmpts[attemptIndex].AbortedFlag &&
-			!proxy.Dwn.AbortedFlag {
-			close(proxy.Up.Atmpts[attemptIndex].CompleteHeader)
-		} else {
-			panic(upstreamReqAborted)
-		}
+		resp, err := httpClient.Do(req)
+		result <- upstreamResult{resp, err}
 	}()
 
+	var upstreamResponse *http.Response
+	var upstreamError error
+
 	//race for upstream headers complete, upstream timeout or downstream abort (timeout or cancellation)
 	select {
 
-	case <-proxy.Up.Atmpt.Aborted:
-		proxy.Up.Atmpt.AbortedFlag = true
-		proxy.Up.Atmpt.StatusCode = 0
+	case <-atmpt.Aborted:
+		atmpt.AbortedFlag = true
+		atmpt.StatusCode = 0
+		select {
+		case r := <-result:
+			upstreamResponse, upstreamError = r.resp, r.err
+			atmpt.resp = upstreamResponse
+		default:
+			go discardUpstreamResult(result)
+		}
 		//aborts due to timeout don't set upstream error
 		if upstreamError == nil {
 			scaffoldUpAttemptLog(proxy).
-				Int(upReadTimeoutSecs, Runner.Connection.Upstream.ReadTimeoutSeconds).
+				Float64(upReadTimeoutSecs, proxy.upstreamReadTimeout().Seconds()).
 				Msg(upConReadTimeoutFired)
 		} else {
 			scaffoldUpAttemptLog(proxy).
@@ -263,6 +338,7 @@ func performUpstreamRequest(proxy *Proxy) (*http.Response, error) {
 			Msg(downstreamRtFired)
 
 		proxy.abortAllUpstreamAttempts()
+		go discardUpstreamResult(result)
 		scaffoldUpAttemptLog(proxy).
 			Msg(upstreamResHeaderAborted)
 	case <-proxy.Dwn.Aborted:
@@ -271,9 +347,12 @@ func performUpstreamRequest(proxy *Proxy) (*http.Response, error) {
 			Msg(downstreamReqAborted)
 
 		proxy.abortAllUpstreamAttempts()
+		go discardUpstreamResult(result)
 		scaffoldUpAttemptLog(proxy).
 			Msg(upstreamResHeaderAborted)
-	case <-proxy.Up.Atmpt.CompleteHeader:
+	case r := <-result:
+		upstreamResponse, upstreamError = r.resp, r.err
+		atmpt.resp = upstreamResponse
 		scaffoldUpAttemptLog(proxy).
 			RawJSON(upResHeaders, jsonifyUpstreamHeaders(proxy)).
 			Msg(upstreamResHeadersProcessed)
@@ -282,6 +361,19 @@ func performUpstreamRequest(proxy *Proxy) (*http.Response, error) {
 	return upstreamResponse, upstreamError
 }
 
+// upstreamResult is what the http client returned for an upstream request.
+type upstreamResult struct {
+	resp *http.Response
+	err  error
+}
+
+// discardUpstreamResult closes the response of an abandoned upstream request once it arrives.
+func discardUpstreamResult(result <-chan upstreamResult) {
+	if r := <-result; r.resp != nil && r.resp.Body != nil {
+		r.resp.Body.Close()
+	}
+}
+
 const upReadTimeoutSecs = "upReadTimeoutSecs"
 const safeToIgnoreFailedBodyChannelClosure = "safe to ignore. recovered internally from closed body success channel after request already handled."
 const upstreamConReadTimeoutFired = "upstream connection read timeout fired, aborting upstream response body processing"
@@ -358,7 +450,7 @@ func parseUpstreamResponse(upstreamResponse *http.Response, proxy *Proxy) ([]byt
 		proxy.Up.Atmpt.AbortedFlag = true
 		if bodyError == nil {
 			scaffoldUpAttemptLog(proxy).
-				Int(upReadTimeoutSecs, Runner.Connection.Upstream.ReadTimeoutSeconds).
+				Float64(upReadTimeoutSecs, proxy.upstreamReadTimeout().Seconds()).
 				Msg(upstreamConReadTimeoutFired)
 		} else {
 			scaffoldUpAttemptLog(proxy).
@@ -426,19 +518,8 @@ func processUpstreamResponse(proxy *Proxy, upstreamResponse *http.Response, upst
 		proxy.Up.Atmpt.respBody = &upstreamResponseBody
 		if shouldProxyUpstreamResponse(proxy, bodyError) {
 			logSuccessfulUpstreamAttempt(proxy, upstreamResponse)
-			if isUpstreamClientError(proxy) {
-				proxy.copyUpstreamStatusCodeHeader()
-				sendStatusCodeAsJSON(proxy)
-			} else {
-				proxy.writeStandardResponseHeaders()
-				proxy.copyUpstreamResponseHeaders()
-				proxy.copyUpstreamStatusCodeHeader()
-				proxy.encodeUpstreamResponseBody()
-				proxy.setContentLengthHeader()
-				proxy.sendDownstreamStatusCodeHeader()
-				proxy.pipeDownstreamResponse()
-				logHandledDownstreamRoundtrip(proxy)
-			}
+			proxy.shareFlight()
+			proxy.sendUpstreamResponse()
 			return true
 		}
 	}
@@ -447,6 +528,26 @@ func processUpstreamResponse(proxy *Proxy, upstreamResponse *http.Response, upst
 	return false
 }
 
+// sendUpstreamResponse sends the current upstream attempt downstream.
+func (proxy *Proxy) sendUpstreamResponse() {
+	if proxy.revalidatedFromCache() {
+		//stale cache entry was served
+	} else if isUpstreamClientError(proxy) {
+		proxy.copyUpstreamStatusCodeHeader()
+		sendStatusCodeAsJSON(proxy)
+	} else {
+		proxy.writeStandardResponseHeaders()
+		proxy.copyUpstreamResponseHeaders()
+		proxy.copyUpstreamStatusCodeHeader()
+		proxy.encodeUpstreamResponseBody()
+		proxy.setContentLengthHeader()
+		proxy.cacheUpstreamResponse()
+		proxy.sendDownstreamStatusCodeHeader()
+		proxy.pipeDownstreamResponse()
+		logHandledDownstreamRoundtrip(proxy)
+	}
+}
+
 func isUpstreamClientError(proxy *Proxy) bool {
 	return proxy.Up.Atmpt.StatusCode > 399 && proxy.Up.Atmpt.StatusCode < 500
 }
@@ -455,7 +556,7 @@ func shouldProxyUpstreamResponse(proxy *Proxy, bodyError error) bool {
 	return !proxy.hasDownstreamAbortedOrTimedout() &&
 		!proxy.hasUpstreamAttemptAborted() &&
 		bodyError == nil &&
-		proxy.Up.Atmpt.resp.StatusCode < 500
+		(proxy.Up.Atmpt.resp.StatusCode < 500 || proxy.passesUpstreamServerError())
 }
 
 func shouldProxyHeader(header string) bool {
@@ -472,7 +573,7 @@ func scaffoldUpAttemptLog(proxy *Proxy) *zerolog.Event {
 		Str(XRequestID, proxy.XRequestID).
 		Int64(upAtmtpElpsdMicros, time.Since(proxy.Up.Atmpt.startDate).Microseconds()).
 		Int64(dwnElpsdMicros, time.Since(proxy.Dwn.startDate).Microseconds()).
-		Str(upAtmpt, proxy.Up.Atmpt.print())
+		Str(upAtmpt, proxy.Up.Atmpt.print(proxy.maxAttempts()))
 }
 
 const downstreamResponseServed = "downstream HTTP response served"
@@ -492,7 +593,7 @@ func logHandledDownstreamRoundtrip(proxy *Proxy) {
 			Int(upAtmptResBodyBytes, len(*proxy.Up.Atmpt.respBody)).
 			Int64(upAtmptElpsdMicros, time.Since(proxy.Up.Atmpt.startDate).Microseconds()).
 			Bool(upAtmptAbort, proxy.Up.Atmpt.AbortedFlag).
-			Str(upAtmpt, proxy.Up.Atmpt.print())
+			Str(upAtmpt, proxy.Up.Atmpt.print(proxy.maxAttempts()))
 	}
 
 	if proxy.Dwn.Resp.StatusCode > 399 {
@@ -521,7 +622,8 @@ func logHandledDownstreamRoundtrip(proxy *Proxy) {
 	}
 
 	if Runner.isTLSOn() {
-		ev = ev.Str(dwnReqTlsVer, proxy.Dwn.TlsVer)
+		ev = ev.Str(dwnReqTlsVer, proxy.Dwn.TlsVer).
+			Bool(dwnReqTlsResumed, proxy.Dwn.TlsResumed)
 	}
 
 	ev.Msg(msg)
@@ -2,11 +2,14 @@ package j8a
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/rs/zerolog"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -667,6 +670,8 @@ func TestProcessUpstreamRequestWithoutValidUpstream(t *testing.T) {
 	//needed for print function
 	Runner = mockRuntime()
 	Runner.Connection.Upstream.MaxAttempts = 1
+	//don't inherit a mock user agent from other tests, upstreamhost must fail to resolve
+	httpClient = scaffoldHTTPClient(Runner)
 
 	p := mockProxy(make([]byte, 1), "", "/", "", "/", "", "")
 	_, e := performUpstreamRequest(&p)
@@ -784,9 +789,13 @@ func mockRuntime() *Runtime {
 				},
 			},
 		},
-		Start:             time.Now(),
-		AcmeHandler:       NewAcmeHandler(),
-		ConnectionWatcher: ConnectionWatcher{dwnOpenConns: 0},
+		Start:              time.Now(),
+		AcmeHandler:        NewAcmeHandler(),
+		AcmeTlsAlpnHandler: NewAcmeTlsAlpnHandler(),
+		ConnectionWatcher:  ConnectionWatcher{dwnOpenConns: 0},
+		ResponseCache:      NewResponseCache(cacheDefaultMaxBytes),
+		Coalescer:          NewCoalescer(),
+		Balancer:           NewBalancer(),
 	}
 
 	//simple compiled regexes for prefix matching only
@@ -799,3 +808,134 @@ func mockRuntime() *Runtime {
 	//now we can work with you
 	return r
 }
+
+func TestRouteMethodNotAllowedWithProxyHandler(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Routes[0].Methods = []string{"GET", "HEAD"}
+	calls := mockRetryUpstream(200)
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 405 {
+		t.Errorf("want 405 for method not allowed on route, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(allow); got != "GET, HEAD" {
+		t.Errorf("want Allow header GET, HEAD, got %s", got)
+	}
+	if atomic.LoadInt32(calls) != 0 {
+		t.Errorf("want no upstream request for method not allowed")
+	}
+}
+
+// mockRouteMatchUpstream answers with the port of the resource member the request was routed to.
+func mockRouteMatchUpstream() {
+	httpClient = &MockHttp{}
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(req.URL.Port())),
+		}, nil
+	}
+}
+
+func TestRouteMatchersWithProxyHandler(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Connection.Downstream.MaxBodyBytes = 1024
+	Runner.Routes = append(Routes{
+		{Path: "/", Resource: "blahResource", Methods: []string{"POST"}},
+		{Path: "/", Resource: "blahResource", Headers: []RouteMatcher{{Name: "X-Api-Version", Value: "2"}}},
+		{Path: "/", Resource: "blahResource", Query: []RouteMatcher{{Name: "beta"}}},
+	}, Runner.Routes...)
+	for i := 0; i < 3; i++ {
+		Runner.Routes[i].compilePath()
+		Runner.Routes[i].compileMatchers()
+	}
+	mockRouteMatchUpstream()
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	tests := map[string]struct {
+		method string
+		url    string
+		header string
+		want   string
+	}{
+		"get":       {"GET", "/", "", "8083"},
+		"post":      {"POST", "/", "", "8084"},
+		"apiV2":     {"GET", "/", "2", "8084"},
+		"apiV1":     {"GET", "/", "1", "8083"},
+		"queryBeta": {"GET", "/?beta=true", "", "8084"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.url, strings.NewReader(""))
+			if len(tt.header) > 0 {
+				req.Header.Set("X-Api-Version", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != 200 || string(body) != tt.want {
+				t.Errorf("want 200 from member %s, got %d %s", tt.want, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestRouteMaxBodyBytesWithProxyHandler(t *testing.T) {
+	tests := map[string]struct {
+		routeMax int64
+		body     int
+		want     int
+	}{
+		"withinRouteLimitAboveDefault": {4096, 2048, 200},
+		"aboveRouteLimit":              {1024, 2048, 413},
+		"routeLimitUnderDefault":       {16, 32, 413},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			Runner.Connection.Downstream.MaxBodyBytes = 1024
+			Runner.Routes[0].MaxBodyBytes = tt.routeMax
+			mockRetryUpstream(200)
+
+			server := httptest.NewServer(&ProxyHttpHandler{})
+			defer server.Close()
+
+			resp, err := http.Post(server.URL, "application/json", bytes.NewReader(make([]byte, tt.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.want {
+				t.Errorf("want %d, got %d %s", tt.want, resp.StatusCode, body)
+			}
+			if tt.want == 413 && !strings.Contains(string(body), fmt.Sprint(tt.routeMax)) {
+				t.Errorf("want route limit %d in response, got %s", tt.routeMax, body)
+			}
+		})
+	}
+}
+
+func TestUnmatchedRouteDoesNotReadBody(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Routes = Runner.Routes[1:]
+	req := httptest.NewRequest("PUT", "/nothere", bytes.NewReader(make([]byte, 1<<20)))
+	rec := httptest.NewRecorder()
+
+	proxyHandler(rec, req, handleHTTP)
+	if rec.Code != 404 {
+		t.Errorf("want 404 for unmatched route, got %d", rec.Code)
+	}
+	if n, _ := req.Body.Read(make([]byte, 1)); n != 1 {
+		t.Errorf("want request body left unread for unmatched route")
+	}
+}
@@ -0,0 +1,134 @@
+package j8a
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/idna"
+)
+
+const purge string = "purge"
+const surrogateKeyS = "Surrogate-Key"
+
+const cachePurged = "cache purged"
+const purgedEntries = "purged %d cache entries"
+const purgeRequestInvalid = "purge request must be a POST with JSON body containing one of url, prefix, route, surrogateKey"
+const purgeByS = "purgeBy"
+const purgeValueS = "purgeValue"
+const purgedS = "purged"
+
+// PurgeRequest invalidates cached responses. Exactly one field must be set. URL and Prefix are absolute URLs or
+// paths starting with '/' that match any host.
+type PurgeRequest struct {
+	Url          string
+	Prefix       string
+	Route        string
+	SurrogateKey string
+}
+
+// match returns a name for the kind of purge and a matcher for cache entries, or false if the request is invalid.
+func (p PurgeRequest) match() (string, func(*CacheEntry) bool, bool) {
+	set := 0
+	for _, v := range []string{p.Url, p.Prefix, p.Route, p.SurrogateKey} {
+		if len(v) > 0 {
+			set++
+		}
+	}
+	if set != 1 {
+		return emptyString, nil, false
+	}
+
+	switch {
+	case len(p.Url) > 0:
+		host, uri, ok := parsePurgeURL(p.Url)
+		return "url", func(e *CacheEntry) bool {
+			return (len(host) == 0 || e.Host == host) && e.URI == uri
+		}, ok
+	case len(p.Prefix) > 0:
+		host, uri, ok := parsePurgeURL(p.Prefix)
+		return "prefix", func(e *CacheEntry) bool {
+			return (len(host) == 0 || e.Host == host) && strings.HasPrefix(e.URI, uri)
+		}, ok
+	case len(p.Route) > 0:
+		return "route", func(e *CacheEntry) bool {
+			return e.Route == p.Route
+		}, true
+	default:
+		return "surrogateKey", func(e *CacheEntry) bool {
+			for _, k := range e.SurrogateKeys {
+				if k == p.SurrogateKey {
+					return true
+				}
+			}
+			return false
+		}, true
+	}
+}
+
+func (p PurgeRequest) value() string {
+	return p.Url + p.Prefix + p.Route + p.SurrogateKey
+}
+
+// parsePurgeURL returns host and request URI of u in the same form as cache entries. Host is empty for paths.
+func parsePurgeURL(u string) (string, string, bool) {
+	if strings.HasPrefix(u, "/") {
+		return emptyString, u, true
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || len(parsed.Hostname()) == 0 {
+		return emptyString, emptyString, false
+	}
+	host, _ := idna.ToASCII(parsed.Hostname())
+	return host, parsed.RequestURI(), true
+}
+
+// purge removes all entries matching m and returns how many.
+func (c *ResponseCache) purge(m func(*CacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, el := range c.entries {
+		if m(el.Value.(*CacheEntry)) {
+			c.removeElement(el)
+			n++
+		}
+	}
+	return n
+}
+
+// purgeHandler serves routes with resource purge. These routes are always authenticated with jwt.
+func purgeHandler(proxy *Proxy) {
+	if proxy.Dwn.Method != "POST" {
+		proxy.Dwn.Resp.Writer.Header().Set(allow, "POST")
+		sendStatusCodeAsJSON(proxy.respondWith(405, purgeRequestInvalid))
+		return
+	}
+
+	var p PurgeRequest
+	if err := json.Unmarshal(proxy.Dwn.Body, &p); err != nil {
+		sendStatusCodeAsJSON(proxy.respondWith(400, purgeRequestInvalid))
+		return
+	}
+	by, m, ok := p.match()
+	if !ok {
+		sendStatusCodeAsJSON(proxy.respondWith(400, purgeRequestInvalid))
+		return
+	}
+
+	n := 0
+	if Runner.ResponseCache != nil {
+		n = Runner.ResponseCache.purge(m)
+	}
+
+	log.Info().
+		Str(XRequestID, proxy.XRequestID).
+		Str(purgeByS, by).
+		Str(purgeValueS, p.value()).
+		Int(purgedS, n).
+		Msg(cachePurged)
+
+	sendStatusCodeAsJSON(proxy.respondWith(200, fmt.Sprintf(purgedEntries, n)))
+}
@@ -0,0 +1,144 @@
+package j8a
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// mockPurgeCache stores responses for uris on host localhost with the surrogate keys of the upstream response
+func mockPurgeCache(uris map[string]string) {
+	for uri, keys := range uris {
+		proxy := mockCacheProxy("")
+		proxy.Dwn.URI = uri
+		proxy.serveFromCache()
+		mockCacheUpstreamResponse(proxy, 200, mockCacheHeader(cacheControlS, "max-age=60", surrogateKeyS, keys), cacheBody)
+	}
+}
+
+func mockPurgeProxy(method string, body string) *Proxy {
+	proxy := mockProxy(nil, "0", "/purge", "/purge", "/purge", "", "")
+	proxy.Up.Atmpt = nil
+	proxy.XRequestID = "purge-12345"
+	proxy.Dwn.Method = method
+	proxy.Dwn.Body = []byte(body)
+	proxy.Route.Resource = purge
+	return &proxy
+}
+
+func TestPurgeRequestMatch(t *testing.T) {
+	e := &CacheEntry{Host: "localhost", URI: "/api/users?page=1", Route: "/api", SurrogateKeys: []string{"users", "page1"}}
+
+	tests := map[string]struct {
+		req       PurgeRequest
+		wantBy    string
+		wantValid bool
+		wantMatch bool
+	}{
+		"url":             {PurgeRequest{Url: "http://localhost:8080/api/users?page=1"}, "url", true, true},
+		"urlPath":         {PurgeRequest{Url: "/api/users?page=1"}, "url", true, true},
+		"urlOtherHost":    {PurgeRequest{Url: "http://example.com/api/users?page=1"}, "url", true, false},
+		"urlOtherQuery":   {PurgeRequest{Url: "/api/users?page=2"}, "url", true, false},
+		"prefix":          {PurgeRequest{Prefix: "http://localhost/api/"}, "prefix", true, true},
+		"prefixPath":      {PurgeRequest{Prefix: "/api/users"}, "prefix", true, true},
+		"prefixNoMatch":   {PurgeRequest{Prefix: "/api/orders"}, "prefix", true, false},
+		"route":           {PurgeRequest{Route: "/api"}, "route", true, true},
+		"routeNoMatch":    {PurgeRequest{Route: "/"}, "route", true, false},
+		"surrogateKey":    {PurgeRequest{SurrogateKey: "page1"}, "surrogateKey", true, true},
+		"surrogateKeyNot": {PurgeRequest{SurrogateKey: "page"}, "surrogateKey", true, false},
+		"none":            {PurgeRequest{}, "", false, false},
+		"two":             {PurgeRequest{Route: "/api", SurrogateKey: "users"}, "", false, false},
+		"relativeUrl":     {PurgeRequest{Url: "api/users"}, "url", false, false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			by, m, ok := tt.req.match()
+			if ok != tt.wantValid || by != tt.wantBy {
+				t.Fatalf("want valid %v by %s, got %v by %s", tt.wantValid, tt.wantBy, ok, by)
+			}
+			if ok && m(e) != tt.wantMatch {
+				t.Errorf("want match %v, got %v", tt.wantMatch, m(e))
+			}
+		})
+	}
+}
+
+func TestPurgeHandler(t *testing.T) {
+	tests := map[string]struct {
+		body       string
+		wantPurged []string
+	}{
+		"url":          {`{"url":"/a/1"}`, []string{"/a/1"}},
+		"prefix":       {`{"prefix":"http://localhost/a/"}`, []string{"/a/1", "/a/2"}},
+		"route":        {`{"route":"/path"}`, []string{"/a/1", "/a/2", "/b/1"}},
+		"surrogateKey": {`{"surrogateKey":"even"}`, []string{"/a/2"}},
+		"nothing":      {`{"url":"/c/1"}`, nil},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			mockPurgeCache(map[string]string{"/a/1": "a odd", "/a/2": "a even", "/b/1": "b odd"})
+
+			proxy := mockPurgeProxy("POST", tt.body)
+			purgeHandler(proxy)
+
+			rec := proxy.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+			if rec.Code != 200 || !strings.Contains(rec.Body.String(), "purged") {
+				t.Errorf("want 200 purged, got %d %s", rec.Code, rec.Body.String())
+			}
+			if got := Runner.ResponseCache.len(); got != 3-len(tt.wantPurged) {
+				t.Errorf("want %d entries left, got %d", 3-len(tt.wantPurged), got)
+			}
+			for _, uri := range tt.wantPurged {
+				p := mockCacheProxy("")
+				p.Dwn.URI = uri
+				if p.serveFromCache() {
+					t.Errorf("want %s purged", uri)
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeHandlerRejectsInvalidRequests(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		body   string
+		want   int
+	}{
+		"get":         {"GET", `{"url":"/a/1"}`, 405},
+		"invalidJson": {"POST", `{"url":`, 400},
+		"noSelector":  {"POST", `{}`, 400},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			proxy := mockPurgeProxy(tt.method, tt.body)
+			purgeHandler(proxy)
+			rec := proxy.Dwn.Resp.Writer.(*httptest.ResponseRecorder)
+			if rec.Code != tt.want {
+				t.Errorf("want %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want == 405 && rec.Header().Get(allow) != "POST" {
+				t.Errorf("want Allow POST, got %s", rec.Header().Get(allow))
+			}
+		})
+	}
+}
+
+func TestCacheStripsSurrogateKeyDownstream(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockCacheProxy("")
+	proxy.serveFromCache()
+	mockCacheUpstreamResponse(proxy, 200, http.Header{cacheControlS: {"max-age=60"}, surrogateKeyS: {"a b"}}, cacheBody)
+
+	if got := proxy.Dwn.Resp.Writer.Header().Get(surrogateKeyS); len(got) > 0 {
+		t.Errorf("want Surrogate-Key removed from downstream response, got %s", got)
+	}
+	if got := Runner.ResponseCache.get(proxy.cache.key).SurrogateKeys; len(got) != 2 {
+		t.Errorf("want 2 surrogate keys stored, got %v", got)
+	}
+}
@@ -17,6 +17,7 @@ func redirectHandler(response http.ResponseWriter, request *http.Request) {
 	proxy := new(Proxy).
 		setOutgoing(response).
 		parseIncoming(request)
+	proxy.parseRequestBody(request)
 
 	proxy.writeStandardResponseHeaders()
 
@@ -3,7 +3,9 @@ package j8a
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ocsp"
 	"sync"
 )
 
@@ -13,31 +15,164 @@ type ReloadableCert struct {
 	Init bool
 	//required to use runtime internally without global pointer for testing.
 	runtime *Runtime
+	//OCSP responses cached by leaf certificate serial
+	ocspStaples map[string]*OcspStaple
 }
 
 func (r *ReloadableCert) GetCertificateFunc(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	return r.Cert, nil
+	if isAcmeTlsAlpnHello(clientHello) && r.runtime != nil && r.runtime.AcmeTlsAlpnHandler != nil {
+		return r.runtime.AcmeTlsAlpnHandler.getCertificate(clientHello)
+	}
+	return r.certificate(), nil
+}
+
+// certificate returns the certificate currently served. OCSP stapling and reloads swap it concurrently.
+func (r *ReloadableCert) certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Cert
 }
 
 func (r *ReloadableCert) triggerInit() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.Init = true
+	err := r.load([]byte(r.runtime.Connection.Downstream.Tls.Cert), []byte(r.runtime.Connection.Downstream.Tls.Key))
+	r.Init = false
+	return err
+}
 
-	var cert tls.Certificate
-	var err error
+// swap serves cert and key from now on. The runtime's PEM strings and the certificate are published together, so
+// readers never see a pair that didn't parse. The previous pair is kept on error.
+func (r *ReloadableCert) swap(cert []byte, key []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load(cert, key)
+}
 
-	c := []byte(r.runtime.Connection.Downstream.Tls.Cert)
-	k := []byte(r.runtime.Connection.Downstream.Tls.Key)
+// keyPair returns the PEM encoded cert and key currently served.
+func (r *ReloadableCert) keyPair() (string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runtime.Connection.Downstream.Tls.Cert, r.runtime.Connection.Downstream.Tls.Key
+}
 
-	cert, err = tls.X509KeyPair(c, k)
+// load parses c and k and publishes them if valid. Callers hold mu.
+func (r *ReloadableCert) load(c []byte, k []byte) error {
+	cert, err := tls.X509KeyPair(c, k)
 	if err == nil {
-		r.Cert = &cert
-		r.Cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	//re-attach a cached OCSP response if we already have one for this certificate, forget those of replaced ones.
+	serial := formatSerial(cert.Leaf.SerialNumber)
+	for s := range r.ocspStaples {
+		if s != serial {
+			delete(r.ocspStaples, s)
+		}
+	}
+	if staple, ok := r.ocspStaples[serial]; ok && staple.isFresh() {
+		cert.OCSPStaple = staple.Raw
+	}
+	r.runtime.Connection.Downstream.Tls.Cert = string(c)
+	r.runtime.Connection.Downstream.Tls.Key = string(k)
+	r.Cert = &cert
+	log.Info().Msgf("TLS certificate #%v initialized", serial)
+	return nil
+}
+
+const ocspStapled = "OCSP response for TLS certificate #%v stapled, status %s, next update %s"
+const ocspNotStapled = "OCSP response for TLS certificate #%v not stapled, cause: %v"
+const ocspRevoked = "OCSP responder reports TLS certificate #%v revoked at %s, reason code %d. Replace this certificate now"
+const ocspUnknown = "OCSP responder reports TLS certificate #%v status unknown"
+const ocspStapleExpired = "cached OCSP response for TLS certificate #%v expired, no longer stapled"
+
+// stapleOcsp fetches a fresh OCSP response for the current certificate and staples it if the certificate is good.
+// It returns the staple served after this call, which may be an older cached one if the fetch was unsuccessful.
+func (r *ReloadableCert) stapleOcsp() (*OcspStaple, error) {
+	r.mu.Lock()
+	cert := r.Cert
+	r.mu.Unlock()
+
+	if cert == nil || cert.Leaf == nil {
+		return nil, errors.New("no TLS certificate initialized, cannot staple OCSP response")
 	}
+	serial := formatSerial(cert.Leaf.SerialNumber)
+
+	staple, err := fetchOcspStaple(cert)
 	if err == nil {
-		log.Info().Msgf("TLS certificate #%v initialized", formatSerial(cert.Leaf.SerialNumber))
+		switch staple.Status {
+		case ocsp.Good:
+			r.attachOcspStaple(serial, staple)
+			log.Info().Msgf(ocspStapled, serial, staple.printStatus(), staple.NextUpdate.Format("2006-01-02 15:04:05"))
+			return staple, nil
+		case ocsp.Revoked:
+			//never keep serving an older good response once we know better.
+			r.detachOcspStaple(serial)
+			err = errors.New("certificate revoked")
+			log.Warn().Msgf(ocspRevoked, serial, staple.RevokedAt.Format("2006-01-02"), staple.RevocationReason)
+		default:
+			err = errors.New("certificate status unknown")
+			log.Warn().Msgf(ocspUnknown, serial)
+		}
+	} else if err == errOcspNoResponder {
+		log.Info().Msgf(ocspNotStapled, serial, err)
+	} else {
+		log.Warn().Msgf(ocspNotStapled, serial, err)
+	}
+
+	return r.servedOcspStaple(serial), err
+}
+
+func (r *ReloadableCert) attachOcspStaple(serial string, staple *OcspStaple) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	//certificate may have been rotated while we were fetching, only cache and staple if it's still the same one.
+	if r.Cert == nil || r.Cert.Leaf == nil || formatSerial(r.Cert.Leaf.SerialNumber) != serial {
+		return
+	}
+
+	if r.ocspStaples == nil {
+		r.ocspStaples = make(map[string]*OcspStaple)
+	}
+	r.ocspStaples[serial] = staple
+
+	//we swap a copy so in-flight handshakes never see a partially updated certificate.
+	c := *r.Cert
+	c.OCSPStaple = staple.Raw
+	r.Cert = &c
+}
+
+// servedOcspStaple returns the cached staple still attached to the certificate, detaching it once it has expired.
+func (r *ReloadableCert) servedOcspStaple(serial string) *OcspStaple {
+	r.mu.Lock()
+	staple, ok := r.ocspStaples[serial]
+	r.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	if !staple.isFresh() {
+		r.detachOcspStaple(serial)
+		log.Warn().Msgf(ocspStapleExpired, serial)
+		return nil
+	}
+	return staple
+}
+
+func (r *ReloadableCert) detachOcspStaple(serial string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.ocspStaples, serial)
+	if r.Cert != nil && r.Cert.Leaf != nil && r.Cert.OCSPStaple != nil &&
+		formatSerial(r.Cert.Leaf.SerialNumber) == serial {
+		c := *r.Cert
+		c.OCSPStaple = nil
+		r.Cert = &c
 	}
-	r.Init = false
-	return err
 }
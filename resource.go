@@ -5,4 +5,5 @@ type ResourceMapping struct {
 	Name   string
 	Labels []string
 	URL    URL
+	Index  []string // index files of directories for file resources, defaults to index.html
 }
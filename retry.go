@@ -0,0 +1,198 @@
+package j8a
+
+import (
+	"math/rand"
+	"time"
+)
+
+const retryConnect = "connect"
+const retryTimeout = "timeout"
+const retryDefaultMaxAttempts = 3
+
+var retryConditions = []string{retryConnect, retryTimeout}
+var retryDefaultStatusCodes = []int{502, 503, 504}
+
+const idempotencyKeyS = "Idempotency-Key"
+const upstreamRetryBackoff = "upstream retry backing off for %v"
+const upstreamRetryAlternateMember = "upstream retry on alternate resource member"
+
+// Retry is the upstream retry policy of a route. It replaces connection.upstream.maxAttempts for the route.
+type Retry struct {
+	// MaxAttempts is the maximum number of upstream attempts including the first one, defaults to 3
+	MaxAttempts int
+
+	// On lists the failures that are retried, connect | timeout. Defaults to both if On and StatusCodes are empty.
+	On []string
+
+	// StatusCodes are upstream 5xx status codes that are retried. Defaults to 502, 503, 504 if On and StatusCodes are
+	// empty.
+	StatusCodes []int
+
+	// BackoffMillis is the delay before the second attempt, doubled for each attempt after that, with jitter.
+	BackoffMillis int
+
+	// MaxBackoffMillis caps the delay between attempts. 0 means no cap.
+	MaxBackoffMillis int
+
+	// BudgetMillis caps the total time spent on upstream attempts and backoff. 0 means no cap.
+	BudgetMillis int
+}
+
+// retriesOn condition, which is either connect, timeout or an upstream status code.
+func (r Retry) retriesOn(condition string, statusCode int) bool {
+	if statusCode > 0 {
+		for _, s := range r.StatusCodes {
+			if s == statusCode {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range r.On {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff before attempt n+1, between half and all of the exponential delay.
+func (r Retry) backoff(n int) time.Duration {
+	if r.BackoffMillis <= 0 || n < 1 {
+		return 0
+	}
+	d := time.Duration(r.BackoffMillis) * time.Millisecond
+	for i := 1; i < n && d < time.Hour; i++ {
+		d *= 2
+	}
+	if max := time.Duration(r.MaxBackoffMillis) * time.Millisecond; max > 0 && d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (r Retry) budget() time.Duration {
+	return time.Duration(r.BudgetMillis) * time.Millisecond
+}
+
+func (proxy *Proxy) retry() *Retry {
+	if proxy.Route == nil {
+		return nil
+	}
+	return proxy.Route.Retry
+}
+
+// attempts are the upstream attempts so far, not counting hedged attempts.
+func (proxy *Proxy) attempts() int {
+	return proxy.Up.Atmpt.Count - proxy.Up.Hedges
+}
+
+func (proxy *Proxy) maxAttempts() int {
+	if r := proxy.retry(); r != nil {
+		return r.MaxAttempts
+	}
+	return Runner.Connection.Upstream.MaxAttempts
+}
+
+// isRepeatable is true for repeatable methods, and for POST and PATCH with Idempotency-Key on routes with a retry policy.
+func (proxy *Proxy) isRepeatable() bool {
+	for _, method := range httpRepeatableMethods {
+		if proxy.Dwn.Method == method {
+			return true
+		}
+	}
+	return proxy.retry() != nil &&
+		(proxy.Dwn.Method == "POST" || proxy.Dwn.Method == "PATCH") &&
+		proxy.Dwn.Req != nil && len(proxy.Dwn.Req.Header.Get(idempotencyKeyS)) > 0
+}
+
+// isRetryableFailure checks the failed upstream attempt against the route retry policy.
+func (proxy *Proxy) isRetryableFailure() bool {
+	r := proxy.retry()
+	if r == nil {
+		return true
+	}
+	if r.BudgetMillis > 0 && time.Since(proxy.Up.Atmpts[0].startDate) >= r.budget() {
+		return false
+	}
+	switch {
+	case proxy.hasUpstreamAttemptAborted():
+		return r.retriesOn(retryTimeout, 0)
+	case proxy.Up.Atmpt.resp != nil && proxy.Up.Atmpt.resp.StatusCode >= 500:
+		return r.retriesOn(emptyString, proxy.Up.Atmpt.resp.StatusCode)
+	default:
+		//no response, or response body failed
+		return r.retriesOn(retryConnect, 0)
+	}
+}
+
+// passesUpstreamServerError is true for upstream 5xx the route retry policy doesn't retry. They are sent downstream
+// as is, unless a stale cache entry can be served instead.
+func (proxy *Proxy) passesUpstreamServerError() bool {
+	r := proxy.retry()
+	return r != nil &&
+		!r.retriesOn(emptyString, proxy.Up.Atmpt.resp.StatusCode) &&
+		!proxy.hasStaleIfError()
+}
+
+// backoff waits before the next upstream attempt. It returns false if downstream aborted or timed out meanwhile.
+func (proxy *Proxy) backoff() bool {
+	r := proxy.retry()
+	if r == nil {
+		return true
+	}
+	d := r.backoff(proxy.attempts())
+	if r.BudgetMillis > 0 {
+		if left := r.budget() - time.Since(proxy.Up.Atmpts[0].startDate); d > left {
+			d = left
+		}
+	}
+	if d <= 0 {
+		return true
+	}
+
+	scaffoldUpAttemptLog(proxy).
+		Msgf(upstreamRetryBackoff, d)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-proxy.Dwn.Timeout:
+	case <-proxy.Dwn.Aborted:
+	}
+	return !proxy.hasDownstreamAbortedOrTimedout()
+}
+
+// alternateMember returns the next member of the route's resource with the attempt label, so retries and hedged
+// attempts don't repeat the previous member if there are others.
+func (proxy *Proxy) alternateMember() *URL {
+	url := proxy.Up.Atmpt.URL
+	if proxy.Route == nil {
+		return url
+	}
+	route := *proxy.Route
+	resource := Runner.Resources[route.Resource]
+	if route.Balance != nil {
+		if m := proxy.balance(route, resource, proxy.Up.Atmpt.Label, url); m != nil {
+			return m
+		}
+		return url
+	}
+	if route.Retry == nil && route.Hedge == nil {
+		return url
+	}
+
+	members := balanceMembers(route, resource, proxy.Up.Atmpt.Label)
+	for i, m := range members {
+		if m.String() == url.String() && len(members) > 1 {
+			next := members[(i+1)%len(members)]
+			scaffoldUpAttemptLog(proxy).
+				Str(upResource, next.String()).
+				Msg(upstreamRetryAlternateMember)
+			return next
+		}
+	}
+	return url
+}
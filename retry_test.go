@@ -0,0 +1,207 @@
+package j8a
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockRetryUpstream answers all upstream requests with statusCode and counts them
+func mockRetryUpstream(statusCode int) *int32 {
+	var n int32
+	httpClient = &MockHttp{}
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&n, 1)
+		return &http.Response{
+			StatusCode: statusCode,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"status":"error"}`))),
+		}, nil
+	}
+	return &n
+}
+
+func TestRetryRetriesOn(t *testing.T) {
+	r := Retry{On: []string{retryConnect}, StatusCodes: []int{503}}
+	tests := map[string]struct {
+		condition  string
+		statusCode int
+		want       bool
+	}{
+		"connect":    {retryConnect, 0, true},
+		"timeout":    {retryTimeout, 0, false},
+		"listed5xx":  {"", 503, true},
+		"other5xx":   {"", 500, false},
+		"connect5xx": {retryConnect, 502, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := r.retriesOn(tt.condition, tt.statusCode); got != tt.want {
+				t.Errorf("want retry %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffIsExponentialWithJitter(t *testing.T) {
+	r := Retry{BackoffMillis: 100, MaxBackoffMillis: 300}
+	tests := map[int][2]time.Duration{
+		1: {50 * time.Millisecond, 100 * time.Millisecond},
+		2: {100 * time.Millisecond, 200 * time.Millisecond},
+		3: {150 * time.Millisecond, 300 * time.Millisecond},
+		9: {150 * time.Millisecond, 300 * time.Millisecond},
+	}
+	for n, want := range tests {
+		for i := 0; i < 100; i++ {
+			if got := r.backoff(n); got < want[0] || got > want[1] {
+				t.Fatalf("want backoff before attempt %d between %v and %v, got %v", n+1, want[0], want[1], got)
+			}
+		}
+	}
+	if got := (Retry{}).backoff(1); got != 0 {
+		t.Errorf("want no backoff without backoffMillis, got %v", got)
+	}
+}
+
+func TestIsRepeatable(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		key    string
+		retry  *Retry
+		want   bool
+	}{
+		"get":                 {"GET", "", nil, true},
+		"post":                {"POST", "", nil, false},
+		"postKeyNoPolicy":     {"POST", "k1", nil, false},
+		"postNoKey":           {"POST", "", &Retry{}, false},
+		"postKey":             {"POST", "k1", &Retry{}, true},
+		"patchKey":            {"PATCH", "k1", &Retry{}, true},
+		"putWithRoutePolicy":  {"PUT", "", &Retry{}, true},
+		"connectWithKey":      {"CONNECT", "k1", &Retry{}, false},
+		"deleteWithoutPolicy": {"DELETE", "", nil, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			proxy := mockProxy(nil, "0", "/path", "/path", "/get", "", "")
+			proxy.Dwn.Method = tt.method
+			proxy.Route.Retry = tt.retry
+			if len(tt.key) > 0 {
+				proxy.Dwn.Req.Header.Set(idempotencyKeyS, tt.key)
+			}
+			if got := proxy.isRepeatable(); got != tt.want {
+				t.Errorf("want repeatable %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyStatusCodesWithProxyHandler(t *testing.T) {
+	tests := map[string]struct {
+		statusCode int
+		want       int32
+		wantStatus int
+	}{
+		"listed":   {503, 3, 502},
+		"unlisted": {500, 1, 500},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			Runner = mockRuntime()
+			Runner.Routes[0].Retry = &Retry{MaxAttempts: 3, StatusCodes: []int{503}, BackoffMillis: 1}
+			n := mockRetryUpstream(tt.statusCode)
+
+			server := httptest.NewServer(&ProxyHttpHandler{})
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("want %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if got := atomic.LoadInt32(n); got != tt.want {
+				t.Errorf("want %d upstream attempts, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetriesPOSTWithIdempotencyKey(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Routes[0].Retry = &Retry{MaxAttempts: 2, StatusCodes: []int{503}}
+	Runner.Connection.Downstream.MaxBodyBytes = 65535
+	n := mockRetryUpstream(503)
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{"order":1}`))
+	req.Header.Set(idempotencyKeyS, "order-1")
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(n); got != 2 {
+		t.Errorf("want POST with Idempotency-Key retried, got %d upstream attempts", got)
+	}
+}
+
+func TestRetryPolicyStopsWhenBudgetSpent(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockProxy(nil, "0", "/path", "/path", "/get", "", "")
+	proxy.Route.Retry = &Retry{MaxAttempts: 5, StatusCodes: []int{500}, BudgetMillis: 50}
+	proxy.Up.Atmpt.Count = 1
+	proxy.Up.Atmpt.startDate = time.Now()
+	proxy.Up.Atmpt.resp.StatusCode = 500
+
+	if !proxy.shouldRetryUpstreamAttempt() {
+		t.Errorf("want retry within budget")
+	}
+	proxy.Up.Atmpts[0].startDate = time.Now().Add(-time.Second)
+	if proxy.shouldRetryUpstreamAttempt() {
+		t.Errorf("want no retry once budget is spent")
+	}
+}
+
+func TestRetryBackoffStopsOnDownstreamTimeout(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockProxy(nil, "0", "/path", "/path", "/get", "", "")
+	proxy.Route.Retry = &Retry{BackoffMillis: 10000}
+	proxy.Up.Atmpt.Count = 1
+	timeout := make(chan struct{})
+	close(timeout)
+	proxy.Dwn.Timeout = timeout
+
+	start := time.Now()
+	if proxy.backoff() {
+		t.Errorf("want backoff to stop on downstream timeout")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("want backoff to return immediately on downstream timeout")
+	}
+}
+
+func TestRetryOnAlternateMember(t *testing.T) {
+	Runner = mockRuntime()
+	ms := mockBalanceMembers(3)
+	proxy := mockBalanceProxy(ms, "s1")
+	proxy.Route.Balance = nil
+	proxy.Route.Retry = &Retry{MaxAttempts: 3}
+	proxy.Up.Atmpt.URL = &ms[0].URL
+
+	for _, want := range []*URL{&ms[1].URL, &ms[2].URL, &ms[0].URL} {
+		if got := proxy.nextAttempt().Up.Atmpt.URL; got != want {
+			t.Errorf("want retry on %s, got %s", want, got)
+		}
+	}
+
+	proxy.Route.Retry = nil
+	if got := proxy.nextAttempt().Up.Atmpt.URL; got != &ms[0].URL {
+		t.Errorf("want retry on same member without retry policy, got %s", got)
+	}
+}
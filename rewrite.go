@@ -0,0 +1,201 @@
+package j8a
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// pathTemplateVar is a variable in a route path template that matches one path segment, i.e. /users/{id}/orders
+var pathTemplateVar = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// rewriteVar is a variable reference in a rewrite template
+var rewriteVar = regexp.MustCompile(`\{([^{}]*)\}`)
+
+const rewriteHost = "host"
+const rewriteQueryPrefix = "query."
+const ampersandS = "&"
+const equalsS = "="
+
+// RewriteTemplate is a compiled Route.Rewrite, i.e. /v2/orders?user={id}. It references path template variables
+// and named capture groups of the route path as {name}, query parameters of the request as {query.name} and
+// the request host as {host}.
+type RewriteTemplate struct {
+	parts []rewritePart
+	query bool
+	// captures is true if the template references capture groups of the route path
+	captures bool
+	// consumed are the query parameters the template references as {query.name}
+	consumed map[string]bool
+}
+
+type rewritePart struct {
+	literal  string
+	variable string
+	// inQuery parts are escaped as query values, others as path segments
+	inQuery bool
+}
+
+// compilePathTemplate turns path template variables into named capture groups.
+func compilePathTemplate(path string) string {
+	return pathTemplateVar.ReplaceAllString(path, `(?P<$1>[^/]+)`)
+}
+
+// compileRewrite validates the rewrite template against the route's capture groups.
+func (route *Route) compileRewrite() error {
+	t := route.Rewrite
+	if len(t) == 0 {
+		return nil
+	}
+	if len(route.Transform) > 0 {
+		return errors.New("rewrite and transform are mutually exclusive")
+	}
+	if t[:1] != slashS {
+		return fmt.Errorf("rewrite %s must start with '/'", t)
+	}
+	tmpl, err := route.compileTemplate(t)
+	if err != nil {
+		return err
+	}
+	if _, err := url.ParseRequestURI(rewriteVar.ReplaceAllString(t, "x")); err != nil {
+		return fmt.Errorf("rewrite %s not a valid URI", t)
+	}
+	route.CompiledRewrite = tmpl
+	return nil
+}
+
+// compileTemplate parses t and checks it only references the route's capture groups, the query and host.
+func (route *Route) compileTemplate(t string) (*RewriteTemplate, error) {
+	if strings.ContainsAny(rewriteVar.ReplaceAllString(t, emptyString), "{}") {
+		return nil, fmt.Errorf("template %s has unbalanced braces", t)
+	}
+
+	captures := make(map[string]bool)
+	if route.CompiledPathRegex != nil {
+		for _, n := range route.CompiledPathRegex.SubexpNames() {
+			captures[n] = len(n) > 0
+		}
+	}
+
+	tmpl := &RewriteTemplate{consumed: make(map[string]bool)}
+	q := strings.Index(t, Q)
+	last := 0
+	for _, m := range rewriteVar.FindAllStringSubmatchIndex(t, -1) {
+		v := t[m[2]:m[3]]
+		if !captures[v] && v != rewriteHost &&
+			!(strings.HasPrefix(v, rewriteQueryPrefix) && len(v) > len(rewriteQueryPrefix)) {
+			return nil, fmt.Errorf("template %s references unknown variable {%s}", t, v)
+		}
+		tmpl.captures = tmpl.captures || captures[v]
+		if strings.HasPrefix(v, rewriteQueryPrefix) {
+			tmpl.consumed[strings.TrimPrefix(v, rewriteQueryPrefix)] = true
+		}
+		tmpl.parts = append(tmpl.parts,
+			rewritePart{literal: t[last:m[0]]},
+			rewritePart{variable: v, inQuery: q > -1 && m[0] > q})
+		last = m[1]
+	}
+	tmpl.parts = append(tmpl.parts, rewritePart{literal: t[last:]})
+	tmpl.query = q > -1
+	return tmpl, nil
+}
+
+// pathCaptures are the values of the named capture groups of the route path in the escaped request path. It returns
+// false if the escaped path doesn't match.
+func (route Route) pathCaptures(path string) (map[string]string, bool) {
+	m := route.CompiledPathRegex.FindStringSubmatch(path)
+	if m == nil && route.PathType == prefixS && !strings.HasSuffix(path, slashS) {
+		m = route.CompiledPathRegex.FindStringSubmatch(path + slashS)
+	}
+	if m == nil {
+		return nil, false
+	}
+	captures := make(map[string]string)
+	for i, n := range route.CompiledPathRegex.SubexpNames() {
+		if i > 0 && len(n) > 0 {
+			captures[n] = m[i]
+		}
+	}
+	return captures, true
+}
+
+// matchCaptures is true unless the route renders capture groups its path doesn't match in the escaped request path,
+// i.e. for a route path that only matches unescaped characters.
+func (route Route) matchCaptures(request *http.Request) bool {
+	usesCaptures := route.CompiledRewrite != nil && route.CompiledRewrite.captures ||
+		route.Redirect != nil && route.Redirect.target != nil && route.Redirect.target.captures
+	if !usesCaptures {
+		return true
+	}
+	_, ok := route.pathCaptures(request.URL.EscapedPath())
+	return ok
+}
+
+// rewrite renders the upstream request URI. The downstream query parameters the template didn't consume are appended.
+func (proxy *Proxy) rewrite() string {
+	return proxy.Route.CompiledRewrite.render(proxy) +
+		proxy.Route.CompiledRewrite.appendQuery(proxy)
+}
+
+// render the template with the values of the downstream request.
+func (tmpl *RewriteTemplate) render(proxy *Proxy) string {
+	path, rawQuery, _ := strings.Cut(proxy.Dwn.URI, Q)
+	//routes only match if the escaped path has captures, see matchCaptures
+	captures, _ := proxy.Route.pathCaptures(path)
+	query, _ := url.ParseQuery(rawQuery)
+
+	var b strings.Builder
+	for _, p := range tmpl.parts {
+		if len(p.variable) == 0 {
+			b.WriteString(p.literal)
+			continue
+		}
+		var v string
+		var escaped bool
+		switch {
+		case strings.HasPrefix(p.variable, rewriteQueryPrefix):
+			v = query.Get(strings.TrimPrefix(p.variable, rewriteQueryPrefix))
+		case p.variable == rewriteHost:
+			v = proxy.Dwn.Host
+		default:
+			//captures come from the escaped path
+			v, escaped = captures[p.variable], true
+		}
+		switch {
+		case p.inQuery && escaped:
+			u, _ := url.PathUnescape(v)
+			b.WriteString(url.QueryEscape(u))
+		case p.inQuery:
+			b.WriteString(url.QueryEscape(v))
+		case escaped:
+			b.WriteString(v)
+		default:
+			b.WriteString(url.PathEscape(v))
+		}
+	}
+	return b.String()
+}
+
+// appendQuery is the downstream query string to append to the rendered template, if any. Parameters the template
+// already consumed as {query.name} are left out, so they don't reach upstream twice.
+func (tmpl *RewriteTemplate) appendQuery(proxy *Proxy) string {
+	_, rawQuery, _ := strings.Cut(proxy.Dwn.URI, Q)
+	var params []string
+	for _, p := range strings.Split(rawQuery, ampersandS) {
+		name, _, _ := strings.Cut(p, equalsS)
+		if n, err := url.QueryUnescape(name); len(p) == 0 || err == nil && tmpl.consumed[n] {
+			continue
+		}
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		return emptyString
+	}
+	if tmpl.query {
+		return ampersandS + strings.Join(params, ampersandS)
+	}
+	return Q + strings.Join(params, ampersandS)
+}
@@ -0,0 +1,94 @@
+package j8a
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func mockRewriteProxy(path string, pathType string, rewrite string, requestUri string) Proxy {
+	proxy := mockProxy(nil, "0", path, "", requestUri, "", "")
+	route := Route{Path: path, PathType: pathType, Rewrite: rewrite}
+	route.compilePath()
+	if err := route.compileRewrite(); err != nil {
+		panic(err)
+	}
+	proxy.Route = &route
+	proxy.Dwn.Host = "api.example.com"
+	return proxy
+}
+
+func TestCompilePathTemplate(t *testing.T) {
+	route := Route{Path: "/users/{id}/orders", PathType: exact}
+	if ok, err := route.validPath(); !ok {
+		t.Fatalf("want valid path template, got %v", err)
+	}
+	if got := route.CompiledPathRegex.String(); got != "^/users/(?P<id>[^/]+)/orders$" {
+		t.Errorf("want template compiled to named capture group, got %s", got)
+	}
+	if !route.CompiledPathRegex.MatchString("/users/42/orders") || route.CompiledPathRegex.MatchString("/users/4/2/orders") {
+		t.Errorf("want template variable to match a single path segment")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tests := map[string]struct {
+		path       string
+		pathType   string
+		rewrite    string
+		requestUri string
+		want       string
+	}{
+		"pathTemplate":     {"/users/{id}/orders", exact, "/v2/orders?user={id}", "/users/42/orders", "/v2/orders?user=42"},
+		"namedCapture":     {"/users/(?P<id>[0-9]+)", prefixS, "/v2/users/{id}", "/users/42/profile", "/v2/users/42"},
+		"queryAppended":    {"/users/{id}/orders", exact, "/v2/orders?user={id}", "/users/42/orders?page=2", "/v2/orders?user=42&page=2"},
+		"queryAdded":       {"/users/{id}", prefixS, "/v2/users/{id}", "/users/42?page=2", "/v2/users/42?page=2"},
+		"queryVariable":    {"/search", exact, "/v2/find/{query.q}", "/search?q=a+b", "/v2/find/a%20b"},
+		"queryConsumed":    {"/orders", exact, "/v2/orders?user={query.u}", "/orders?u=1&page=2", "/v2/orders?user=1&page=2"},
+		"queryUnconsumed":  {"/search", exact, "/v2/find/{query.q}", "/search?page=2&q=a", "/v2/find/a?page=2"},
+		"missingQuery":     {"/search", exact, "/v2/find?q={query.q}", "/search", "/v2/find?q="},
+		"host":             {"/", prefixS, "/tenants/{host}", "/", "/tenants/api.example.com"},
+		"escapedPath":      {"/files/{name}", exact, "/v2/files/{name}", "/files/a%2Fb", "/v2/files/a%2Fb"},
+		"escapedPathQuery": {"/files/{name}", exact, "/v2/files?name={name}", "/files/a%20b", "/v2/files?name=a+b"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy := mockRewriteProxy(tt.path, tt.pathType, tt.rewrite, tt.requestUri)
+			want := "http://upstreamhost:8080" + tt.want
+			if got := proxy.resolveUpstreamURI(); got != want {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestRewriteRouteMatchesEscapedCaptures(t *testing.T) {
+	for rewrite, want := range map[string]bool{"/v2/{host}": true, "/v2/{id}": false} {
+		route := Route{Path: "/café/{id}", PathType: prefixS, Rewrite: rewrite}
+		route.compilePath()
+		if err := route.compileRewrite(); err != nil {
+			t.Fatal(err)
+		}
+		//the unescaped path matches, but the escaped path has no captures to render
+		if got := route.match(httptest.NewRequest("GET", "/caf%C3%A9/1", nil)); got != want {
+			t.Errorf("rewrite %s want route match %v, got %v", rewrite, want, got)
+		}
+	}
+}
+
+func TestCompileRewriteFails(t *testing.T) {
+	tests := map[string]Route{
+		"noSlash":         {Path: "/users/{id}", Rewrite: "v2/{id}"},
+		"unknownVariable": {Path: "/users/{id}", Rewrite: "/v2/{user}"},
+		"emptyQueryName":  {Path: "/users/{id}", Rewrite: "/v2/{query.}"},
+		"unbalanced":      {Path: "/users/{id}", Rewrite: "/v2/{id"},
+		"withTransform":   {Path: "/users/{id}", Rewrite: "/v2/{id}", Transform: "/v2"},
+	}
+	for name, route := range tests {
+		t.Run(name, func(t *testing.T) {
+			route.compilePath()
+			if err := route.compileRewrite(); err == nil {
+				t.Errorf("want rewrite %s to fail", route.Rewrite)
+			}
+		})
+	}
+}
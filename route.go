@@ -49,9 +49,16 @@ func (w WeightedSlugs) trimNextSlug() (WeightedSlugs, error) {
 	}
 }
 
+// isTemplate is true for a path slug with a template variable, it weighs less than any literal slug.
+func (w WeightedSlugs) isTemplate() bool {
+	return pathTemplateVar.MatchString(w[0])
+}
+
 func (w WeightedSlugs) Less(w2 WeightedSlugs) bool {
 	less := false
-	if len(w[0]) > len(w2[0]) {
+	if w.isTemplate() != w2.isTemplate() {
+		less = w2.isTemplate()
+	} else if len(w[0]) > len(w2[0]) {
 		less = true
 	} else if len(w[0]) == len(w2[0]) {
 		wn, e := w.trimNextSlug()
@@ -80,6 +87,9 @@ func (s Routes) Swap(i, j int) {
 }
 func (s Routes) Less(i, j int) bool {
 	if s[i].PunyHost == s[j].PunyHost {
+		if s[i].Path == s[j].Path && s[i].PathType == s[j].PathType {
+			return s[i].specificity() > s[j].specificity()
+		}
 		return s.PathIsLess(i, j)
 	} else {
 		return s.HostIsLess(i, j)
@@ -112,13 +122,96 @@ type Route struct {
 	PathType          string // exact | prefix
 	CompiledPathRegex *regexp.Regexp
 	Transform         string
+	Rewrite           string           // optional, upstream URI template referencing path variables, query and host
+	CompiledRewrite   *RewriteTemplate // as template
 	Resource          string
 	Policy            string
 	Jwt               string
+	Decompress        *Decompress    // optional, decodes compressed request bodies
+	Cache             *RouteCache    // optional, caches upstream responses
+	Coalesce          bool           // concurrent identical GET and HEAD requests share one upstream request
+	Mirror            *Mirror        // optional, copies requests to another resource
+	StickyCookie      string         // optional, cookie name that keeps clients on the policy label first resolved
+	Balance           *Balance       // optional, consistent hash balancing over resource members
+	Retry             *Retry         // optional, replaces connection.upstream.maxAttempts for this route
+	Hedge             *Hedge         // optional, races slow GET and HEAD upstream attempts against alternate members
+	Timeouts          *RouteTimeouts // optional, overrides connection timeouts in milliseconds
+	Methods           []string       // optional, the methods this route matches, all methods if empty
+	Headers           []RouteMatcher // optional, request headers this route matches
+	Query             []RouteMatcher // optional, query parameters this route matches
+	MaxBodyBytes      int64          // optional, overrides connection.downstream.maxBodyBytes
+	Redirect          *RouteRedirect // optional, redirects instead of proxying to a resource
+	Respond           *RouteRespond  // optional, sends a fixed response instead of proxying to a resource
+}
+
+// allowsMethod is true if the route matches the request method.
+func (route Route) allowsMethod(m string) bool {
+	if len(route.Methods) == 0 {
+		return true
+	}
+	for _, a := range route.Methods {
+		if a == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Mirror sends a sample of requests to a second resource and discards its responses.
+type Mirror struct {
+	// Resource receiving mirrored requests
+	Resource string
+
+	// Percent of requests mirrored, defaults to 100
+	Percent float64
+}
+
+// RouteCache stores GET responses in memory, honouring Cache-Control and Expires of the upstream response.
+type RouteCache struct {
+	// Vary are request headers whose values are part of the cache key, in addition to method, host and URI.
+	Vary []string
+
+	// StaleWhileRevalidateSeconds a stale response is served while it's refreshed in the background. Upstream
+	// stale-while-revalidate may shorten this. Defaults to 0, disabled
+	StaleWhileRevalidateSeconds int
+
+	// StaleIfErrorSeconds a stale response is served if all upstream attempts fail. Upstream stale-if-error may
+	// shorten this. Defaults to 0, disabled
+	StaleIfErrorSeconds int
+}
+
+func (c RouteCache) staleWhileRevalidate() time.Duration {
+	return time.Duration(c.StaleWhileRevalidateSeconds) * time.Second
+}
+
+func (c RouteCache) staleIfError() time.Duration {
+	return time.Duration(c.StaleIfErrorSeconds) * time.Second
+}
+
+// Decompress decodes gzip, br, zstd and deflate request bodies before they are proxied upstream, so that size
+// limits apply to what the upstream receives. Other Content-Encodings are rejected with 415.
+type Decompress struct {
+	// MaxBytes of the decompressed request body, defaults to the route or downstream maxBodyBytes
+	MaxBytes int64
+
+	// MaxRatio of decompressed to compressed size before we assume a zip bomb, defaults to 100
+	MaxRatio int64
+
+	// Recompress the body with one of gzip | br | zstd | deflate before sending it upstream. Defaults to identity
+	Recompress string
 }
 
 const wildcard = "*"
 
+func (routes Routes) haveCache() bool {
+	for _, r := range routes {
+		if r.Cache != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (route *Route) validHostPattern() (bool, error) {
 	//first check the name is a valid idna name.
 	p := idna.New(
@@ -212,7 +305,7 @@ const dollarS = "$"
 const exact = "exact"
 
 func (route *Route) compilePath() error {
-	compileMe := route.Path
+	compileMe := compilePathTemplate(route.Path)
 	if string(compileMe[0]) != startS {
 		compileMe = startS + compileMe
 	}
@@ -227,12 +320,19 @@ func (route *Route) compilePath() error {
 const slashS = "/"
 
 func (route Route) match(request *http.Request) bool {
-	if len(route.PunyHost) > 0 {
-		return route.matchHostHeader(request) &&
-			route.matchURIPath(request)
-	} else {
-		return route.matchURIPath(request)
+	return route.matchRequest(request) &&
+		route.allowsMethod(parseMethod(request))
+}
+
+// matchRequest matches everything but the method.
+func (route Route) matchRequest(request *http.Request) bool {
+	if len(route.PunyHost) > 0 && !route.matchHostHeader(request) {
+		return false
 	}
+	return route.matchURIPath(request) &&
+		route.matchHeaders(request) &&
+		route.matchQuery(request) &&
+		route.matchCaptures(request)
 }
 
 func (route Route) matchHostHeader(request *http.Request) bool {
@@ -270,11 +370,9 @@ const emptyString = ""
 
 // maps a route to a URL. Returns the URL, the name of the mapped policy and whether mapping was successful
 func (route Route) mapURL(proxy *Proxy) (*URL, string, bool) {
-	var policy Policy
 	var policyLabel string
 	if len(route.Policy) > 0 {
-		policy = Runner.Policies[route.Policy]
-		policyLabel = policy.resolveLabel()
+		policyLabel = proxy.policyLabel(route)
 	}
 
 	resource := Runner.Resources[route.Resource]
@@ -283,6 +381,16 @@ func (route Route) mapURL(proxy *Proxy) (*URL, string, bool) {
 	}
 	//if a policy exists, we match resources with a label. TODO: this should be an interface
 
+	if route.Balance != nil {
+		label := policyLabel
+		if len(route.Policy) == 0 {
+			label = defaultMsg
+		}
+		if u := proxy.balance(route, resource, label, nil); u != nil {
+			return u, label, true
+		}
+	}
+
 	if len(route.Policy) > 0 {
 		for _, resourceMapping := range resource {
 			for _, resourceLabel := range resourceMapping.Labels {
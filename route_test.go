@@ -4,6 +4,7 @@ import (
 	"github.com/rs/zerolog"
 	"golang.org/x/net/idna"
 	"net/http"
+	"reflect"
 	"sort"
 	"testing"
 )
@@ -745,6 +746,28 @@ func TestRouteHostPathLess(t *testing.T) {
 				PathType: "prefix",
 			},
 		},
+		{
+			name: "literal path slug should win over template slug",
+			rl: Route{
+				Path:     "/users/me",
+				PathType: "prefix",
+			},
+			rg: Route{
+				Path:     "/users/{id}",
+				PathType: "prefix",
+			},
+		},
+		{
+			name: "literal path slug should win over template slug in the middle",
+			rl: Route{
+				Path:     "/users/me/orders",
+				PathType: "exact",
+			},
+			rg: Route{
+				Path:     "/users/{id}/orders",
+				PathType: "exact",
+			},
+		},
 		{
 			name: "same host, exact path should with over prefix",
 			rl: Route{
@@ -769,7 +792,7 @@ func TestRouteHostPathLess(t *testing.T) {
 			tt.rg.compileHostPattern()
 			rs := Routes{tt.rl, tt.rg}
 			sort.Sort(rs)
-			if rs[0] != tt.rl {
+			if !reflect.DeepEqual(rs[0], tt.rl) {
 				t.Errorf("route %v should be less than %v", tt.rl, tt.rg)
 			}
 		})
@@ -840,3 +863,13 @@ func BenchmarkRouteMatchingRegexNaiveBusyVersion(b *testing.B) {
 //		}
 //	}
 //}
+
+func TestRouteAllowsMethod(t *testing.T) {
+	r := Route{Methods: []string{"GET", "HEAD"}}
+	if !r.allowsMethod("GET") || r.allowsMethod("POST") {
+		t.Errorf("want route to allow only its methods")
+	}
+	if all := (Route{}); !all.allowsMethod("PATCH") {
+		t.Errorf("want route without methods to allow all methods")
+	}
+}
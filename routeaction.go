@@ -0,0 +1,173 @@
+package j8a
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const location = "Location"
+const redirectDefaultStatusCode = 301
+const respondDefaultStatusCode = 200
+const routeRedirected = "route redirected downstream to %s"
+const routeResponded = "route responded with fixed response"
+
+var redirectStatusCodes = []int{301, 302, 303, 307, 308}
+
+// RouteRedirect responds with a redirect instead of proxying the request.
+type RouteRedirect struct {
+	// StatusCode is one of 301, 302, 303, 307, 308, defaults to 301
+	StatusCode int
+
+	// Target is an absolute URL or path template. It references path variables as {name}, query parameters as
+	// {query.name} and the request host as {host}.
+	Target string
+
+	// PreservePath appends the downstream request path to Target
+	PreservePath bool
+
+	// PreserveQuery appends the downstream query string to Target
+	PreserveQuery bool
+
+	target *RewriteTemplate
+}
+
+// RouteRespond responds with a fixed response instead of proxying the request.
+type RouteRespond struct {
+	// StatusCode defaults to 200
+	StatusCode int
+
+	// Headers are sent with the response. Content-Type defaults to the type of File or the body.
+	Headers map[string]string
+
+	// Body is sent inline
+	Body string
+
+	// File is read at config load and sent as body
+	File string
+
+	header      http.Header
+	contentType string
+	body        []byte
+}
+
+// hasAction is true for routes that respond themselves instead of proxying to a resource.
+func (route Route) hasAction() bool {
+	return route.Redirect != nil || route.Respond != nil
+}
+
+func (route *Route) compileRedirect() error {
+	r := route.Redirect
+	if r.StatusCode == 0 {
+		r.StatusCode = redirectDefaultStatusCode
+	}
+	valid := false
+	for _, s := range redirectStatusCodes {
+		valid = valid || s == r.StatusCode
+	}
+	if !valid {
+		return fmt.Errorf("redirect statusCode must be one of %v, was %d", redirectStatusCodes, r.StatusCode)
+	}
+	if len(r.Target) == 0 {
+		return errors.New("redirect must have a target")
+	}
+
+	u, err := url.Parse(rewriteVar.ReplaceAllString(r.Target, "x"))
+	absolute := err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+	path := err == nil && len(u.Scheme) == 0 && len(u.Host) == 0 && strings.HasPrefix(r.Target, slashS)
+	if !absolute && !path {
+		return fmt.Errorf("redirect target %s must be an http(s) URL or start with '/'", r.Target)
+	}
+	if r.PreservePath && strings.Contains(r.Target, Q) {
+		return fmt.Errorf("redirect target %s can't have a query with preservePath", r.Target)
+	}
+
+	r.target, err = route.compileTemplate(r.Target)
+	return err
+}
+
+func (route *Route) compileRespond() error {
+	r := route.Respond
+	if r.StatusCode == 0 {
+		r.StatusCode = respondDefaultStatusCode
+	}
+	if r.StatusCode < 200 || r.StatusCode > 599 {
+		return fmt.Errorf("respond statusCode must be between 200 and 599, was %d", r.StatusCode)
+	}
+	if len(r.Body) > 0 && len(r.File) > 0 {
+		return errors.New("respond must have either body or file")
+	}
+
+	r.body = []byte(r.Body)
+	if len(r.File) > 0 {
+		b, err := os.ReadFile(r.File)
+		if err != nil {
+			return fmt.Errorf("respond unable to read file %s, cause %v", r.File, err)
+		}
+		r.body = b
+	}
+	if len(r.body) > 0 && (r.StatusCode == 204 || r.StatusCode == 304) {
+		return fmt.Errorf("respond statusCode %d can't have a body", r.StatusCode)
+	}
+
+	r.header = make(http.Header)
+	for k, v := range r.Headers {
+		r.header.Set(k, v)
+	}
+	r.contentType = r.header.Get(contentType)
+	r.header.Del(contentType)
+	if len(r.contentType) == 0 && len(r.body) > 0 {
+		switch {
+		case len(r.File) > 0 && len(mime.TypeByExtension(filepath.Ext(r.File))) > 0:
+			r.contentType = mime.TypeByExtension(filepath.Ext(r.File))
+		case json.Valid(r.body):
+			r.contentType = applicationJSON
+		default:
+			r.contentType = http.DetectContentType(r.body)
+		}
+	}
+	return nil
+}
+
+// location renders the redirect target for the downstream request.
+func (r *RouteRedirect) location(proxy *Proxy) string {
+	loc := r.target.render(proxy)
+	if r.PreservePath {
+		path, _, _ := strings.Cut(proxy.Dwn.URI, Q)
+		loc = strings.TrimSuffix(loc, slashS) + path
+	}
+	if r.PreserveQuery {
+		loc += r.target.appendQuery(proxy)
+	}
+	return loc
+}
+
+func (proxy *Proxy) redirect() {
+	loc := proxy.Route.Redirect.location(proxy)
+	proxy.Dwn.Resp.Writer.Header().Set(location, loc)
+
+	infoOrTraceEv(proxy).
+		Str(XRequestID, proxy.XRequestID).
+		Msgf(routeRedirected, loc)
+
+	sendStatusCodeAsJSON(proxy.respondWith(proxy.Route.Redirect.StatusCode, emptyString))
+}
+
+func (proxy *Proxy) respond() {
+	r := proxy.Route.Respond
+	for k, v := range r.header {
+		proxy.Dwn.Resp.Writer.Header()[k] = v
+	}
+
+	infoOrTraceEv(proxy).
+		Str(XRequestID, proxy.XRequestID).
+		Msg(routeResponded)
+
+	sendDownstreamBody(proxy.respondWith(r.StatusCode, http.StatusText(r.StatusCode)), r.contentType, r.body, false)
+}
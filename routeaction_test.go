@@ -0,0 +1,166 @@
+package j8a
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompileRedirectFails(t *testing.T) {
+	tests := map[string]RouteRedirect{
+		"noTarget":            {},
+		"statusCode":          {StatusCode: 200, Target: "/v2"},
+		"relativeTarget":      {Target: "v2"},
+		"otherScheme":         {Target: "ftp://example.com/"},
+		"unknownVariable":     {Target: "/v2/{user}"},
+		"preservePathQuery":   {Target: "/v2?a=b", PreservePath: true},
+		"schemeRelativeNoURL": {Target: "https:///v2"},
+	}
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			route := Route{Path: "/users/{id}", Redirect: &r}
+			route.compilePath()
+			if err := route.compileRedirect(); err == nil {
+				t.Errorf("want redirect %+v to fail", r)
+			}
+		})
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	tests := map[string]struct {
+		redirect   RouteRedirect
+		requestUri string
+		want       string
+	}{
+		"static":        {RouteRedirect{Target: "https://new.example.com/"}, "/users/42?a=b", "https://new.example.com/"},
+		"template":      {RouteRedirect{Target: "/v2/users/{id}?src={host}"}, "/users/42", "/v2/users/42?src=api.example.com"},
+		"preservePath":  {RouteRedirect{Target: "https://new.example.com/", PreservePath: true}, "/users/42?a=b", "https://new.example.com/users/42"},
+		"preserveQuery": {RouteRedirect{Target: "/v2/users/{id}", PreserveQuery: true}, "/users/42?a=b", "/v2/users/42?a=b"},
+		"preserveBoth":  {RouteRedirect{Target: "https://{host}", PreservePath: true, PreserveQuery: true}, "/users/42?a=b", "https://api.example.com/users/42?a=b"},
+		"mergeQuery":    {RouteRedirect{Target: "/v2?user={id}", PreserveQuery: true}, "/users/42?a=b", "/v2?user=42&a=b"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy := mockProxy(nil, "0", "/users/{id}", "", tt.requestUri, "", "")
+			route := Route{Path: "/users/{id}", PathType: prefixS, Redirect: &tt.redirect}
+			route.compilePath()
+			if err := route.compileRedirect(); err != nil {
+				t.Fatal(err)
+			}
+			proxy.Route = &route
+			proxy.Dwn.Host = "api.example.com"
+			if got := route.Redirect.location(&proxy); got != tt.want {
+				t.Errorf("want location %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompileRespond(t *testing.T) {
+	dir := t.TempDir()
+	html := filepath.Join(dir, "index.html")
+	os.WriteFile(html, []byte("<html></html>"), 0644)
+
+	tests := map[string]struct {
+		respond RouteRespond
+		status  int
+		ct      string
+	}{
+		"default":     {RouteRespond{}, 200, ""},
+		"json":        {RouteRespond{Body: `{"ok":true}`}, 200, applicationJSON},
+		"text":        {RouteRespond{StatusCode: 503, Body: "down for maintenance"}, 503, "text/plain; charset=utf-8"},
+		"file":        {RouteRespond{File: html}, 200, "text/html; charset=utf-8"},
+		"contentType": {RouteRespond{Body: "x", Headers: map[string]string{"content-type": "text/csv"}}, 200, "text/csv"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			route := Route{Path: "/", Respond: &tt.respond}
+			if err := route.compileRespond(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.respond.StatusCode != tt.status || tt.respond.contentType != tt.ct {
+				t.Errorf("want status %d content type %s, got %d %s", tt.status, tt.ct, tt.respond.StatusCode, tt.respond.contentType)
+			}
+			if len(tt.respond.header.Get(contentType)) > 0 {
+				t.Errorf("want content type removed from headers")
+			}
+		})
+	}
+}
+
+func TestCompileRespondFails(t *testing.T) {
+	tests := map[string]RouteRespond{
+		"statusCode":       {StatusCode: 101},
+		"bodyAndFile":      {Body: "x", File: "x.json"},
+		"missingFile":      {File: "/does/not/exist.json"},
+		"noContentAndBody": {StatusCode: 204, Body: "x"},
+	}
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			route := Route{Path: "/", Respond: &r}
+			if err := route.compileRespond(); err == nil {
+				t.Errorf("want respond %+v to fail", r)
+			}
+		})
+	}
+}
+
+func TestRouteRedirectWithProxyHandler(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Routes[0].Redirect = &RouteRedirect{StatusCode: 308, Target: "https://new.example.com", PreservePath: true, PreserveQuery: true}
+	Runner.Routes[0].compileRedirect()
+	calls := mockRetryUpstream(200)
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(server.URL + "/users/42?a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 308 {
+		t.Errorf("want 308, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(location); got != "https://new.example.com/users/42?a=b" {
+		t.Errorf("want location with path and query, got %s", got)
+	}
+	if atomic.LoadInt32(calls) != 0 {
+		t.Errorf("want no upstream request for redirect")
+	}
+}
+
+func TestRouteRespondWithProxyHandler(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Routes[0].Respond = &RouteRespond{StatusCode: 200, Body: `{"status":"ok"}`, Headers: map[string]string{"Cache-Control": "no-store"}}
+	Runner.Routes[0].compileRespond()
+	calls := mockRetryUpstream(500)
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != `{"status":"ok"}` {
+		t.Errorf("want fixed response, got %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get(contentType) != applicationJSON || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("want content type and route headers, got %v", resp.Header)
+	}
+	if len(resp.Header.Get(XRequestID)) == 0 {
+		t.Errorf("want standard response headers")
+	}
+	if atomic.LoadInt32(calls) != 0 {
+		t.Errorf("want no upstream request for fixed response")
+	}
+}
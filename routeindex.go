@@ -0,0 +1,167 @@
+package j8a
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// pathMetaChars end the literal prefix of a route path, which is a regular expression.
+const pathMetaChars = `\.+*?()|[]{}^$`
+
+// pathQuantifiers make the character before them optional.
+const pathQuantifiers = `?*{`
+
+// RouteIndex finds the routes that may match a request by host, then by the literal prefix of their path in a
+// radix tree. Candidates are matched in route order, so precedence is the same as matching every route in turn.
+type RouteIndex struct {
+	routes Routes
+	// hosts have a radix tree for each host name, wildcards for each parent domain of a wildcard host.
+	hosts     map[string]*radixNode
+	wildcards map[string]*radixNode
+	anyHost   *radixNode
+}
+
+// radixNode has an edge prefix and holds the routes whose literal path prefix ends here.
+type radixNode struct {
+	prefix   string
+	labels   []byte
+	children []*radixNode
+	routes   []int
+}
+
+// NewRouteIndex indexes sorted, compiled routes.
+func NewRouteIndex(routes Routes) *RouteIndex {
+	ri := &RouteIndex{
+		routes:    routes,
+		hosts:     make(map[string]*radixNode),
+		wildcards: make(map[string]*radixNode),
+		anyHost:   &radixNode{},
+	}
+	for i, route := range routes {
+		ri.tree(route).insert(route.literalPathPrefix(), i)
+	}
+	return ri
+}
+
+func (ri *RouteIndex) tree(route Route) *radixNode {
+	var trees map[string]*radixNode
+	host := route.PunyHost
+	switch {
+	case len(host) == 0:
+		return ri.anyHost
+	case strings.HasPrefix(host, wildcard+dot):
+		trees, host = ri.wildcards, host[len(wildcard+dot):]
+	default:
+		trees = ri.hosts
+	}
+	if _, ok := trees[host]; !ok {
+		trees[host] = &radixNode{}
+	}
+	return trees[host]
+}
+
+// candidates are the indexes of routes that may match request, in route order.
+func (ri *RouteIndex) candidates(request *http.Request) []int {
+	host := parseHost(request)
+	path := request.URL.Path
+
+	c := ri.anyHost.collect(path, nil)
+	if n, ok := ri.hosts[host]; ok {
+		c = n.collect(path, c)
+	}
+	if i := strings.Index(host, dot); i > -1 {
+		if n, ok := ri.wildcards[host[i+1:]]; ok {
+			c = n.collect(path, c)
+		}
+	}
+	sort.Ints(c)
+	return c
+}
+
+// each calls f with the candidate routes for request in route order until f returns true.
+func (ri *RouteIndex) each(request *http.Request, f func(route Route) bool) {
+	for _, i := range ri.candidates(request) {
+		if f(ri.routes[i]) {
+			return
+		}
+	}
+}
+
+// literalPathPrefix is the part of the route path that every matching request path starts with, less a trailing
+// slash, because prefix routes also match the request path with a slash appended.
+func (route Route) literalPathPrefix() string {
+	p := route.Path
+	if i := strings.IndexAny(p, pathMetaChars); i > -1 {
+		if strings.ContainsRune(pathQuantifiers, rune(p[i])) && i > 0 {
+			i--
+		}
+		p = p[:i]
+	}
+	return strings.TrimSuffix(p, slashS)
+}
+
+func (n *radixNode) child(b byte) int {
+	for i, l := range n.labels {
+		if l == b {
+			return i
+		}
+	}
+	return -1
+}
+
+func (n *radixNode) insert(key string, route int) {
+	for len(key) > 0 {
+		i := n.child(key[0])
+		if i == -1 {
+			n.labels = append(n.labels, key[0])
+			n.children = append(n.children, &radixNode{prefix: key, routes: []int{route}})
+			return
+		}
+		c := n.children[i]
+		l := 0
+		for l < len(key) && l < len(c.prefix) && key[l] == c.prefix[l] {
+			l++
+		}
+		if l < len(c.prefix) {
+			//split the edge where the keys diverge
+			split := &radixNode{prefix: c.prefix[:l], labels: []byte{c.prefix[l]}, children: []*radixNode{c}}
+			c.prefix = c.prefix[l:]
+			n.children[i] = split
+			c = split
+		}
+		key = key[l:]
+		n = c
+	}
+	n.routes = append(n.routes, route)
+}
+
+// collect appends the routes of all nodes whose key is a prefix of path.
+func (n *radixNode) collect(path string, dst []int) []int {
+	for {
+		dst = append(dst, n.routes...)
+		if len(path) == 0 {
+			return dst
+		}
+		i := n.child(path[0])
+		if i == -1 || !strings.HasPrefix(path, n.children[i].prefix) {
+			return dst
+		}
+		n = n.children[i]
+		path = path[len(n.prefix):]
+	}
+}
+
+// eachRoute calls f with the routes that may match request in route order until f returns true. It uses the route
+// index if the runtime has one.
+func (runtime *Runtime) eachRoute(request *http.Request, f func(route Route) bool) {
+	if runtime.RouteIndex != nil {
+		runtime.RouteIndex.each(request, f)
+		return
+	}
+	for _, route := range runtime.Routes {
+		if f(route) {
+			return
+		}
+	}
+}
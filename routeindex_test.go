@@ -0,0 +1,165 @@
+package j8a
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+// mockRouteTable has n routes per kind of host, mixing exact, prefix and path template routes like a large config.
+func mockRouteTable(n int) Routes {
+	var routes Routes
+	hosts := []string{"", "api.example.com", "*.tenant.example.com"}
+	for _, host := range hosts {
+		for i := 0; i < n; i++ {
+			routes = append(routes,
+				Route{Host: host, Path: fmt.Sprintf("/svc%d", i), PathType: prefixS},
+				Route{Host: host, Path: fmt.Sprintf("/svc%d/v2/items", i), PathType: exact},
+				Route{Host: host, Path: fmt.Sprintf("/svc%d/users/{id}/orders", i), PathType: prefixS},
+			)
+		}
+	}
+	routes = append(routes, Route{Path: "/", PathType: prefixS})
+	for i := range routes {
+		routes[i].compilePath()
+		if len(routes[i].Host) > 0 {
+			routes[i].compileHostPattern()
+		}
+	}
+	sort.Stable(routes)
+	return routes
+}
+
+func mockRouteRequests(n int) []*http.Request {
+	var reqs []*http.Request
+	for _, host := range []string{"localhost", "api.example.com", "acme.tenant.example.com", "api.example.com:8443"} {
+		for _, path := range []string{"/svc%d", "/svc%d/", "/svc%d/v2/items", "/svc%d/v2/items/1", "/svc%d/users/42/orders", "/nope%d"} {
+			for _, i := range []int{0, n / 2, n - 1} {
+				req := httptest.NewRequest("GET", fmt.Sprintf(path, i), nil)
+				req.Host = host
+				reqs = append(reqs, req)
+			}
+		}
+	}
+	return reqs
+}
+
+func linearRouteMatch(routes Routes, request *http.Request) int {
+	for i, route := range routes {
+		if route.match(request) {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexRouteMatch(ri *RouteIndex, request *http.Request) int {
+	for _, i := range ri.candidates(request) {
+		if ri.routes[i].match(request) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestLiteralPathPrefix(t *testing.T) {
+	tests := map[string]string{
+		"/":                    "",
+		"/mse6":                "/mse6",
+		"/mse6/":               "/mse6",
+		"/users/{id}/orders":   "/users",
+		"/users/(?P<id>[0-9])": "/users",
+		"/files/a.b":           "/files/a",
+		"/colou?r":             "/colo",
+		"/ab+c":                "/ab",
+	}
+	for path, want := range tests {
+		if got := (Route{Path: path}).literalPathPrefix(); got != want {
+			t.Errorf("path %s want literal prefix %s, got %s", path, want, got)
+		}
+	}
+}
+
+func TestRadixNodeCollect(t *testing.T) {
+	root := &radixNode{}
+	for i, k := range []string{"/mse6", "/mse6/get", "/mse7", "/m", "", "/mse6/get"} {
+		root.insert(k, i)
+	}
+	tests := map[string][]int{
+		"/mse6/get/me": {0, 1, 3, 4, 5},
+		"/mse7":        {2, 3, 4},
+		"/mse":         {3, 4},
+		"/x":           {4},
+	}
+	for path, want := range tests {
+		got := root.collect(path, nil)
+		sort.Ints(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("path %s want routes %v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestRouteIndexMatchesLikeLinearScan(t *testing.T) {
+	routes := mockRouteTable(50)
+	ri := NewRouteIndex(routes)
+	for _, req := range mockRouteRequests(50) {
+		want := linearRouteMatch(routes, req)
+		if got := indexRouteMatch(ri, req); got != want {
+			t.Errorf("host %s path %s want route %d, got %d", req.Host, req.URL.Path, want, got)
+		}
+	}
+}
+
+func TestRouteIndexWithProxyHandler(t *testing.T) {
+	Runner = mockRuntime()
+	for i := range Runner.Routes {
+		Runner.Routes[i].PathType = prefixS
+	}
+	sort.Stable(Runner.Routes)
+	Runner.RouteIndex = NewRouteIndex(Runner.Routes)
+	mockRouteMatchUpstream()
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	for path, want := range map[string]string{"/blah/x": "8084", "/other": "8083"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || string(body) != want {
+			t.Errorf("want 200 from member %s for %s, got %d %s", want, path, resp.StatusCode, body)
+		}
+	}
+}
+
+func benchmarkRouteMatch(b *testing.B, n int, match func(Routes, *RouteIndex, *http.Request) int) {
+	routes := mockRouteTable(n)
+	ri := NewRouteIndex(routes)
+	reqs := mockRouteRequests(n)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		match(routes, ri, reqs[i%len(reqs)])
+	}
+}
+
+func linear(routes Routes, _ *RouteIndex, req *http.Request) int {
+	return linearRouteMatch(routes, req)
+}
+
+func indexed(_ Routes, ri *RouteIndex, req *http.Request) int {
+	return indexRouteMatch(ri, req)
+}
+
+func BenchmarkRouteMatchLinear10(b *testing.B)   { benchmarkRouteMatch(b, 10, linear) }
+func BenchmarkRouteMatchIndex10(b *testing.B)    { benchmarkRouteMatch(b, 10, indexed) }
+func BenchmarkRouteMatchLinear100(b *testing.B)  { benchmarkRouteMatch(b, 100, linear) }
+func BenchmarkRouteMatchIndex100(b *testing.B)   { benchmarkRouteMatch(b, 100, indexed) }
+func BenchmarkRouteMatchLinear1000(b *testing.B) { benchmarkRouteMatch(b, 1000, linear) }
+func BenchmarkRouteMatchIndex1000(b *testing.B)  { benchmarkRouteMatch(b, 1000, indexed) }
@@ -0,0 +1,168 @@
+package j8a
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RouteMatcher matches a request header or query parameter by Name. It matches a Value exactly, a Regex anywhere
+// in the value unless anchored, or the presence of the parameter if neither is set. Absent inverts presence.
+type RouteMatcher struct {
+	Name          string
+	Value         string
+	Regex         string
+	Absent        bool
+	CompiledRegex *regexp.Regexp
+}
+
+func (m *RouteMatcher) compile() error {
+	if len(strings.TrimSpace(m.Name)) == 0 {
+		return errors.New("matcher must have a name")
+	}
+	if len(m.Value) > 0 && len(m.Regex) > 0 {
+		return fmt.Errorf("matcher %s must have either value or regex", m.Name)
+	}
+	if m.Absent && len(m.Value)+len(m.Regex) > 0 {
+		return fmt.Errorf("matcher %s for absent parameter can't have value or regex", m.Name)
+	}
+	if len(m.Regex) > 0 {
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("matcher %s regex %s invalid, cause %v", m.Name, m.Regex, err)
+		}
+		m.CompiledRegex = re
+	}
+	return nil
+}
+
+// compileMatchers compiles header and query matchers. Header names are canonicalised.
+func (route *Route) compileMatchers() error {
+	for i := range route.Headers {
+		if err := route.Headers[i].compile(); err != nil {
+			return err
+		}
+		route.Headers[i].Name = textproto.CanonicalMIMEHeaderKey(route.Headers[i].Name)
+	}
+	for i := range route.Query {
+		if err := route.Query[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches any of the values present for the parameter
+func (m RouteMatcher) matches(values []string) bool {
+	if m.Absent {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	for _, v := range values {
+		switch {
+		case m.CompiledRegex != nil:
+			if m.CompiledRegex.MatchString(v) {
+				return true
+			}
+		case len(m.Value) > 0:
+			if v == m.Value {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (route Route) matchHeaders(request *http.Request) bool {
+	for _, m := range route.Headers {
+		if !m.matches(request.Header.Values(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (route Route) matchQuery(request *http.Request) bool {
+	if len(route.Query) == 0 {
+		return true
+	}
+	q := request.URL.Query()
+	for _, m := range route.Query {
+		if !m.matches(q[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// specificity of a route's matchers beyond host and path. Routes with the same host and path are tried with the
+// most specific first.
+func (route Route) specificity() int {
+	s := len(route.Headers) + len(route.Query)
+	if len(route.Methods) > 0 {
+		s++
+	}
+	return s
+}
+
+// identity distinguishes routes sharing host and path by their matchers, so their responses aren't mixed up.
+func (route Route) identity() string {
+	var b strings.Builder
+	b.WriteString(route.PunyHost)
+	b.WriteString(Sep)
+	b.WriteString(route.PathType)
+	b.WriteString(Sep)
+	b.WriteString(route.Path)
+	for _, m := range route.Headers {
+		b.WriteString(m.identity("h:"))
+	}
+	for _, m := range route.Query {
+		b.WriteString(m.identity("q:"))
+	}
+	if len(route.Methods) > 0 {
+		b.WriteString(Sep)
+		b.WriteString(strings.Join(route.Methods, COMMA))
+	}
+	return b.String()
+}
+
+func (m RouteMatcher) identity(kind string) string {
+	return fmt.Sprintf("%s%s%s=%q~%q!%t", Sep, kind, m.Name, m.Value, m.Regex, m.Absent)
+}
+
+// allowedMethods are the methods of all routes matching request other than by method, or nil if any of them matches
+// every method.
+func (runtime *Runtime) allowedMethods(request *http.Request) []string {
+	seen := make(map[string]bool)
+	var allowed []string
+	all := false
+	runtime.eachRoute(request, func(route Route) bool {
+		if !route.matchRequest(request) {
+			return false
+		}
+		if len(route.Methods) == 0 {
+			all = true
+			return true
+		}
+		for _, m := range route.Methods {
+			if !seen[m] {
+				seen[m] = true
+				allowed = append(allowed, m)
+			}
+		}
+		return false
+	})
+	if all {
+		return nil
+	}
+	sort.Strings(allowed)
+	return allowed
+}
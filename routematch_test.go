@@ -0,0 +1,130 @@
+package j8a
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRouteMatcherMatches(t *testing.T) {
+	tests := map[string]struct {
+		m      RouteMatcher
+		values []string
+		want   bool
+	}{
+		"exact":           {RouteMatcher{Name: "v", Value: "2"}, []string{"2"}, true},
+		"exactMismatch":   {RouteMatcher{Name: "v", Value: "2"}, []string{"20"}, false},
+		"exactAnyValue":   {RouteMatcher{Name: "v", Value: "2"}, []string{"1", "2"}, true},
+		"regex":           {RouteMatcher{Name: "v", Regex: "^application/vnd\\.api\\.v2"}, []string{"application/vnd.api.v2+json"}, true},
+		"regexMismatch":   {RouteMatcher{Name: "v", Regex: "^application/vnd\\.api\\.v2"}, []string{"application/json"}, false},
+		"present":         {RouteMatcher{Name: "v"}, []string{""}, true},
+		"presentMissing":  {RouteMatcher{Name: "v"}, nil, false},
+		"absent":          {RouteMatcher{Name: "v", Absent: true}, nil, true},
+		"absentButExists": {RouteMatcher{Name: "v", Absent: true}, []string{"1"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.m.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.m.matches(tt.values); got != tt.want {
+				t.Errorf("want match %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRouteMatcherCompileFails(t *testing.T) {
+	tests := map[string]RouteMatcher{
+		"noName":        {Value: "1"},
+		"valueAndRegex": {Name: "v", Value: "1", Regex: "1"},
+		"absentValue":   {Name: "v", Value: "1", Absent: true},
+		"badRegex":      {Name: "v", Regex: "(["},
+	}
+	for name, m := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := m.compile(); err == nil {
+				t.Errorf("want compile error for matcher %+v", m)
+			}
+		})
+	}
+}
+
+func TestRouteMatchHeadersAndQuery(t *testing.T) {
+	route := Route{
+		Path:    "/api",
+		Headers: []RouteMatcher{{Name: "x-api-version", Value: "2"}},
+		Query:   []RouteMatcher{{Name: "beta"}},
+		Methods: []string{"GET"},
+	}
+	route.compilePath()
+	if err := route.compileMatchers(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		method string
+		url    string
+		header string
+		want   bool
+	}{
+		"all":          {"GET", "/api?beta", "2", true},
+		"wrongHeader":  {"GET", "/api?beta", "1", false},
+		"noQuery":      {"GET", "/api", "2", false},
+		"otherQuery":   {"GET", "/api?alpha=1", "2", false},
+		"wrongMethod":  {"POST", "/api?beta", "2", false},
+		"lowerMethod":  {"get", "/api?beta=1", "2", true},
+		"pathMismatch": {"GET", "/other?beta", "2", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			req.Method = tt.method
+			req.Header.Set("X-Api-Version", tt.header)
+			if got := route.match(req); got != tt.want {
+				t.Errorf("want match %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRoutesSortMoreSpecificMatchersFirst(t *testing.T) {
+	plain := Route{Path: "/api", PathType: prefixS, Resource: "plain"}
+	post := Route{Path: "/api", PathType: prefixS, Resource: "post", Methods: []string{"POST"}}
+	v2 := Route{Path: "/api", PathType: prefixS, Resource: "v2", Methods: []string{"GET"},
+		Headers: []RouteMatcher{{Name: "X-Api-Version", Value: "2"}}}
+	longer := Route{Path: "/api/v1", PathType: prefixS, Resource: "longer"}
+
+	routes := Routes{plain, post, longer, v2}
+	sort.Stable(routes)
+
+	want := []string{"longer", "v2", "post", "plain"}
+	var got []string
+	for _, r := range routes {
+		got = append(got, r.Resource)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want routes sorted %v, got %v", want, got)
+	}
+}
+
+func TestRoutesAllowedMethods(t *testing.T) {
+	get := Route{Path: "/api", Methods: []string{"HEAD", "GET"}}
+	post := Route{Path: "/api", Methods: []string{"POST", "GET"}}
+	other := Route{Path: "/other"}
+	for _, r := range []*Route{&get, &post, &other} {
+		r.compilePath()
+	}
+
+	runtime := &Runtime{Config: Config{Routes: Routes{get, post, other}}}
+	if got := runtime.allowedMethods(httptest.NewRequest("DELETE", "/api", nil)); !reflect.DeepEqual(got, []string{"GET", "HEAD", "POST"}) {
+		t.Errorf("want union of route methods, got %v", got)
+	}
+	if got := runtime.allowedMethods(httptest.NewRequest("DELETE", "/other", nil)); got != nil {
+		t.Errorf("want no allowed methods for route matching all methods, got %v", got)
+	}
+	if got := runtime.allowedMethods(httptest.NewRequest("DELETE", "/none", nil)); got != nil {
+		t.Errorf("want no allowed methods for unmatched path, got %v", got)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/process"
+	"github.com/simonmittag/lego/v4/challenge/tlsalpn01"
 	golog "log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -28,13 +30,20 @@ var ID string = "unknown"
 // Runtime struct defines runtime environment wrapper for a config.
 type Runtime struct {
 	Config
-	Start             time.Time
-	StateHandler      *StateHandler
-	Memory            []sample
-	AcmeHandler       *AcmeHandler
-	ReloadableCert    *ReloadableCert
-	cacheDir          string
+	Start              time.Time
+	StateHandler       *StateHandler
+	Memory             []sample
+	AcmeHandler        *AcmeHandler
+	AcmeTlsAlpnHandler *AcmeTlsAlpnHandler
+	ReloadableCert     *ReloadableCert
+	cacheDir           string
+	//last TLS file pair that failed validation, only used by the TLS file watcher
+	tlsFilesRejected  string
 	ConnectionWatcher ConnectionWatcher
+	ResponseCache     *ResponseCache
+	Coalescer         *Coalescer
+	Balancer          *Balancer
+	RouteIndex        *RouteIndex
 }
 
 // Runner is the Live environment of the server
@@ -77,11 +86,16 @@ func BootStrap() {
 	config := processConfig()
 
 	Runner = &Runtime{
-		StateHandler:      NewStateHandler(),
-		Config:            *config,
-		Start:             time.Now(),
-		AcmeHandler:       NewAcmeHandler(),
-		ConnectionWatcher: ConnectionWatcher{dwnOpenConns: 0},
+		StateHandler:       NewStateHandler(),
+		Config:             *config,
+		Start:              time.Now(),
+		AcmeHandler:        NewAcmeHandler(),
+		AcmeTlsAlpnHandler: NewAcmeTlsAlpnHandler(),
+		ConnectionWatcher:  ConnectionWatcher{dwnOpenConns: 0},
+		ResponseCache:      NewResponseCache(config.Cache.MaxBytes),
+		Coalescer:          NewCoalescer(),
+		Balancer:           NewBalancer(),
+		RouteIndex:         NewRouteIndex(config.Routes),
 	}
 
 	Runner.
@@ -118,11 +132,18 @@ func processConfig() *Config {
 		compileRoutePaths().
 		compileRouteHosts().
 		compileRouteTransforms().
+		compileRouteMatchers().
+		compileRouteActions().
 		validateRoutes().
 		addDefaultPolicy().
+		validatePolicies().
 		setDefaultUpstreamParams().
 		setDefaultDownstreamParams().
 		validateHTTPConfig().
+		validateCompression().
+		validateCache().
+		loadTlsFiles().
+		validateSessionTickets().
 		validateAcmeConfig()
 	return config
 }
@@ -184,10 +205,11 @@ func (r *Runtime) cacheDirIsActive() bool {
 
 func (r *Runtime) initReloadableCert() *Runtime {
 	r.ReloadableCert = &ReloadableCert{
-		Cert:    nil,
-		Init:    false,
-		mu:      sync.Mutex{},
-		runtime: r,
+		Cert:        nil,
+		Init:        false,
+		mu:          sync.Mutex{},
+		runtime:     r,
+		ocspStaples: make(map[string]*OcspStaple),
 	}
 	return r
 }
@@ -245,6 +267,8 @@ var acmeRex, _ = regexp.Compile("/.well-known/acme-challenge/")
 var aboutRex, _ = regexp.Compile("^" + aboutPath + "$")
 
 const star = "*"
+const alpnH2 = "h2"
+const alpnHttp11 = "http/1.1"
 const options = "OPTIONS"
 
 func (hd HandlerDelegate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -273,7 +297,10 @@ func (runtime *Runtime) startTls(server *http.Server, err chan<- error, msg stri
 		cacheErr := runtime.loadAcmeCertAndKeyFromCache(p)
 		if cacheErr != nil {
 			//so caching didn't work let's go to acmeProvider
-			acmeErr := runtime.fetchAcmeCertAndKey(acmeProviders[p].endpoint)
+			cert, key, acmeErr := runtime.fetchAcmeCertAndKey(runtime.Connection.Downstream.Tls.Acme.directoryUrl())
+			if acmeErr == nil {
+				acmeErr = runtime.ReloadableCert.swap(cert, key)
+			}
 			if acmeErr != nil {
 				err <- acmeErr
 				return
@@ -291,12 +318,14 @@ func (runtime *Runtime) startTls(server *http.Server, err chan<- error, msg stri
 		return
 	}
 
-	_, tlsErr := checkFullCertChain(runtime.ReloadableCert.Cert)
+	_, tlsErr := checkFullCertChain(runtime.ReloadableCert.certificate())
 	if tlsErr == nil {
 		go runtime.tlsHealthCheck(true)
+		runtime.initOcspStapling()
+		runtime.watchTlsFiles()
 		log.Info().Msg(msg)
 		runtime.StateHandler.setState(Daemon)
-		err <- server.ListenAndServeTLS("", "")
+		err <- serveTls(server)
 	} else {
 		err <- tlsErr
 	}
@@ -309,6 +338,16 @@ func (runtime *Runtime) startHTTP(server *http.Server, err chan<- error, msg str
 	err <- server.ListenAndServe()
 }
 
+// serveTls serves server.TLSConfig as is, so that session ticket keys rotated on it take effect.
+// ListenAndServeTLS would serve a clone.
+func serveTls(server *http.Server) error {
+	ln, e := net.Listen("tcp", server.Addr)
+	if e != nil {
+		return e
+	}
+	return server.Serve(tls.NewListener(ln, server.TLSConfig))
+}
+
 func (runtime *Runtime) initUserAgent() *Runtime {
 	if httpClient == nil {
 		httpClient = scaffoldHTTPClient(runtime)
@@ -344,11 +383,10 @@ func serverVersion() string {
 func (runtime *Runtime) tlsConfig() (*tls.Config, error) {
 	//keypair and cert from the runtime params. They may have originated from the config file or ACME
 	//in both instances the certificate now sits as reloadable in GetCertificateFunc which also uses Runner.
-	var cert []byte = []byte(runtime.Connection.Downstream.Tls.Cert)
-	var key []byte = []byte(runtime.Connection.Downstream.Tls.Key)
+	cert, key := runtime.ReloadableCert.keyPair()
 
 	//tls config validation
-	if _, err := checkFullCertChainFromBytes(cert, key); err != nil {
+	if _, err := checkFullCertChainFromBytes([]byte(cert), []byte(key)); err != nil {
 		return nil, err
 	}
 
@@ -373,6 +411,17 @@ func (runtime *Runtime) tlsConfig() (*tls.Config, error) {
 		GetCertificate: runtime.ReloadableCert.GetCertificateFunc,
 	}
 
+	if err := runtime.initSessionTickets(config); err != nil {
+		return nil, err
+	}
+
+	//we serve this config through our own listener, so net/http only configures http/2 if we offer h2 here.
+	//the ACME protocol goes first so validators can complete the handshake.
+	config.NextProtos = []string{alpnH2, alpnHttp11}
+	if runtime.Connection.Downstream.Tls.Acme.isTlsAlpn01() {
+		config.NextProtos = append([]string{tlsalpn01.ACMETLS1Protocol}, config.NextProtos...)
+	}
+
 	return config, nil
 }
 
@@ -397,30 +446,26 @@ func sendStatusCodeAsJSON(proxy *Proxy) {
 		proxy.Dwn.Resp.Message = statusCodeResponse.Message
 	}
 
-	proxy.writeStandardResponseHeaders()
-
-	b := []byte(statusCodeResponse.AsJSON())
-	proxy.Dwn.Resp.Body = &b
-	if proxy.Dwn.AcceptEncoding.isCompatible(EncIdentity) {
-		proxy.Dwn.Resp.ContentEncoding = EncIdentity
-	} else if proxy.Dwn.AcceptEncoding.isCompatible(EncGzip) {
-		proxy.Dwn.Resp.Body = Gzip(*proxy.Dwn.Resp.Body)
-		proxy.Dwn.Resp.ContentEncoding = EncGzip
-	} else if proxy.Dwn.AcceptEncoding.isCompatible(EncBrotli) {
-		proxy.Dwn.Resp.Body = BrotliEncode(*proxy.Dwn.Resp.Body)
-		proxy.Dwn.Resp.ContentEncoding = EncBrotli
-	} else {
-		//fallback
-		proxy.Dwn.Resp.ContentEncoding = EncIdentity
-	}
-
 	if proxy.Dwn.Resp.StatusCode >= clientError {
 		//for http1.1 we send a connection:close. Go HTTP/2 server removes this header which is illegal in HTTP/2.
 		//but magically maps this to a GOAWAY frame for HTTP/2, see: https://go-review.googlesource.com/c/net/+/121415/
 		proxy.Dwn.Resp.Writer.Header().Set(connectionS, closeS)
 	}
 
-	proxy.Dwn.Resp.Writer.Header().Set(contentType, applicationJSON)
+	sendDownstreamBody(proxy, applicationJSON, []byte(statusCodeResponse.AsJSON()), true)
+}
+
+// sendDownstreamBody encodes and writes a response body j8a generated itself, then logs the round trip.
+func sendDownstreamBody(proxy *Proxy, ct string, b []byte, preferIdentity bool) {
+	proxy.writeStandardResponseHeaders()
+
+	enc := proxy.compression().negotiate(proxy.Dwn.AcceptEncoding, preferIdentity, ct, len(b))
+	proxy.Dwn.Resp.Body = proxy.compression().encode(enc, b)
+	proxy.Dwn.Resp.ContentEncoding = enc
+
+	if len(ct) > 0 {
+		proxy.Dwn.Resp.Writer.Header().Set(contentType, ct)
+	}
 	proxy.Dwn.Resp.Writer.Header().Set(contentEncoding, proxy.Dwn.Resp.ContentEncoding.print())
 	proxy.setContentLengthHeader()
 	proxy.Dwn.Resp.Writer.WriteHeader(proxy.Dwn.Resp.StatusCode)
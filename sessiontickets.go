@@ -0,0 +1,122 @@
+package j8a
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const sessionTicketDefaultRotationHours = 24
+const sessionTicketDefaultPreviousKeys = 2
+const sessionTicketMinSecretBytes = 32
+
+func (s SessionTickets) hasSecret() bool {
+	return len(s.SecretFile) > 0 || len(s.SecretEnv) > 0
+}
+
+func (s SessionTickets) loadSecret() ([]byte, error) {
+	var encoded string
+	if len(s.SecretFile) > 0 {
+		b, e := os.ReadFile(s.SecretFile)
+		if e != nil {
+			return nil, e
+		}
+		encoded = string(b)
+	} else {
+		encoded = os.Getenv(s.SecretEnv)
+		if len(encoded) == 0 {
+			return nil, fmt.Errorf("environment variable %s is empty", s.SecretEnv)
+		}
+	}
+
+	secret, e := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if e != nil {
+		return nil, fmt.Errorf("secret is not base64 encoded, cause: %v", e)
+	}
+	if len(secret) < sessionTicketMinSecretBytes {
+		return nil, fmt.Errorf("secret must have at least %d bytes, was %d", sessionTicketMinSecretBytes, len(secret))
+	}
+	return secret, nil
+}
+
+func (s SessionTickets) rotation() time.Duration {
+	return time.Hour * time.Duration(s.RotationHours)
+}
+
+// sessionTicketKeys derives the ticket key for the rotation period at time now, followed by the keys of the
+// previous periods. Instances sharing the secret and rotation derive identical keys without talking to each other.
+func sessionTicketKeys(secret []byte, rotation time.Duration, previous int, now time.Time) [][32]byte {
+	period := now.Unix() / int64(rotation/time.Second)
+	keys := make([][32]byte, previous+1)
+	for i := range keys {
+		mac := hmac.New(sha256.New, secret)
+		binary.Write(mac, binary.BigEndian, period-int64(i))
+		copy(keys[i][:], mac.Sum(nil))
+	}
+	return keys
+}
+
+// SessionTicketKeyring rotates the derived keys on the tls.Config being served. net/http would clone the config
+// in ListenAndServeTLS and miss later rotations, so j8a serves it through its own TLS listener.
+type SessionTicketKeyring struct {
+	config   *tls.Config
+	secret   []byte
+	rotation time.Duration
+	previous int
+}
+
+func (k *SessionTicketKeyring) rotate(now time.Time) {
+	//tickets we can't decrypt with any of these keys fall back to a full handshake
+	k.config.SetSessionTicketKeys(sessionTicketKeys(k.secret, k.rotation, k.previous, now))
+}
+
+// nextRotation is the wait period until the start of the next rotation period.
+func (k *SessionTicketKeyring) nextRotation(now time.Time) time.Duration {
+	return k.rotation - time.Duration(now.UnixNano()%int64(k.rotation))
+}
+
+func (r *Runtime) initSessionTickets(config *tls.Config) error {
+	st := r.Connection.Downstream.Tls.SessionTickets
+	if st.Disable {
+		config.SessionTicketsDisabled = true
+		log.Info().Msg("TLS session tickets disabled")
+		return nil
+	}
+
+	if !st.hasSecret() {
+		//go's default, random keys per process rotated daily.
+		return nil
+	}
+
+	secret, e := st.loadSecret()
+	if e != nil {
+		return fmt.Errorf("unable to load TLS session ticket secret, cause: %v", e)
+	}
+
+	k := &SessionTicketKeyring{
+		config:   config,
+		secret:   secret,
+		rotation: st.rotation(),
+		previous: st.PreviousKeys,
+	}
+	k.rotate(time.Now())
+
+	go func() {
+		for {
+			time.Sleep(k.nextRotation(time.Now()))
+			k.rotate(time.Now())
+			log.Info().Msgf("TLS session ticket keys rotated, keeping %d previous key(s)", k.previous)
+		}
+	}()
+
+	log.Info().Msgf("TLS session ticket keys derived from shared secret, rotating every %v", k.rotation)
+	return nil
+}
@@ -0,0 +1,184 @@
+package j8a
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const testSessionTicketSecretEnv = "J8A_TEST_SESSION_TICKET_SECRET"
+
+func TestSessionTicketKeysDerivation(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	k1 := sessionTicketKeys(secret, time.Hour*24, 2, now)
+	k2 := sessionTicketKeys(secret, time.Hour*24, 2, now.Add(time.Hour))
+	if len(k1) != 3 {
+		t.Fatalf("session ticket keys should contain current and 2 previous keys, but had %d", len(k1))
+	}
+	if k1[0] != k2[0] {
+		t.Errorf("session ticket key should be identical within the same rotation period")
+	}
+
+	next := sessionTicketKeys(secret, time.Hour*24, 2, now.Add(time.Hour*24))
+	if next[0] == k1[0] {
+		t.Errorf("session ticket key should change after rotation")
+	}
+	if next[1] != k1[0] || next[2] != k1[1] {
+		t.Errorf("previous session ticket keys should be kept after rotation")
+	}
+
+	other := sessionTicketKeys([]byte("fedcba9876543210fedcba9876543210"), time.Hour*24, 2, now)
+	if other[0] == k1[0] {
+		t.Errorf("session ticket keys should differ for different secrets")
+	}
+}
+
+func TestSessionTicketKeyringNextRotation(t *testing.T) {
+	k := SessionTicketKeyring{rotation: time.Hour * 24}
+	now := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	if d := k.nextRotation(now); d != time.Hour*6 {
+		t.Errorf("next rotation should be at start of next period in 6h, but was %v", d)
+	}
+}
+
+// serveTlsOnce accepts connections, completes the handshake and writes a byte so that clients receive tickets.
+func serveTlsOnce(t *testing.T, config *tls.Config) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("unable to listen, cause: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("k"))
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func dialTlsResumed(t *testing.T, ln net.Listener, cache tls.ClientSessionCache) bool {
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true,
+		ClientSessionCache: cache,
+	})
+	if err != nil {
+		t.Fatalf("unable to connect, cause: %v", err)
+	}
+	defer conn.Close()
+	io.ReadAll(conn)
+	return conn.ConnectionState().DidResume
+}
+
+func sessionTicketInstance(t *testing.T, secret string) *tls.Config {
+	os.Setenv(testSessionTicketSecretEnv, secret)
+	defer os.Unsetenv(testSessionTicketSecretEnv)
+
+	config, err := mockTlsConfig()
+	if err != nil {
+		t.Fatalf("unable to create TLS config, cause: %v", err)
+	}
+	Runner.Connection.Downstream.Tls.SessionTickets = SessionTickets{
+		SecretEnv:     testSessionTicketSecretEnv,
+		RotationHours: 24,
+		PreviousKeys:  2,
+	}
+	if err = Runner.initSessionTickets(config); err != nil {
+		t.Fatalf("unable to init session tickets, cause: %v", err)
+	}
+	return config
+}
+
+func TestSessionTicketsResumeAcrossInstancesWithSharedSecret(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	a := serveTlsOnce(t, sessionTicketInstance(t, secret))
+	defer a.Close()
+	b := serveTlsOnce(t, sessionTicketInstance(t, secret))
+	defer b.Close()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	if dialTlsResumed(t, a, cache) {
+		t.Errorf("first connection should not have resumed")
+	}
+	if !dialTlsResumed(t, b, cache) {
+		t.Errorf("connection to second instance with shared secret should have resumed session")
+	}
+}
+
+func TestSessionTicketsDoNotResumeWithDifferentSecret(t *testing.T) {
+	a := serveTlsOnce(t, sessionTicketInstance(t, base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))))
+	defer a.Close()
+	b := serveTlsOnce(t, sessionTicketInstance(t, base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))))
+	defer b.Close()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	dialTlsResumed(t, a, cache)
+	if dialTlsResumed(t, b, cache) {
+		t.Errorf("connection to instance with different secret should not have resumed session")
+	}
+}
+
+func TestSessionTicketsDisabled(t *testing.T) {
+	config, _ := mockTlsConfig()
+	Runner.Connection.Downstream.Tls.SessionTickets = SessionTickets{Disable: true}
+	Runner.initSessionTickets(config)
+
+	ln := serveTlsOnce(t, config)
+	defer ln.Close()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	dialTlsResumed(t, ln, cache)
+	if dialTlsResumed(t, ln, cache) {
+		t.Errorf("session should not resume with session tickets disabled")
+	}
+}
+
+func TestSessionTicketKeyringRotatesServedConfig(t *testing.T) {
+	config, _ := mockTlsConfig()
+	now := time.Now()
+	k := &SessionTicketKeyring{
+		config:   config,
+		secret:   []byte("0123456789abcdef0123456789abcdef"),
+		rotation: time.Hour * 24,
+		previous: 1,
+	}
+	k.rotate(now)
+
+	ln := serveTlsOnce(t, config)
+	defer ln.Close()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	dialTlsResumed(t, ln, cache)
+	if !dialTlsResumed(t, ln, cache) {
+		t.Errorf("session should resume with current key")
+	}
+
+	k.rotate(now.Add(k.rotation))
+	if !dialTlsResumed(t, ln, cache) {
+		t.Errorf("session should resume with previous key after one rotation")
+	}
+
+	k.rotate(now.Add(k.rotation * 3))
+	if dialTlsResumed(t, ln, cache) {
+		t.Errorf("session should not resume after its key was rotated out of served config")
+	}
+}
+
+func TestSessionTicketsLoadSecretTooShort(t *testing.T) {
+	os.Setenv(testSessionTicketSecretEnv, base64.StdEncoding.EncodeToString([]byte("short")))
+	defer os.Unsetenv(testSessionTicketSecretEnv)
+
+	if _, err := (SessionTickets{SecretEnv: testSessionTicketSecretEnv}).loadSecret(); err == nil {
+		t.Errorf("session ticket secret shorter than %d bytes should have been rejected", sessionTicketMinSecretBytes)
+	}
+}
@@ -199,6 +199,9 @@ func (rt *Runtime) LookUpResourceIps() map[string][]net.IP {
 	var ips = make(map[string][]net.IP)
 	for _, v := range rt.Resources {
 		for _, r := range v {
+			if r.URL.Scheme == fileScheme {
+				continue
+			}
 			is := make([]net.IP, 1)
 			h := strings.TrimLeft(r.URL.Host, "[")
 			h = strings.TrimRight(h, "]")
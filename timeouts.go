@@ -0,0 +1,93 @@
+package j8a
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// routeWriteGrace is added to the route round trip timeout for the downstream write deadline, same as the server's.
+const routeWriteGrace = time.Second
+
+const routeTimeoutsApplied = "route timeouts applied"
+const dwnRoundTripTimeoutMillis = "dwnRoundTripTimeoutMillis"
+const upReadTimeoutMillis = "upReadTimeoutMillis"
+const upSocketTimeoutMillis = "upSocketTimeoutMillis"
+
+type upstreamSocketTimeoutKey struct{}
+
+// RouteTimeouts override the connection timeouts for a single route, in milliseconds. Zero values use the
+// connection defaults.
+type RouteTimeouts struct {
+	// RoundTripMillis overrides connection.downstream.roundTripTimeoutSeconds
+	RoundTripMillis int
+
+	// UpstreamReadMillis overrides connection.upstream.readTimeoutSeconds
+	UpstreamReadMillis int
+
+	// UpstreamSocketMillis overrides connection.upstream.socketTimeoutSeconds for new upstream connections
+	UpstreamSocketMillis int
+}
+
+func millis(m int) time.Duration {
+	return time.Duration(m) * time.Millisecond
+}
+
+func (proxy *Proxy) routeTimeouts() *RouteTimeouts {
+	if proxy.Route == nil {
+		return nil
+	}
+	return proxy.Route.Timeouts
+}
+
+func (proxy *Proxy) downstreamRoundTripTimeout() time.Duration {
+	if t := proxy.routeTimeouts(); t != nil && t.RoundTripMillis > 0 {
+		return millis(t.RoundTripMillis)
+	}
+	return Runner.getDownstreamRoundTripTimeoutDuration()
+}
+
+func (proxy *Proxy) upstreamReadTimeout() time.Duration {
+	if t := proxy.routeTimeouts(); t != nil && t.UpstreamReadMillis > 0 {
+		return millis(t.UpstreamReadMillis)
+	}
+	return time.Duration(Runner.Connection.Upstream.ReadTimeoutSeconds) * time.Second
+}
+
+// upstreamContext carries the route socket timeout to the dialer of the http client.
+func (proxy *Proxy) upstreamContext(ctx context.Context) context.Context {
+	if t := proxy.routeTimeouts(); t != nil && t.UpstreamSocketMillis > 0 {
+		return context.WithValue(ctx, upstreamSocketTimeoutKey{}, millis(t.UpstreamSocketMillis))
+	}
+	return ctx
+}
+
+func upstreamSocketTimeout(ctx context.Context, d time.Duration) time.Duration {
+	if t, ok := ctx.Value(upstreamSocketTimeoutKey{}).(time.Duration); ok {
+		return t
+	}
+	return d
+}
+
+// applyRouteTimeouts restarts the downstream round trip timer and moves the write deadline once the route is known.
+// Both were set from connection defaults when the request was parsed.
+func (proxy *Proxy) applyRouteTimeouts() {
+	t := proxy.routeTimeouts()
+	if t == nil || t.RoundTripMillis == 0 {
+		return
+	}
+	d := millis(t.RoundTripMillis)
+	if proxy.Dwn.timer != nil {
+		proxy.Dwn.timer.Reset(d - time.Since(proxy.Dwn.startDate))
+	}
+	//writers that don't support deadlines i.e. in tests return an error we can ignore
+	_ = http.NewResponseController(proxy.Dwn.Resp.Writer).
+		SetWriteDeadline(proxy.Dwn.startDate.Add(d + routeWriteGrace))
+
+	infoOrTraceEv(proxy).
+		Str(XRequestID, proxy.XRequestID).
+		Int(dwnRoundTripTimeoutMillis, t.RoundTripMillis).
+		Int(upReadTimeoutMillis, int(proxy.upstreamReadTimeout().Milliseconds())).
+		Int(upSocketTimeoutMillis, t.UpstreamSocketMillis).
+		Msg(routeTimeoutsApplied)
+}
@@ -0,0 +1,84 @@
+package j8a
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteTimeoutsOverrideConnectionDefaults(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockProxy(nil, "0", "/path", "/path", "/get", "", "")
+
+	if got := proxy.downstreamRoundTripTimeout(); got != 120*time.Second {
+		t.Errorf("want connection round trip timeout, got %v", got)
+	}
+	if got := proxy.upstreamReadTimeout(); got != 120*time.Second {
+		t.Errorf("want connection upstream read timeout, got %v", got)
+	}
+
+	proxy.Route.Timeouts = &RouteTimeouts{RoundTripMillis: 1500, UpstreamReadMillis: 250}
+	if got := proxy.downstreamRoundTripTimeout(); got != 1500*time.Millisecond {
+		t.Errorf("want route round trip timeout, got %v", got)
+	}
+	if got := proxy.upstreamReadTimeout(); got != 250*time.Millisecond {
+		t.Errorf("want route upstream read timeout, got %v", got)
+	}
+}
+
+func TestUpstreamSocketTimeoutFromContext(t *testing.T) {
+	Runner = mockRuntime()
+	proxy := mockProxy(nil, "0", "/path", "/path", "/get", "", "")
+
+	if got := upstreamSocketTimeout(proxy.upstreamContext(context.TODO()), 3*time.Second); got != 3*time.Second {
+		t.Errorf("want connection socket timeout, got %v", got)
+	}
+	proxy.Route.Timeouts = &RouteTimeouts{UpstreamSocketMillis: 100}
+	if got := upstreamSocketTimeout(proxy.upstreamContext(context.TODO()), 3*time.Second); got != 100*time.Millisecond {
+		t.Errorf("want route socket timeout, got %v", got)
+	}
+}
+
+func TestApplyRouteTimeoutsRestartsRoundTripTimer(t *testing.T) {
+	Runner = mockRuntime()
+	req := httptest.NewRequest("GET", "/path", nil)
+	proxy := new(Proxy).
+		setOutgoing(httptest.NewRecorder()).
+		parseIncoming(req)
+	proxy.Route = &Route{Path: "/path", Timeouts: &RouteTimeouts{RoundTripMillis: 20}}
+	proxy.applyRouteTimeouts()
+
+	select {
+	case <-proxy.Dwn.Timeout:
+	case <-time.After(time.Second):
+		t.Errorf("want downstream timeout from route after 20ms, not connection default")
+	}
+}
+
+func TestRouteUpstreamReadTimeoutWithProxyHandler(t *testing.T) {
+	Runner = mockRuntime()
+	Runner.Connection.Upstream.MaxAttempts = 1
+	Runner.Routes[0].Timeouts = &RouteTimeouts{UpstreamReadMillis: 20}
+	httpClient = &MockHttp{}
+	mockDoFunc = func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+
+	server := httptest.NewServer(&ProxyHttpHandler{})
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 504 {
+		t.Errorf("want 504 for route upstream read timeout, got %d", resp.StatusCode)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("want route upstream read timeout, took %v", time.Since(start))
+	}
+}
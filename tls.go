@@ -77,11 +77,11 @@ func (r *Runtime) tlsHealthCheck(daemon bool) {
 	}()
 
 	//safety first
-	if r.ReloadableCert.Cert != nil {
+	if r.ReloadableCert.certificate() != nil {
 	Daemon:
 		for {
 			//Andeka is checking our certificate chains forever.
-			andeka, _ := checkFullCertChain(r.ReloadableCert.Cert)
+			andeka, _ := checkFullCertChain(r.ReloadableCert.certificate())
 			logCertStats(andeka)
 			if andeka[0].expiresTooCloseForComfort() {
 				r.renewAcmeCertAndKey()
@@ -102,22 +102,22 @@ func (r *Runtime) renewAcmeCertAndKey() error {
 	p := r.Connection.Downstream.Tls.Acme.Provider
 	log.Info().Msgf("triggering renewal of ACME certificate from provider %s ", p)
 
-	e1 := r.fetchAcmeCertAndKey(acmeProviders[p].endpoint)
+	c, k, e1 := r.fetchAcmeCertAndKey(r.Connection.Downstream.Tls.Acme.directoryUrl())
 	if e1 == nil {
-		c := []byte(r.Connection.Downstream.Tls.Cert)
-		k := []byte(r.Connection.Downstream.Tls.Key)
-
 		if newCerts, e2 := checkFullCertChainFromBytes(c, k); e2 != nil {
 			log.Warn().Msgf(acmeRetry24h, p, e2)
 			return e2
 		} else {
-			//if no issues, cache the cert and key. we don't assert whether this works it only matters when loading.
-			r.cacheAcmeCertAndKey(acmeProviders[p].endpoint)
-
-			//now trigger a re-init of TLS cert for the cert we just downloaded.
-			e3 := r.ReloadableCert.triggerInit()
+			//now swap in the cert we just downloaded, handshakes keep the previous one until it validated.
+			e3 := r.ReloadableCert.swap(c, k)
 			if e3 == nil {
+				//if no issues, cache the cert and key. we don't assert whether this works it only matters when loading.
+				//cached by provider name like on startup, a custom ACME directory has no known endpoint to key on.
+				r.cacheAcmeCertAndKey(p)
 				logCertStats(newCerts)
+				if !r.Connection.Downstream.Tls.DisableOcspStapling {
+					r.ReloadableCert.stapleOcsp()
+				}
 				log.Info().Msgf("successful renewal of ACME certificate from provider %s complete", p)
 			} else {
 				log.Warn().Msgf(acmeRetry24h, p, e3)
@@ -0,0 +1,86 @@
+package j8a
+
+import (
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const tlsFilesWatchInterval = time.Second * 10
+
+const tlsFilesUnchanged = "TLS certFile %s and keyFile %s changed but not reloaded, cause: %v. Keep serving previous certificate"
+
+func readTlsFiles(certFile string, keyFile string) ([]byte, []byte, error) {
+	cert, e1 := os.ReadFile(certFile)
+	if e1 != nil {
+		return nil, nil, e1
+	}
+	key, e2 := os.ReadFile(keyFile)
+	if e2 != nil {
+		return nil, nil, e2
+	}
+	return cert, key, nil
+}
+
+// watchTlsFiles polls certFile and keyFile for changes. Polling works with the symlink swaps used by
+// kubernetes secret mounts, where file system events are unreliable.
+func (r *Runtime) watchTlsFiles() {
+	if len(r.Connection.Downstream.Tls.CertFile) == 0 {
+		return
+	}
+
+	log.Info().Msgf("TLS certFile %s and keyFile %s watched for changes every %v", r.Connection.Downstream.Tls.CertFile,
+		r.Connection.Downstream.Tls.KeyFile, tlsFilesWatchInterval)
+	go func() {
+		for {
+			time.Sleep(tlsFilesWatchInterval)
+			r.reloadTlsFiles()
+		}
+	}()
+}
+
+// reloadTlsFiles swaps in the pair from certFile and keyFile if it changed and validates. A broken pair never
+// replaces the working certificate, we'll pick it up once fixed on the next change. Returns true on swap.
+func (r *Runtime) reloadTlsFiles() bool {
+	certFile := r.Connection.Downstream.Tls.CertFile
+	keyFile := r.Connection.Downstream.Tls.KeyFile
+
+	cert, key, e1 := readTlsFiles(certFile, keyFile)
+	if e1 != nil {
+		log.Warn().Msgf(tlsFilesUnchanged, certFile, keyFile, e1)
+		return false
+	}
+
+	oldCert, oldKey := r.ReloadableCert.keyPair()
+	if string(cert) == oldCert && string(key) == oldKey {
+		return false
+	}
+
+	pair := string(cert) + string(key)
+	if pair == r.tlsFilesRejected {
+		//already told you about this one.
+		return false
+	}
+
+	newCerts, e2 := checkFullCertChainFromBytes(cert, key)
+	if e2 != nil {
+		r.tlsFilesRejected = pair
+		log.Warn().Msgf(tlsFilesUnchanged, certFile, keyFile, e2)
+		return false
+	}
+
+	if e3 := r.ReloadableCert.swap(cert, key); e3 != nil {
+		r.tlsFilesRejected = pair
+		log.Warn().Msgf(tlsFilesUnchanged, certFile, keyFile, e3)
+		return false
+	}
+	r.tlsFilesRejected = ""
+
+	logCertStats(newCerts)
+	if !r.Connection.Downstream.Tls.DisableOcspStapling {
+		r.ReloadableCert.stapleOcsp()
+	}
+	log.Info().Msgf("TLS cert reloaded from certFile %s and keyFile %s", certFile, keyFile)
+	return true
+}
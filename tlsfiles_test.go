@@ -0,0 +1,158 @@
+package j8a
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mockTlsFilesRunner(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	_, cert, key := mockOcspChain(t, "http://localhost:1")
+	os.WriteFile(certFile, cert, 0600)
+	os.WriteFile(keyFile, key, 0600)
+
+	mockRunner()
+	Runner.Connection.Downstream.Tls.Port = 8443
+	Runner.Connection.Downstream.Tls.CertFile = certFile
+	Runner.Connection.Downstream.Tls.KeyFile = keyFile
+	Runner.Connection.Downstream.Tls.DisableOcspStapling = true
+	Runner.Config = *Runner.Config.loadTlsFiles()
+	if err := Runner.ReloadableCert.triggerInit(); err != nil {
+		t.Fatalf("unable to init certificate from files, cause: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestReloadTlsFilesUnchanged(t *testing.T) {
+	mockTlsFilesRunner(t)
+	if Runner.reloadTlsFiles() {
+		t.Errorf("unchanged TLS files should not have been reloaded")
+	}
+}
+
+func TestReloadTlsFilesSwapsValidPair(t *testing.T) {
+	certFile, keyFile := mockTlsFilesRunner(t)
+	before := Runner.ReloadableCert.Cert
+
+	_, cert, key := mockOcspChain(t, "http://localhost:1")
+	os.WriteFile(certFile, cert, 0600)
+	os.WriteFile(keyFile, key, 0600)
+
+	if !Runner.reloadTlsFiles() {
+		t.Fatalf("changed TLS files should have been reloaded")
+	}
+	if Runner.ReloadableCert.Cert == before {
+		t.Errorf("certificate should have been swapped")
+	}
+	if Runner.Connection.Downstream.Tls.Cert != string(cert) {
+		t.Errorf("runtime TLS cert should have been updated from certFile")
+	}
+}
+
+func TestReloadTlsFilesKeepsWorkingCertForBrokenPair(t *testing.T) {
+	certFile, keyFile := mockTlsFilesRunner(t)
+	before := Runner.ReloadableCert.Cert
+	oldCert := Runner.Connection.Downstream.Tls.Cert
+
+	//key from a different pair, i.e. cert-manager has written only one of the files so far.
+	_, cert, _ := mockOcspChain(t, "http://localhost:1")
+	os.WriteFile(certFile, cert, 0600)
+
+	if Runner.reloadTlsFiles() {
+		t.Errorf("mismatched TLS files should not have been reloaded")
+	}
+	if Runner.ReloadableCert.Cert != before || Runner.Connection.Downstream.Tls.Cert != oldCert {
+		t.Errorf("working certificate should have been kept for mismatched TLS files")
+	}
+
+	//now the matching key arrives
+	_, cert, key := mockOcspChain(t, "http://localhost:1")
+	os.WriteFile(certFile, cert, 0600)
+	os.WriteFile(keyFile, key, 0600)
+	if !Runner.reloadTlsFiles() {
+		t.Errorf("fixed TLS files should have been reloaded")
+	}
+}
+
+func TestReloadTlsFilesPublishesOnlyValidatedPairs(t *testing.T) {
+	certFile, keyFile := mockTlsFilesRunner(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			cert, key := Runner.ReloadableCert.keyPair()
+			if _, err := tls.X509KeyPair([]byte(cert), []byte(key)); err != nil {
+				t.Errorf("concurrent readers should only see validated TLS pairs, cause: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		//a cert without its key first, then the full pair.
+		_, cert, key := mockOcspChain(t, "http://localhost:1")
+		os.WriteFile(certFile, cert, 0600)
+		Runner.reloadTlsFiles()
+		os.WriteFile(keyFile, key, 0600)
+		Runner.reloadTlsFiles()
+	}
+	<-done
+}
+
+func TestReloadTlsFilesKeepsWorkingCertForMissingFile(t *testing.T) {
+	certFile, _ := mockTlsFilesRunner(t)
+	before := Runner.ReloadableCert.Cert
+
+	os.Remove(certFile)
+	if Runner.reloadTlsFiles() {
+		t.Errorf("missing TLS file should not have been reloaded")
+	}
+	if Runner.ReloadableCert.Cert != before {
+		t.Errorf("working certificate should have been kept for missing TLS file")
+	}
+}
+
+func TestLoadTlsFilesKeyFileMissingFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for certFile without keyFile")
+		}
+	}()
+
+	config := &Config{}
+	config.Connection.Downstream.Tls.CertFile = "/tmp/tls.crt"
+	config.loadTlsFiles()
+}
+
+func TestLoadTlsFilesWithCertFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for certFile and cert")
+		}
+	}()
+
+	config := &Config{}
+	config.Connection.Downstream.Tls.CertFile = "/tmp/tls.crt"
+	config.Connection.Downstream.Tls.KeyFile = "/tmp/tls.key"
+	config.Connection.Downstream.Tls.Cert = "cert"
+	config.loadTlsFiles()
+}
+
+func TestLoadTlsFilesUnreadableFails(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("config did not panic for unreadable certFile")
+		}
+	}()
+
+	config := &Config{}
+	config.Connection.Downstream.Tls.CertFile = "/not/there/tls.crt"
+	config.Connection.Downstream.Tls.KeyFile = "/not/there/tls.key"
+	config.loadTlsFiles()
+}
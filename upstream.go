@@ -10,10 +10,14 @@ type URL struct {
 	Scheme string
 	Host   string
 	Port   string
+	Path   string // directory of file resources
 }
 
 // String representation of our URL struct
 func (u URL) String() string {
+	if u.Scheme == fileScheme {
+		return u.Scheme + "://" + u.Path
+	}
 	return u.Scheme + "://" + u.Host + ":" + u.Port
 }
 
@@ -34,6 +38,9 @@ func (u *URL) UnmarshalJSON(data []byte) error {
 		if v["port"] != nil {
 			u.Port = fmt.Sprintf("%v", v["port"])
 		}
+		if v["path"] != nil {
+			u.Path = fmt.Sprintf("%v", v["path"])
+		}
 	default:
 		return fmt.Errorf("unexpected JSON value type: %T", value)
 	}
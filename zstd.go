@@ -0,0 +1,41 @@
+package j8a
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+const zstdLevel int = 1
+
+// zstd encoders and decoder are safe for concurrent use with EncodeAll and DecodeAll, so we share one per level.
+var zstdEncs = newZstdEncoders()
+var zstdDec, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+
+var zstdMagicBytes = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func newZstdEncoders() map[zstd.EncoderLevel]*zstd.Encoder {
+	encs := make(map[zstd.EncoderLevel]*zstd.Encoder)
+	for l := zstd.SpeedFastest; l <= zstd.SpeedBestCompression; l++ {
+		encs[l], _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(l))
+	}
+	return encs
+}
+
+// ZstdEncode encodes to zstd from byte array.
+func ZstdEncode(input []byte) *[]byte {
+	return ZstdEncodeLevel(input, zstdLevel)
+}
+
+// ZstdEncodeLevel encodes to zstd from byte array with compression level 1-22, mapped to the closest encoder speed.
+func ZstdEncodeLevel(input []byte, level int) *[]byte {
+	enc := zstdEncs[zstd.EncoderLevelFromZstd(level)].EncodeAll(input, make([]byte, 0, len(input)))
+	return &enc
+}
+
+// ZstdDecode decodes a []byte from zstd binary format
+func ZstdDecode(input []byte) (*[]byte, error) {
+	dec, err := zstdDec.DecodeAll(input, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &dec, nil
+}
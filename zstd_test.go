@@ -0,0 +1,55 @@
+package j8a
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestZstdEncoder(t *testing.T) {
+	json := []byte("{\"routes\": [{\n\t\t\t\"path\": \"/about\",\n\t\t\t\"resource\": \"aboutj8a\"\n\t\t},\n\t\t{\n\t\t\t\"path\": \"/customer\",\n\t\t\t\"resource\": \"customer\",\n\t\t\t\"policy\": \"ab\"\n\t\t}\n\t]}")
+	zs := *ZstdEncode(json)
+
+	if c := bytes.Compare(zs[0:4], zstdMagicBytes); c != 0 {
+		t.Errorf("zstd format not properly encoded, want %v, got %v", zstdMagicBytes, zs[0:4])
+	}
+	if len(zs) >= len(json) {
+		t.Errorf("zstd compression not working, encoded size %d not smaller than data size %d", len(zs), len(json))
+	}
+}
+
+func TestZstdDecoder(t *testing.T) {
+	for i := 0; i <= 100; i++ {
+		json := []byte(fmt.Sprintf(`{ "key":"value%d" }`, i))
+		if dec, err := ZstdDecode(*ZstdEncode(json)); err != nil || bytes.Compare(json, *dec) != 0 {
+			t.Error("zstd data is not equal to original")
+		}
+	}
+}
+
+func TestZstdDecodeCorrupt(t *testing.T) {
+	zs := *ZstdEncode([]byte(`{"key":"value"}`))
+	if _, err := ZstdDecode(zs[:len(zs)-2]); err == nil {
+		t.Errorf("truncated zstd data should return error")
+	}
+}
+
+func TestZstdEncodeThenZstdDecodeConcurrentIntegrity(t *testing.T) {
+	var wg sync.WaitGroup
+
+	for i := 0; i <= 10000; i++ {
+		json := []byte(fmt.Sprintf(`{ "key":"value %v" }`, rand.Float64()*float64(i)))
+		wg.Add(1)
+
+		go func() {
+			if dec, err := ZstdDecode(*ZstdEncode(json)); err != nil || bytes.Compare(json, *dec) != 0 {
+				t.Error("zstd decoded data is not equal to original")
+			}
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+}